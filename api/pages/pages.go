@@ -7,11 +7,15 @@ import (
 	"github.com/sokkalf/hubro/api"
 	"github.com/sokkalf/hubro/index"
 	"github.com/sokkalf/hubro/server"
+	"github.com/sokkalf/hubro/utils"
 )
 
-func pageIndex(h *server.Hubro, entries []index.IndexEntry) func(http.ResponseWriter, *http.Request) {
+func pageIndex(h *server.Hubro, idx *index.Index) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		slog.Info("Serving index")
+		entries := utils.Reject(func(entry index.IndexEntry) bool {
+			return entry.Scheduled
+		}, idx.GetEntries())
 		w.Header().Set("Content-Type", "text/html")
 		// Response for HTMX
 		h.RenderWithoutLayout(w, r, "api/index", entries)
@@ -23,11 +27,9 @@ func Register(prefix string, h *server.Hubro, mux *http.ServeMux, options any) {
 
 	slog.Info("Registering API", "prefix", prefix)
 	for i := range indices {
-		indices[i].RLock()
 		endpoint := "/" + indices[i].GetName() + "/index"
-		mux.HandleFunc("GET "+endpoint, pageIndex(h, indices[i].GetEntries()))
-		api.RegisterOptionsHandler(endpoint, mux)
+		mux.HandleFunc("GET "+endpoint, pageIndex(h, indices[i]))
+		api.RegisterOptionsHandler(h, endpoint, mux)
 		slog.Info("Registered endpoint", "endpoint", prefix+endpoint)
-		indices[i].RUnlock()
 	}
 }
@@ -0,0 +1,94 @@
+package search
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/sokkalf/hubro/api"
+	"github.com/sokkalf/hubro/fulltext"
+	"github.com/sokkalf/hubro/server"
+)
+
+const (
+	defaultLimit = 20
+	maxLimit     = 100
+)
+
+type result struct {
+	Index       string    `json:"index"`
+	Id          string    `json:"id"`
+	Slug        string    `json:"slug"`
+	Title       string    `json:"title"`
+	Path        string    `json:"path"`
+	Author      string    `json:"author"`
+	Description string    `json:"description"`
+	Tags        []string  `json:"tags"`
+	Date        time.Time `json:"date"`
+	Score       float64   `json:"score"`
+}
+
+type response struct {
+	Query   string   `json:"query"`
+	Total   int      `json:"total"`
+	Offset  int      `json:"offset"`
+	Limit   int      `json:"limit"`
+	Results []result `json:"results"`
+}
+
+func intParam(r *http.Request, name string, defaultVal int) int {
+	if v := r.URL.Query().Get(name); v != "" {
+		if parsed, err := strconv.Atoi(v); err == nil && parsed >= 0 {
+			return parsed
+		}
+	}
+	return defaultVal
+}
+
+func searchHandler(engine *fulltext.Engine) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query().Get("q")
+		limit := min(intParam(r, "limit", defaultLimit), maxLimit)
+		offset := intParam(r, "offset", 0)
+
+		matches := engine.Search(fulltext.ParseQuery(query))
+		resp := response{
+			Query:   query,
+			Total:   len(matches),
+			Offset:  offset,
+			Limit:   limit,
+			Results: []result{},
+		}
+		if offset < len(matches) {
+			for _, m := range matches[offset:min(offset+limit, len(matches))] {
+				resp.Results = append(resp.Results, result{
+					Index:       m.Index,
+					Id:          m.Entry.Id,
+					Slug:        m.Entry.Slug,
+					Title:       m.Entry.Title,
+					Path:        m.Entry.Path,
+					Author:      m.Entry.Author,
+					Description: m.Entry.Description,
+					Tags:        m.Entry.Tags,
+					Date:        m.Entry.Date,
+					Score:       m.Score,
+				})
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			slog.Error("Error encoding search results", "error", err)
+		}
+	}
+}
+
+func Register(prefix string, h *server.Hubro, mux *http.ServeMux, options any) {
+	engine := options.(*fulltext.Engine)
+
+	mux.HandleFunc("GET /", searchHandler(engine))
+	api.RegisterOptionsHandler(h, "/", mux)
+	slog.Info("Registered endpoint", "endpoint", prefix)
+}
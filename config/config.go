@@ -1,48 +1,110 @@
 package config
 
 import (
-	"log/slog"
+	"errors"
+	"fmt"
 	"net/url"
 	"os"
+	"os/exec"
+	"slices"
 	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
 
 	"go.opentelemetry.io/otel/trace"
 	"go.opentelemetry.io/otel/trace/noop"
 )
 
+// The configuration file is optional, it is read from defaultConfigFile if it exists
+const (
+	configFileEnv     = "HUBRO_CONFIG_FILE"
+	defaultConfigFile = "./hubro.yaml"
+)
+
 type HubroConfig struct {
-	BaseURL             string
-	Port                int
-	AuthorName          string
-	AuthorEmail         string
-	FeedsEnabled        bool
-	DisplayAuthorInFeed bool
-	Title               string
-	Description         string
-	RootPath            string
-	LegacyRoutesFile    string
-	BlogDir             string
-	PagesDir            string
-	UserStaticDir       string
-	LogoImage           string
-	UserCSS             bool
-	PostsPerPage        int
-	Version             string
-	Environment         string
-	GelfEndpoint        *string
-	SeqEndpoint         *string
-	SeqAPIKey           *string
-	AdminEnabled        bool
-	AdminPassword       string
-	Tracer              trace.Tracer
-}
-
-var Config *HubroConfig
-
-func Init() {
-	config := HubroConfig{
+	BaseURL             string        `yaml:"base_url"`
+	Port                int           `yaml:"port"`
+	AuthorName          string        `yaml:"author_name"`
+	AuthorEmail         string        `yaml:"author_email"`
+	FeedsEnabled        bool          `yaml:"feeds_enabled"`
+	DisplayAuthorInFeed bool          `yaml:"display_author_in_feed"`
+	Title               string        `yaml:"title"`
+	Description         string        `yaml:"description"`
+	RootPath            string        `yaml:"-"`
+	LegacyRoutesFile    string        `yaml:"legacy_routes_file"`
+	BlogDir             string        `yaml:"blog_dir"`
+	PagesDir            string        `yaml:"pages_dir"`
+	UserStaticDir       string        `yaml:"userfiles_dir"`
+	LogoImage           string        `yaml:"logo_image"`
+	UserCSS             bool          `yaml:"-"`
+	ThemeDir            string        `yaml:"theme"`
+	PostsPerPage        int           `yaml:"posts_per_page"`
+	PageCacheEnabled    bool          `yaml:"page_cache_enabled"`
+	PageCacheMaxEntries int           `yaml:"page_cache_max_entries"`
+	PageCacheMaxBytes   int64         `yaml:"page_cache_max_bytes"`
+	MediaMaxUploadBytes int64         `yaml:"media_max_upload_bytes"`
+	ImagesEnabled       bool          `yaml:"images_enabled"`
+	ImageWidths         []int         `yaml:"image_widths"`
+	ImageFormats        []string      `yaml:"image_formats"`
+	ImageQuality        int           `yaml:"image_quality"`
+	ImageCacheDir       string        `yaml:"image_cache_dir"`
+	ImagePregenerate    bool          `yaml:"image_pregenerate"`
+	ImageMaxPixels      int           `yaml:"image_max_pixels"`
+	ShutdownTimeout     time.Duration `yaml:"shutdown_timeout"`
+	TLSCertFile         string        `yaml:"tls_cert_file"`
+	TLSKeyFile          string        `yaml:"tls_key_file"`
+	ACMEEnabled         bool          `yaml:"acme_enabled"`
+	ACMEDirectoryURL    string        `yaml:"acme_directory_url"`
+	ACMEEmail           string        `yaml:"acme_email"`
+	ACMEHosts           []string      `yaml:"acme_hosts"`
+	ACMECacheDir        string        `yaml:"acme_cache_dir"`
+	ACMECAFile          string        `yaml:"acme_ca_file"`
+	HTTPRedirectPort    int           `yaml:"http_redirect_port"`
+	Version             string        `yaml:"-"`
+	Environment         string        `yaml:"environment"`
+	GelfEndpoint        *string       `yaml:"gelf_endpoint"`
+	SeqEndpoint         *string       `yaml:"seq_endpoint"`
+	SeqAPIKey           *string       `yaml:"seq_api_key"`
+	AdminEnabled        bool          `yaml:"admin_enabled"`
+	AdminPassword       string        `yaml:"admin_password"`
+	UsersFile           string        `yaml:"users_file"`
+	SessionSecret       string        `yaml:"session_secret"`
+	SessionTimeout      time.Duration `yaml:"session_timeout"`
+	OIDCIssuer          string        `yaml:"oidc_issuer"`
+	OIDCClientID        string        `yaml:"oidc_client_id"`
+	OIDCClientSecret    string        `yaml:"oidc_client_secret"`
+	OIDCAllowedEmails   []string      `yaml:"oidc_allowed_emails"`
+	OIDCAllowedGroups   []string      `yaml:"oidc_allowed_groups"`
+	OIDCGroupsClaim     string        `yaml:"oidc_groups_claim"`
+	GitEnabled          bool          `yaml:"git_enabled"`
+	WebhookSecret       string        `yaml:"webhook_secret"`
+	Tracer              trace.Tracer  `yaml:"-"`
+	Sites               []SiteConfig  `yaml:"sites"`
+
+	// The configuration of each site, derived from Sites
+	sites map[string]*HubroConfig
+}
+
+var current atomic.Pointer[HubroConfig]
+
+// Get returns the current configuration. It may be replaced by a reload at any
+// time, so call Get again rather than keeping the result around.
+func Get() *HubroConfig {
+	return current.Load()
+}
+
+// Update replaces the current configuration with a copy modified by f
+func Update(f func(c *HubroConfig)) {
+	c := *Get()
+	f(&c)
+	c.deriveSites()
+	current.Store(&c)
+}
+
+func defaults() HubroConfig {
+	return HubroConfig{
 		BaseURL:             "http://localhost:8080/",
-		Port:                8080,
 		AuthorName:          "Anonymous",
 		AuthorEmail:         "anonymous@example.org",
 		FeedsEnabled:        true,
@@ -53,107 +115,299 @@ func Init() {
 		BlogDir:             "./blog",
 		PagesDir:            "./pages",
 		UserStaticDir:       "./userfiles",
+		UsersFile:           "./users.yaml",
+		SessionTimeout:      12 * time.Hour,
+		OIDCGroupsClaim:     "groups",
 		LogoImage:           "logo.svg",
 		PostsPerPage:        10,
+		PageCacheMaxEntries: 1000,
+		PageCacheMaxBytes:   64 << 20,
+		MediaMaxUploadBytes: 10 << 20,
+		ImageWidths:         []int{320, 640, 1024, 1600},
+		ImageFormats:        []string{"original"},
+		ImageQuality:        80,
+		ImageCacheDir:       "./cache/images",
+		ImageMaxPixels:      40_000_000,
+		ShutdownTimeout:     10 * time.Second,
+		ACMECacheDir:        "./certs",
 		Version:             "0.0.1-dev",
 		Environment:         "development",
 		GelfEndpoint:        nil,
 		Tracer:              noop.NewTracerProvider().Tracer("hubro"),
 	}
+}
+
+// ConfigFile returns the configuration file to read, or "" if there is none
+func ConfigFile() string {
+	if file, ok := os.LookupEnv(configFileEnv); ok {
+		return file
+	}
+	if _, err := os.Stat(defaultConfigFile); err == nil {
+		return defaultConfigFile
+	}
+	return ""
+}
+
+// Init loads the configuration. If any setting is invalid, the error lists
+// all of them, and the configuration is kept as far as it could be read.
+func Init() error {
+	config, err := Load(ConfigFile())
+	current.Store(config)
+	return err
+}
 
-	if baseURL, ok := os.LookupEnv("HUBRO_BASE_URL"); ok {
-		config.BaseURL = baseURL
+// Load reads the configuration from the defaults, the configuration file if
+// file is not empty, and the environment, each overriding the one before.
+func Load(file string) (*HubroConfig, error) {
+	config := defaults()
+	errs := []error{}
+	if file != "" {
+		if err := loadFile(file, &config); err != nil {
+			errs = append(errs, err)
+		}
 	}
-	path, err := url.Parse(config.BaseURL)
-	if err != nil {
-		slog.Error("Error parsing base URL", "error", err, "url", config.BaseURL)
-	} else {
-		var port int
+	errs = append(errs, loadEnv(&config)...)
+	config.derive()
+	config.deriveSites()
+	errs = append(errs, config.Validate()...)
+	return &config, errors.Join(errs...)
+}
+
+// derive fills in the settings that follow from other settings
+func (config *HubroConfig) derive() {
+	if path, err := url.Parse(config.BaseURL); err == nil {
 		config.RootPath = path.Path
-		if path.Scheme == "http" && path.Port() == "" {
-			port = 80
-		} else if path.Scheme == "https" && path.Port() == "" {
-			port = 443
-		} else {
-			port, err = strconv.Atoi(path.Port())
-			if err != nil {
-				port = 8080
+		if config.Port == 0 {
+			if path.Scheme == "http" && path.Port() == "" {
+				config.Port = 80
+			} else if path.Scheme == "https" && path.Port() == "" {
+				config.Port = 443
+			} else if port, err := strconv.Atoi(path.Port()); err == nil {
+				config.Port = port
+			} else {
+				config.Port = 8080
 			}
 		}
-		config.Port = port
+		if len(config.ACMEHosts) == 0 {
+			config.ACMEHosts = append([]string{path.Hostname()}, config.siteHosts()...)
+		}
 	}
-	if port, ok := os.LookupEnv("HUBRO_PORT"); ok {
-		var err error
-		config.Port, err = strconv.Atoi(port)
-		if err != nil {
-			config.Port = 8080
+	fi, err := os.Stat(config.UserStaticDir + "/" + config.LogoImage)
+	if err != nil && fi == nil {
+		config.LogoImage = "" // no logo image found
+	}
+	fi, err = os.Stat(config.UserStaticDir + "/user.css")
+	if err != nil && fi == nil {
+		config.UserCSS = false
+	} else {
+		config.UserCSS = true
+	}
+}
+
+const redacted = "REDACTED"
+
+// Redacted returns a copy of the configuration with secrets replaced, safe for printing
+func (config HubroConfig) Redacted() HubroConfig {
+	if config.AdminPassword != "" {
+		config.AdminPassword = redacted
+	}
+	if config.WebhookSecret != "" {
+		config.WebhookSecret = redacted
+	}
+	if config.SessionSecret != "" {
+		config.SessionSecret = redacted
+	}
+	if config.OIDCClientSecret != "" {
+		config.OIDCClientSecret = redacted
+	}
+	if config.SeqAPIKey != nil {
+		key := redacted
+		config.SeqAPIKey = &key
+	}
+	sites := make([]SiteConfig, len(config.Sites))
+	for i, site := range config.Sites {
+		if site.AdminPassword != "" {
+			site.AdminPassword = redacted
 		}
+		sites[i] = site
+	}
+	config.Sites = sites
+	return config
+}
+
+// SettingError reports an invalid setting, named by its key in the
+// configuration file or its environment variable
+type SettingError struct {
+	Setting string
+	Message string
+}
+
+func (e *SettingError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Setting, e.Message)
+}
+
+func invalid(setting string, format string, args ...any) error {
+	return &SettingError{Setting: setting, Message: fmt.Sprintf(format, args...)}
+}
+
+func isDir(path string) bool {
+	fi, err := os.Stat(path)
+	return err == nil && fi.IsDir()
+}
+
+func isFile(path string) bool {
+	fi, err := os.Stat(path)
+	return err == nil && !fi.IsDir()
+}
+
+// Validate checks every setting and returns an error for each invalid one
+func (config *HubroConfig) Validate() []error {
+	errs := []error{}
+	if u, err := url.Parse(config.BaseURL); err != nil {
+		errs = append(errs, invalid("base_url", "%v", err))
+	} else if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		errs = append(errs, invalid("base_url", "must be an absolute http or https URL, got %q", config.BaseURL))
 	}
-	if authorName, ok := os.LookupEnv("HUBRO_AUTHOR_NAME"); ok {
-		config.AuthorName = authorName
+	if config.Port < 1 || config.Port > 65535 {
+		errs = append(errs, invalid("port", "must be between 1 and 65535, got %d", config.Port))
 	}
-	if authorEmail, ok := os.LookupEnv("HUBRO_AUTHOR_EMAIL"); ok {
-		config.AuthorEmail = authorEmail
+	if config.PostsPerPage < 1 {
+		errs = append(errs, invalid("posts_per_page", "must be at least 1, got %d", config.PostsPerPage))
 	}
-	if title, ok := os.LookupEnv("HUBRO_TITLE"); ok {
-		config.Title = title
+	if config.PageCacheMaxEntries < 1 {
+		errs = append(errs, invalid("page_cache_max_entries", "must be at least 1, got %d", config.PageCacheMaxEntries))
 	}
-	if description, ok := os.LookupEnv("HUBRO_DESCRIPTION"); ok {
-		config.Description = description
+	if config.PageCacheMaxBytes < 1 {
+		errs = append(errs, invalid("page_cache_max_bytes", "must be at least 1, got %d", config.PageCacheMaxBytes))
 	}
-	if displayAuthorInFeed, ok := os.LookupEnv("HUBRO_DISPLAY_AUTHOR_IN_FEED"); ok {
-		config.DisplayAuthorInFeed, _ = strconv.ParseBool(displayAuthorInFeed)
+	if config.MediaMaxUploadBytes < 1 {
+		errs = append(errs, invalid("media_max_upload_bytes", "must be at least 1, got %d", config.MediaMaxUploadBytes))
 	}
-	if feedsEnabled, ok := os.LookupEnv("HUBRO_FEEDS_ENABLED"); ok {
-		config.FeedsEnabled, _ = strconv.ParseBool(feedsEnabled)
+	errs = append(errs, config.validateImages()...)
+	if config.ShutdownTimeout < 0 {
+		errs = append(errs, invalid("shutdown_timeout", "must not be negative, got %s", config.ShutdownTimeout))
 	}
-	if legacyRoutesFile, ok := os.LookupEnv("HUBRO_LEGACY_ROUTES_FILE"); ok {
-		config.LegacyRoutesFile = legacyRoutesFile
+	if config.ThemeDir != "" && !isDir(config.ThemeDir) {
+		errs = append(errs, invalid("theme", "directory %q not found", config.ThemeDir))
+	}
+	// The admin password is only needed to log in until users are added, or
+	// if there is no single sign-on
+	if config.AdminEnabled && config.AdminPassword == "" && config.OIDCIssuer == "" {
+		if len(config.Sites) == 0 && !isFile(config.UsersFile) {
+			errs = append(errs, invalid("admin_password", "must be set when the admin interface is enabled and there is no users_file"))
+		}
+		for i, site := range config.Sites {
+			usersFile := config.UsersFile
+			override(&usersFile, site.UsersFile)
+			if site.AdminPassword == "" && !isFile(usersFile) {
+				errs = append(errs, invalid(fmt.Sprintf("sites[%d].admin_password", i),
+					"must be set when the admin interface is enabled and there is no main admin_password or users_file"))
+			}
+		}
 	}
-	if blogDir, ok := os.LookupEnv("HUBRO_BLOG_DIR"); ok {
-		config.BlogDir = blogDir
+	errs = append(errs, config.validateOIDC()...)
+	if config.SessionTimeout < time.Minute {
+		errs = append(errs, invalid("session_timeout", "must be at least 1m, got %s", config.SessionTimeout))
 	}
-	if pagesDir, ok := os.LookupEnv("HUBRO_PAGES_DIR"); ok {
-		config.PagesDir = pagesDir
+	if config.GitEnabled {
+		if _, err := exec.LookPath("git"); err != nil {
+			errs = append(errs, invalid("git_enabled", "git was not found in PATH"))
+		}
+	} else if config.WebhookSecret != "" {
+		errs = append(errs, invalid("webhook_secret", "the content webhook requires git_enabled"))
 	}
-	if environment, ok := os.LookupEnv("HUBRO_ENVIRONMENT"); ok {
-		config.Environment = environment
+	if config.SeqEndpoint != nil && config.SeqAPIKey == nil {
+		errs = append(errs, invalid("seq_api_key", "must be set when seq_endpoint is set"))
 	}
-	if adminEnabled, ok := os.LookupEnv("HUBRO_ADMIN_ENABLED"); ok {
-		config.AdminEnabled, _ = strconv.ParseBool(adminEnabled)
+	errs = append(errs, config.validateTLS()...)
+	errs = append(errs, config.validateSites()...)
+	return errs
+}
+
+// The formats the image pipeline can write, original keeps that of the image
+var imageFormats = []string{"original", "jpeg", "png"}
+
+func (config *HubroConfig) validateImages() []error {
+	errs := []error{}
+	if len(config.ImageWidths) == 0 {
+		errs = append(errs, invalid("image_widths", "must list at least one width"))
 	}
-	if adminPassword, ok := os.LookupEnv("HUBRO_ADMIN_PASSWORD"); ok {
-		config.AdminPassword = adminPassword
-	} else {
-		if config.AdminEnabled {
-			config.AdminEnabled = false
-			slog.Warn("Admin interface disabled, no password set")
+	for _, width := range config.ImageWidths {
+		if width < 1 || width > 10000 {
+			errs = append(errs, invalid("image_widths", "must be between 1 and 10000, got %d", width))
 		}
 	}
-	if gelfEndpoint, ok := os.LookupEnv("HUBRO_GELF_ENDPOINT"); ok {
-		config.GelfEndpoint = &gelfEndpoint
+	if len(config.ImageFormats) == 0 {
+		errs = append(errs, invalid("image_formats", "must list at least one format"))
 	}
-	if seqEndpoint, ok := os.LookupEnv("HUBRO_SEQ_ENDPOINT"); ok {
-		config.SeqEndpoint = &seqEndpoint
+	for _, format := range config.ImageFormats {
+		if !slices.Contains(imageFormats, format) {
+			errs = append(errs, invalid("image_formats", "must be one of %s, got %q", strings.Join(imageFormats, ", "), format))
+		}
 	}
-	if seqAPIKey, ok := os.LookupEnv("HUBRO_SEQ_API_KEY"); ok {
-		config.SeqAPIKey = &seqAPIKey
+	if config.ImageQuality < 1 || config.ImageQuality > 100 {
+		errs = append(errs, invalid("image_quality", "must be between 1 and 100, got %d", config.ImageQuality))
 	}
-	if userStaticDir, ok := os.LookupEnv("HUBRO_USERFILES_DIR"); ok {
-		config.UserStaticDir = userStaticDir
+	if config.ImageMaxPixels < 1 {
+		errs = append(errs, invalid("image_max_pixels", "must be at least 1, got %d", config.ImageMaxPixels))
 	}
-	if logoImage, ok := os.LookupEnv("HUBRO_LOGO_IMAGE"); ok {
-		config.LogoImage = logoImage
+	return errs
+}
+
+func (config *HubroConfig) validateOIDC() []error {
+	errs := []error{}
+	if config.OIDCIssuer == "" {
+		return errs
 	}
-	fi, err := os.Stat(config.UserStaticDir + "/" + config.LogoImage)
-	if err != nil && fi == nil {
-		config.LogoImage = "" // no logo image found
+	if u, err := url.Parse(config.OIDCIssuer); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		errs = append(errs, invalid("oidc_issuer", "must be an absolute http or https URL, got %q", config.OIDCIssuer))
 	}
-	fi, err = os.Stat(config.UserStaticDir + "/user.css")
-	if err != nil && fi == nil {
-		config.UserCSS = false
-	} else {
-		config.UserCSS = true
+	if config.OIDCClientID == "" {
+		errs = append(errs, invalid("oidc_client_id", "must be set when oidc_issuer is set"))
+	}
+	if len(config.OIDCAllowedEmails) == 0 && len(config.OIDCAllowedGroups) == 0 && !isFile(config.UsersFile) {
+		errs = append(errs, invalid("oidc_allowed_emails",
+			"set oidc_allowed_emails or oidc_allowed_groups, or add users with their email address to users_file"))
+	}
+	return errs
+}
+
+func (config *HubroConfig) validateTLS() []error {
+	errs := []error{}
+	if config.TLSCertFile != "" || config.TLSKeyFile != "" {
+		if config.ACMEEnabled {
+			errs = append(errs, invalid("tls_cert_file", "can't be used together with acme_enabled"))
+		}
+		if config.TLSCertFile == "" || config.TLSKeyFile == "" {
+			errs = append(errs, invalid("tls_key_file", "tls_cert_file and tls_key_file must be set together"))
+		}
+		if config.TLSCertFile != "" && !isFile(config.TLSCertFile) {
+			errs = append(errs, invalid("tls_cert_file", "file %q not found", config.TLSCertFile))
+		}
+		if config.TLSKeyFile != "" && !isFile(config.TLSKeyFile) {
+			errs = append(errs, invalid("tls_key_file", "file %q not found", config.TLSKeyFile))
+		}
+	}
+	if config.ACMEEnabled {
+		for _, host := range config.ACMEHosts {
+			if strings.TrimSpace(host) == "" {
+				errs = append(errs, invalid("acme_hosts", "must not contain empty host names"))
+				break
+			}
+		}
+		if config.ACMECAFile != "" && !isFile(config.ACMECAFile) {
+			errs = append(errs, invalid("acme_ca_file", "file %q not found", config.ACMECAFile))
+		}
+		if config.ACMEDirectoryURL != "" {
+			if u, err := url.Parse(config.ACMEDirectoryURL); err != nil || u.Scheme != "https" {
+				errs = append(errs, invalid("acme_directory_url", "must be an https URL, got %q", config.ACMEDirectoryURL))
+			}
+		}
+	}
+	if config.HTTPRedirectPort < 0 || config.HTTPRedirectPort > 65535 {
+		errs = append(errs, invalid("http_redirect_port", "must be between 0 and 65535, got %d", config.HTTPRedirectPort))
+	} else if config.HTTPRedirectPort != 0 && config.HTTPRedirectPort == config.Port {
+		errs = append(errs, invalid("http_redirect_port", "must differ from port %d", config.Port))
 	}
-	Config = &config
+	return errs
 }
@@ -0,0 +1,152 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestLoadLayering checks that the file overrides the defaults, and the environment overrides the file.
+func TestLoadLayering(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "hubro.yaml")
+	content := "title: From file\ndescription: From file\nbase_url: https://example.org/blog/\nshutdown_timeout: 3s\n"
+	if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("HUBRO_TITLE", "From env")
+
+	c, err := Load(file)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if c.Title != "From env" || c.Description != "From file" || c.AuthorName != "Anonymous" {
+		t.Errorf("unexpected layering: title %q, description %q, author %q", c.Title, c.Description, c.AuthorName)
+	}
+	if c.Port != 443 || c.RootPath != "/blog/" || c.ShutdownTimeout != 3*time.Second {
+		t.Errorf("unexpected derived settings: port %d, root path %q, timeout %s", c.Port, c.RootPath, c.ShutdownTimeout)
+	}
+}
+
+// TestLoadReportsAllErrors checks that every invalid setting is reported, not just the first.
+func TestLoadReportsAllErrors(t *testing.T) {
+	t.Setenv("HUBRO_PORT", "eighty")
+	t.Setenv("HUBRO_FEEDS_ENABLED", "yes")
+	t.Setenv("HUBRO_POSTS_PER_PAGE", "0")
+	t.Setenv("HUBRO_ADMIN_ENABLED", "true")
+	t.Setenv("HUBRO_IMAGE_WIDTHS", "320,wide")
+	t.Setenv("HUBRO_IMAGE_FORMATS", "jpeg,webp")
+
+	_, err := Load("")
+	var joined interface{ Unwrap() []error }
+	if !errors.As(err, &joined) {
+		t.Fatalf("expected joined errors, got %v", err)
+	}
+	settings := map[string]bool{}
+	for _, e := range joined.Unwrap() {
+		var settingErr *SettingError
+		if errors.As(e, &settingErr) {
+			settings[settingErr.Setting] = true
+		}
+	}
+	for _, setting := range []string{"HUBRO_PORT", "HUBRO_FEEDS_ENABLED", "posts_per_page", "admin_password", "HUBRO_IMAGE_WIDTHS", "image_formats"} {
+		if !settings[setting] {
+			t.Errorf("expected an error for %s, got %v", setting, err)
+		}
+	}
+}
+
+// TestReloadKeepsRestartSettings checks that a reload applies live settings, and
+// keeps and reports the ones that need a restart.
+func TestReloadKeepsRestartSettings(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "hubro.yaml")
+	t.Setenv("HUBRO_CONFIG_FILE", file)
+	if err := os.WriteFile(file, []byte("title: Before\nport: 8080\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := Init(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := os.WriteFile(file, []byte("title: After\nport: 9090\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	needsRestart, err := Reload()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if Get().Title != "After" || Get().Port != 8080 {
+		t.Errorf("expected new title and old port, got %q and %d", Get().Title, Get().Port)
+	}
+	if len(needsRestart) != 1 || needsRestart[0] != "port" {
+		t.Errorf("expected port to need a restart, got %v", needsRestart)
+	}
+
+	if err := os.WriteFile(file, []byte("posts_per_page: 0\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Reload(); err == nil || Get().Title != "After" {
+		t.Errorf("expected invalid configuration to be rejected, got %v", err)
+	}
+}
+
+// TestSitesInheritMainConfig checks that sites override the main configuration,
+// and inherit what they don't set.
+func TestSitesInheritMainConfig(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "hubro.yaml")
+	content := `title: Main
+author_name: Main Author
+posts_per_page: 5
+sites:
+  - name: one
+    base_url: https://one.example.org/
+    title: One
+  - name: two
+    base_url: https://two.example.org/blog/
+    author_name: Two Author
+`
+	if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := Load(file)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	one, two := c.Site("one"), c.Site("two")
+	if one.Title != "One" || one.AuthorName != "Main Author" || one.PostsPerPage != 5 {
+		t.Errorf("unexpected site one: title %q, author %q, posts per page %d", one.Title, one.AuthorName, one.PostsPerPage)
+	}
+	if two.Title != "Main" || two.AuthorName != "Two Author" || two.RootPath != "/blog/" {
+		t.Errorf("unexpected site two: title %q, author %q, root path %q", two.Title, two.AuthorName, two.RootPath)
+	}
+	if one.ImageCacheDir != filepath.Join("cache", "images", "one") {
+		t.Errorf("expected site one to cache images in its own directory, got %q", one.ImageCacheDir)
+	}
+	if c.Site("unknown") != c {
+		t.Errorf("expected the main configuration for an unknown site")
+	}
+}
+
+// TestSitesValidation checks that sites need unique names and hosts.
+func TestSitesValidation(t *testing.T) {
+	c := defaults()
+	c.Sites = []SiteConfig{
+		{Name: "one", BaseURL: "https://example.org/"},
+		{Name: "one", BaseURL: "https://EXAMPLE.org:8443/"},
+		{Name: "three", BaseURL: "/relative"},
+	}
+	settings := map[string]bool{}
+	for _, e := range c.validateSites() {
+		var settingErr *SettingError
+		if errors.As(e, &settingErr) {
+			settings[settingErr.Setting] = true
+		}
+	}
+	for _, setting := range []string{"sites[1].name", "sites[1].base_url", "sites[2].base_url"} {
+		if !settings[setting] {
+			t.Errorf("expected an error for %s, got %v", setting, settings)
+		}
+	}
+}
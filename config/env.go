@@ -0,0 +1,154 @@
+package config
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// envLoader reads settings from environment variables, collecting an error
+// for every value that can't be parsed instead of ignoring it
+type envLoader struct {
+	errs []error
+}
+
+func (l *envLoader) string(name string, dst *string) {
+	if value, ok := os.LookupEnv(name); ok {
+		*dst = value
+	}
+}
+
+func (l *envLoader) optionalString(name string, dst **string) {
+	if value, ok := os.LookupEnv(name); ok {
+		*dst = &value
+	}
+}
+
+func (l *envLoader) bool(name string, dst *bool) {
+	if value, ok := os.LookupEnv(name); ok {
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			l.errs = append(l.errs, invalid(name, "must be true or false, got %q", value))
+			return
+		}
+		*dst = b
+	}
+}
+
+func (l *envLoader) int(name string, dst *int) {
+	if value, ok := os.LookupEnv(name); ok {
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			l.errs = append(l.errs, invalid(name, "must be an integer, got %q", value))
+			return
+		}
+		*dst = n
+	}
+}
+
+func (l *envLoader) int64(name string, dst *int64) {
+	if value, ok := os.LookupEnv(name); ok {
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			l.errs = append(l.errs, invalid(name, "must be an integer, got %q", value))
+			return
+		}
+		*dst = n
+	}
+}
+
+func (l *envLoader) duration(name string, dst *time.Duration) {
+	if value, ok := os.LookupEnv(name); ok {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			l.errs = append(l.errs, invalid(name, "must be a duration such as 10s, got %q", value))
+			return
+		}
+		*dst = d
+	}
+}
+
+func (l *envLoader) list(name string, dst *[]string) {
+	if value, ok := os.LookupEnv(name); ok {
+		items := []string{}
+		for item := range strings.SplitSeq(value, ",") {
+			items = append(items, strings.TrimSpace(item))
+		}
+		*dst = items
+	}
+}
+
+func (l *envLoader) intList(name string, dst *[]int) {
+	if value, ok := os.LookupEnv(name); ok {
+		items := []int{}
+		for item := range strings.SplitSeq(value, ",") {
+			n, err := strconv.Atoi(strings.TrimSpace(item))
+			if err != nil {
+				l.errs = append(l.errs, invalid(name, "must be a comma separated list of integers, got %q", value))
+				return
+			}
+			items = append(items, n)
+		}
+		*dst = items
+	}
+}
+
+// loadEnv overrides config with the HUBRO_* environment variables
+func loadEnv(config *HubroConfig) []error {
+	l := &envLoader{}
+	l.string("HUBRO_BASE_URL", &config.BaseURL)
+	l.int("HUBRO_PORT", &config.Port)
+	l.string("HUBRO_AUTHOR_NAME", &config.AuthorName)
+	l.string("HUBRO_AUTHOR_EMAIL", &config.AuthorEmail)
+	l.string("HUBRO_TITLE", &config.Title)
+	l.string("HUBRO_DESCRIPTION", &config.Description)
+	l.bool("HUBRO_DISPLAY_AUTHOR_IN_FEED", &config.DisplayAuthorInFeed)
+	l.bool("HUBRO_FEEDS_ENABLED", &config.FeedsEnabled)
+	l.string("HUBRO_LEGACY_ROUTES_FILE", &config.LegacyRoutesFile)
+	l.string("HUBRO_BLOG_DIR", &config.BlogDir)
+	l.string("HUBRO_PAGES_DIR", &config.PagesDir)
+	l.string("HUBRO_ENVIRONMENT", &config.Environment)
+	l.bool("HUBRO_ADMIN_ENABLED", &config.AdminEnabled)
+	l.string("HUBRO_ADMIN_PASSWORD", &config.AdminPassword)
+	l.string("HUBRO_USERS_FILE", &config.UsersFile)
+	l.string("HUBRO_SESSION_SECRET", &config.SessionSecret)
+	l.duration("HUBRO_SESSION_TIMEOUT", &config.SessionTimeout)
+	l.string("HUBRO_OIDC_ISSUER", &config.OIDCIssuer)
+	l.string("HUBRO_OIDC_CLIENT_ID", &config.OIDCClientID)
+	l.string("HUBRO_OIDC_CLIENT_SECRET", &config.OIDCClientSecret)
+	l.list("HUBRO_OIDC_ALLOWED_EMAILS", &config.OIDCAllowedEmails)
+	l.list("HUBRO_OIDC_ALLOWED_GROUPS", &config.OIDCAllowedGroups)
+	l.string("HUBRO_OIDC_GROUPS_CLAIM", &config.OIDCGroupsClaim)
+	l.bool("HUBRO_GIT_ENABLED", &config.GitEnabled)
+	l.string("HUBRO_WEBHOOK_SECRET", &config.WebhookSecret)
+	l.int("HUBRO_POSTS_PER_PAGE", &config.PostsPerPage)
+	l.bool("HUBRO_PAGE_CACHE_ENABLED", &config.PageCacheEnabled)
+	l.int("HUBRO_PAGE_CACHE_MAX_ENTRIES", &config.PageCacheMaxEntries)
+	l.int64("HUBRO_PAGE_CACHE_MAX_BYTES", &config.PageCacheMaxBytes)
+	l.int64("HUBRO_MEDIA_MAX_UPLOAD_BYTES", &config.MediaMaxUploadBytes)
+	l.bool("HUBRO_IMAGES_ENABLED", &config.ImagesEnabled)
+	l.intList("HUBRO_IMAGE_WIDTHS", &config.ImageWidths)
+	l.list("HUBRO_IMAGE_FORMATS", &config.ImageFormats)
+	l.int("HUBRO_IMAGE_QUALITY", &config.ImageQuality)
+	l.string("HUBRO_IMAGE_CACHE_DIR", &config.ImageCacheDir)
+	l.bool("HUBRO_IMAGE_PREGENERATE", &config.ImagePregenerate)
+	l.int("HUBRO_IMAGE_MAX_PIXELS", &config.ImageMaxPixels)
+	l.duration("HUBRO_SHUTDOWN_TIMEOUT", &config.ShutdownTimeout)
+	l.string("HUBRO_TLS_CERT_FILE", &config.TLSCertFile)
+	l.string("HUBRO_TLS_KEY_FILE", &config.TLSKeyFile)
+	l.bool("HUBRO_ACME_ENABLED", &config.ACMEEnabled)
+	l.string("HUBRO_ACME_DIRECTORY_URL", &config.ACMEDirectoryURL)
+	l.string("HUBRO_ACME_EMAIL", &config.ACMEEmail)
+	l.list("HUBRO_ACME_HOSTS", &config.ACMEHosts)
+	l.string("HUBRO_ACME_CACHE_DIR", &config.ACMECacheDir)
+	l.string("HUBRO_ACME_CA_FILE", &config.ACMECAFile)
+	l.int("HUBRO_HTTP_REDIRECT_PORT", &config.HTTPRedirectPort)
+	l.optionalString("HUBRO_GELF_ENDPOINT", &config.GelfEndpoint)
+	l.optionalString("HUBRO_SEQ_ENDPOINT", &config.SeqEndpoint)
+	l.optionalString("HUBRO_SEQ_API_KEY", &config.SeqAPIKey)
+	l.string("HUBRO_USERFILES_DIR", &config.UserStaticDir)
+	l.string("HUBRO_THEME", &config.ThemeDir)
+	l.string("HUBRO_LOGO_IMAGE", &config.LogoImage)
+	return l.errs
+}
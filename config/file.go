@@ -0,0 +1,21 @@
+package config
+
+import (
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v2"
+)
+
+// loadFile overrides config with the settings in a YAML file. Unknown keys
+// are reported, since they are most likely misspelled settings.
+func loadFile(file string, config *HubroConfig) error {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return fmt.Errorf("reading configuration file: %w", err)
+	}
+	if err := yaml.UnmarshalStrict(data, config); err != nil {
+		return fmt.Errorf("parsing configuration file %s: %w", file, err)
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+)
+
+// Settings that are only read at startup, a reload keeps their current values
+var restartRequired = []string{
+	"BaseURL", "Port", "FeedsEnabled", "LegacyRoutesFile", "BlogDir", "PagesDir", "UserStaticDir", "ThemeDir",
+	"PageCacheEnabled", "PageCacheMaxEntries", "PageCacheMaxBytes",
+	"TLSCertFile", "TLSKeyFile", "ACMEEnabled", "ACMEDirectoryURL", "ACMEEmail", "ACMEHosts", "ACMECacheDir",
+	"ACMECAFile", "HTTPRedirectPort", "Environment", "GelfEndpoint", "SeqEndpoint", "SeqAPIKey", "AdminEnabled",
+	"GitEnabled", "WebhookSecret", "ImagesEnabled", "ImageWidths", "ImageFormats", "ImageQuality", "ImageCacheDir",
+	"ImagePregenerate", "ImageMaxPixels",
+}
+
+// keepFields copies the named fields from old to new if they differ, and
+// returns the YAML keys of those that did, prefixed by prefix
+func keepFields(old any, new any, names []string, prefix string) []string {
+	changed := []string{}
+	oldValue := reflect.ValueOf(old).Elem()
+	newValue := reflect.ValueOf(new).Elem()
+	for _, name := range names {
+		if !reflect.DeepEqual(oldValue.FieldByName(name).Interface(), newValue.FieldByName(name).Interface()) {
+			field, _ := newValue.Type().FieldByName(name)
+			key, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+			changed = append(changed, prefix+key)
+			newValue.FieldByName(name).Set(oldValue.FieldByName(name))
+		}
+	}
+	return changed
+}
+
+// keepSites keeps the sites that are running, since sites can't be added or
+// removed without a restart, and returns what changed that needs a restart
+func keepSites(old *HubroConfig, config *HubroConfig) []string {
+	changed := []string{}
+	newSites := map[string]SiteConfig{}
+	for _, site := range config.Sites {
+		newSites[site.Name] = site
+	}
+	sites := make([]SiteConfig, 0, len(old.Sites))
+	for _, oldSite := range old.Sites {
+		site, ok := newSites[oldSite.Name]
+		if !ok {
+			changed = append(changed, "sites."+oldSite.Name)
+			site = oldSite
+		}
+		changed = append(changed, keepFields(&oldSite, &site, siteRestartRequired, "sites."+site.Name+".")...)
+		sites = append(sites, site)
+		delete(newSites, site.Name)
+	}
+	for name := range newSites {
+		changed = append(changed, "sites."+name)
+	}
+	config.Sites = sites
+	return changed
+}
+
+// Reload loads the configuration again and swaps it in. Changed settings that
+// need a restart to take effect keep their current values, and their keys are
+// returned. If the new configuration is invalid, the current one is kept.
+func Reload() (needsRestart []string, err error) {
+	config, err := Load(ConfigFile())
+	if err != nil {
+		return nil, err
+	}
+	old := Get()
+	config.RootPath = old.RootPath
+	config.Version = old.Version
+	config.Tracer = old.Tracer
+
+	needsRestart = keepFields(old, config, restartRequired, "")
+	needsRestart = append(needsRestart, keepSites(old, config)...)
+	config.deriveSites()
+	current.Store(config)
+	return needsRestart, nil
+}
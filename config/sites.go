@@ -0,0 +1,127 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"strings"
+)
+
+// SiteConfig overrides settings for one site, when several sites are hosted
+// by one process. Settings left empty are inherited from the main configuration.
+type SiteConfig struct {
+	Name             string `yaml:"name"`
+	BaseURL          string `yaml:"base_url"`
+	Title            string `yaml:"title,omitempty"`
+	Description      string `yaml:"description,omitempty"`
+	AuthorName       string `yaml:"author_name,omitempty"`
+	AuthorEmail      string `yaml:"author_email,omitempty"`
+	BlogDir          string `yaml:"blog_dir,omitempty"`
+	PagesDir         string `yaml:"pages_dir,omitempty"`
+	UserStaticDir    string `yaml:"userfiles_dir,omitempty"`
+	ImageCacheDir    string `yaml:"image_cache_dir,omitempty"`
+	LogoImage        string `yaml:"logo_image,omitempty"`
+	ThemeDir         string `yaml:"theme,omitempty"`
+	LegacyRoutesFile string `yaml:"legacy_routes_file,omitempty"`
+	AdminPassword    string `yaml:"admin_password,omitempty"`
+	UsersFile        string `yaml:"users_file,omitempty"`
+}
+
+// Settings of a site that are only read at startup
+var siteRestartRequired = []string{"BaseURL", "BlogDir", "PagesDir", "UserStaticDir", "ImageCacheDir", "ThemeDir", "LegacyRoutesFile"}
+
+// SiteNames returns the names of the configured sites, in order. It is empty
+// if only one site is hosted.
+func (config *HubroConfig) SiteNames() []string {
+	names := make([]string, len(config.Sites))
+	for i, site := range config.Sites {
+		names[i] = site.Name
+	}
+	return names
+}
+
+// Site returns the configuration of the named site, or the main
+// configuration if name is empty or not a configured site
+func (config *HubroConfig) Site(name string) *HubroConfig {
+	if site, ok := config.sites[name]; ok {
+		return site
+	}
+	return config
+}
+
+func override(dst *string, value string) {
+	if value != "" {
+		*dst = value
+	}
+}
+
+// deriveSites builds the configuration of every site from the main configuration
+func (config *HubroConfig) deriveSites() {
+	config.sites = make(map[string]*HubroConfig, len(config.Sites))
+	for _, site := range config.Sites {
+		c := *config
+		c.Sites = nil
+		c.sites = nil
+		c.ACMEHosts = nil
+		c.Port = config.Port
+		override(&c.BaseURL, site.BaseURL)
+		override(&c.Title, site.Title)
+		override(&c.Description, site.Description)
+		override(&c.AuthorName, site.AuthorName)
+		override(&c.AuthorEmail, site.AuthorEmail)
+		override(&c.BlogDir, site.BlogDir)
+		override(&c.PagesDir, site.PagesDir)
+		override(&c.UserStaticDir, site.UserStaticDir)
+		// Sites share the main image cache directory, each in its own subdirectory
+		c.ImageCacheDir = filepath.Join(config.ImageCacheDir, site.Name)
+		override(&c.ImageCacheDir, site.ImageCacheDir)
+		override(&c.LogoImage, site.LogoImage)
+		override(&c.ThemeDir, site.ThemeDir)
+		override(&c.LegacyRoutesFile, site.LegacyRoutesFile)
+		override(&c.AdminPassword, site.AdminPassword)
+		override(&c.UsersFile, site.UsersFile)
+		c.derive()
+		c.ACMEHosts = config.ACMEHosts
+		config.sites[site.Name] = &c
+	}
+}
+
+// siteHosts returns the host names of all sites
+func (config *HubroConfig) siteHosts() []string {
+	hosts := []string{}
+	for _, site := range config.Sites {
+		if u, err := url.Parse(site.BaseURL); err == nil && u.Hostname() != "" {
+			hosts = append(hosts, u.Hostname())
+		}
+	}
+	return hosts
+}
+
+func (config *HubroConfig) validateSites() []error {
+	errs := []error{}
+	names := map[string]bool{}
+	hosts := map[string]bool{}
+	for i, site := range config.Sites {
+		setting := fmt.Sprintf("sites[%d]", i)
+		if site.Name == "" {
+			errs = append(errs, invalid(setting+".name", "must be set"))
+		} else if names[site.Name] {
+			errs = append(errs, invalid(setting+".name", "site %q is defined more than once", site.Name))
+		}
+		names[site.Name] = true
+		u, err := url.Parse(site.BaseURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, invalid(setting+".base_url", "must be an absolute http or https URL, got %q", site.BaseURL))
+			continue
+		}
+		host := strings.ToLower(u.Hostname())
+		if hosts[host] {
+			errs = append(errs, invalid(setting+".base_url", "host %q is used by more than one site", host))
+		}
+		hosts[host] = true
+		if site.ThemeDir != "" && !isDir(site.ThemeDir) {
+			errs = append(errs, invalid(setting+".theme", "directory %q not found", site.ThemeDir))
+		}
+	}
+	return errs
+}
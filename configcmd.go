@@ -0,0 +1,70 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/sokkalf/hubro/config"
+	"gopkg.in/yaml.v2"
+)
+
+// initConfig loads the configuration, and prints every invalid setting if it can't
+func initConfig() bool {
+	err := config.Init()
+	config.Update(func(c *config.HubroConfig) { c.Version = Version })
+	if err != nil {
+		printConfigErrors(err)
+		return false
+	}
+	return true
+}
+
+func printConfigErrors(err error) {
+	fmt.Fprintln(os.Stderr, "Invalid configuration:")
+	var joined interface{ Unwrap() []error }
+	if errors.As(err, &joined) {
+		for _, e := range joined.Unwrap() {
+			fmt.Fprintf(os.Stderr, "  %v\n", e)
+		}
+	} else {
+		fmt.Fprintf(os.Stderr, "  %v\n", err)
+	}
+}
+
+// runConfig implements the config subcommand
+func runConfig(args []string) int {
+	usage := func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s config check [-f file]\n", os.Args[0])
+	}
+	if len(args) == 0 || args[0] != "check" {
+		usage()
+		return 2
+	}
+	flags := flag.NewFlagSet("config check", flag.ExitOnError)
+	file := flags.String("f", config.ConfigFile(), "configuration file (default: HUBRO_CONFIG_FILE or ./hubro.yaml)")
+	flags.Usage = func() {
+		usage()
+		flags.PrintDefaults()
+	}
+	flags.Parse(args[1:])
+
+	c, err := config.Load(*file)
+	if *file != "" {
+		fmt.Printf("# Configuration file: %s\n", *file)
+	} else {
+		fmt.Println("# No configuration file, using defaults and environment variables")
+	}
+	out, marshalErr := yaml.Marshal(c.Redacted())
+	if marshalErr != nil {
+		fmt.Fprintf(os.Stderr, "Error printing configuration: %v\n", marshalErr)
+		return 1
+	}
+	os.Stdout.Write(out)
+	if err != nil {
+		printConfigErrors(err)
+		return 1
+	}
+	return 0
+}
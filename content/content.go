@@ -0,0 +1,46 @@
+// Package content stores the markdown files behind an index
+package content
+
+import (
+	"context"
+	"io/fs"
+	"path"
+	"strings"
+	"time"
+)
+
+// File describes a file in a content store. Name is slash separated and
+// relative to the root of the store.
+type File struct {
+	Name    string
+	Size    int64
+	ModTime time.Time
+}
+
+// ContentStore is where the files of an index are read from and written to
+type ContentStore interface {
+	// List returns all files in the store, including those in subdirectories
+	List() ([]File, error)
+	// Stat returns the file with the given name, or an error wrapping
+	// fs.ErrNotExist if there is none
+	Stat(name string) (File, error)
+	Read(name string) ([]byte, error)
+	// Write creates or replaces a file
+	Write(name string, data []byte) error
+	Delete(name string) error
+	// Watch calls onChange when files in the store change, until ctx is done.
+	// Bursts of changes may be reported as a single call.
+	Watch(ctx context.Context, onChange func()) error
+}
+
+// validName rejects names that would escape the root of a store
+func validName(name string) error {
+	if !fs.ValidPath(name) || name == "." || strings.Contains(name, "\\") || path.Clean(name) != name {
+		return &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	return nil
+}
+
+func notExist(op string, name string) error {
+	return &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+}
@@ -0,0 +1,81 @@
+package content
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"testing"
+	"time"
+)
+
+// testStore checks the behaviour every ContentStore must have
+func testStore(t *testing.T, s ContentStore) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	changed := make(chan struct{}, 10)
+	if err := s.Watch(ctx, func() { changed <- struct{}{} }); err != nil {
+		t.Fatalf("watch: %v", err)
+	}
+
+	if err := s.Write("post.md", []byte("first")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if err := s.Write("sub/nested.md", []byte("nested")); err != nil {
+		t.Fatalf("write in subdirectory: %v", err)
+	}
+	first, err := s.Stat("post.md")
+	if err != nil || first.Size != 5 {
+		t.Fatalf("stat: %v, %+v", err, first)
+	}
+	if err := s.Write("post.md", []byte("second")); err != nil {
+		t.Fatalf("overwrite: %v", err)
+	}
+	data, err := s.Read("post.md")
+	if err != nil || string(data) != "second" {
+		t.Fatalf("read: %v, %q", err, data)
+	}
+
+	files, err := s.List()
+	if err != nil {
+		t.Fatalf("list: %v", err)
+	}
+	names := map[string]File{}
+	for _, f := range files {
+		names[f.Name] = f
+	}
+	if len(names) != 2 || names["sub/nested.md"].Size != 6 {
+		t.Errorf("unexpected files: %+v", files)
+	}
+	if names["post.md"].ModTime.Equal(first.ModTime) && names["post.md"].Size == first.Size {
+		t.Errorf("file unchanged after overwrite: %+v", names["post.md"])
+	}
+
+	if err := s.Delete("post.md"); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if _, err := s.Read("post.md"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected fs.ErrNotExist after delete, got %v", err)
+	}
+	if _, err := s.Stat("missing.md"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected fs.ErrNotExist for a missing file, got %v", err)
+	}
+	for _, name := range []string{"../escape.md", "/abs.md", "a/../../b.md"} {
+		if err := s.Write(name, nil); err == nil {
+			t.Errorf("expected an error writing %q", name)
+		}
+	}
+
+	select {
+	case <-changed:
+	case <-time.After(5 * time.Second):
+		t.Errorf("watcher was not notified of changes")
+	}
+}
+
+func TestFSStore(t *testing.T) {
+	testStore(t, NewFSStore(t.TempDir()))
+}
+
+func TestMemoryStore(t *testing.T) {
+	testStore(t, NewMemoryStore())
+}
@@ -0,0 +1,124 @@
+package content
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/sokkalf/hubro/utils/watchfs"
+)
+
+// FSStore keeps the content in a directory on disk
+type FSStore struct {
+	dir  string
+	fsys fs.FS
+}
+
+func NewFSStore(dir string) *FSStore {
+	return &FSStore{dir: dir, fsys: os.DirFS(dir)}
+}
+
+// Dir returns the directory the store is kept in
+func (s *FSStore) Dir() string {
+	return s.dir
+}
+
+func (s *FSStore) path(name string) string {
+	return filepath.Join(s.dir, filepath.FromSlash(name))
+}
+
+// List skips hidden files and directories
+func (s *FSStore) List() ([]File, error) {
+	files := []File{}
+	err := fs.WalkDir(s.fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if name != "." && strings.HasPrefix(d.Name(), ".") {
+			// Hidden files and directories, such as .git and unfinished writes
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			return nil
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		files = append(files, File{Name: name, Size: fi.Size(), ModTime: fi.ModTime()})
+		return nil
+	})
+	return files, err
+}
+
+func (s *FSStore) Stat(name string) (File, error) {
+	if err := validName(name); err != nil {
+		return File{}, err
+	}
+	fi, err := fs.Stat(s.fsys, name)
+	if err != nil {
+		return File{}, err
+	}
+	if fi.IsDir() {
+		return File{}, notExist("stat", name)
+	}
+	return File{Name: name, Size: fi.Size(), ModTime: fi.ModTime()}, nil
+}
+
+func (s *FSStore) Read(name string) ([]byte, error) {
+	if err := validName(name); err != nil {
+		return nil, err
+	}
+	return fs.ReadFile(s.fsys, name)
+}
+
+// Write replaces the file through a temporary file, so readers never see it
+// half written. The permissions of an existing file are kept.
+func (s *FSStore) Write(name string, data []byte) error {
+	if err := validName(name); err != nil {
+		return err
+	}
+	path := s.path(name)
+	mode := fs.FileMode(0644)
+	if fi, err := os.Stat(path); err == nil {
+		mode = fi.Mode().Perm()
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".hubro-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), mode); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (s *FSStore) Delete(name string) error {
+	if err := validName(name); err != nil {
+		return err
+	}
+	return os.Remove(s.path(name))
+}
+
+func (s *FSStore) Watch(ctx context.Context, onChange func()) error {
+	return watchfs.Watch(ctx, s.dir, onChange)
+}
@@ -0,0 +1,307 @@
+package content
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Git commands in the same repository can't run concurrently, and the blog
+// and pages directories are often in one repository
+var gitMutex sync.Mutex
+
+// fetchTimeout bounds fetching from the upstream, which holds gitMutex
+var fetchTimeout = 2 * time.Minute
+
+var revisionPattern = regexp.MustCompile(`^[0-9a-f]{4,40}$`)
+
+type fileTimes struct {
+	created  time.Time
+	modified time.Time
+}
+
+type watcher struct {
+	ctx      context.Context
+	onChange func()
+}
+
+// GitStore keeps the content in a directory inside a git work tree, and
+// commits every change. Files changed outside Hubro are read as they are,
+// and are not committed.
+type GitStore struct {
+	*FSStore
+	author Author
+	root   string
+
+	timesMutex sync.Mutex
+	times      map[string]fileTimes
+	timesHead  string
+
+	watchMutex sync.Mutex
+	watchers   []watcher
+}
+
+// NewGitStore returns a store for dir, which must be in a git work tree.
+// Writes that don't name an author are committed as author.
+func NewGitStore(dir string, author Author) (*GitStore, error) {
+	s := &GitStore{FSStore: NewFSStore(dir), author: author}
+	root, err := s.git(nil, "rev-parse", "--show-toplevel")
+	if err != nil {
+		return nil, fmt.Errorf("%s is not in a git repository: %w", dir, err)
+	}
+	s.root = strings.TrimSpace(string(root))
+	return s, nil
+}
+
+// Root returns the top-level directory of the repository
+func (s *GitStore) Root() string {
+	return s.root
+}
+
+// Branch returns the full name of the checked out branch, e.g. refs/heads/main
+func (s *GitStore) Branch() (string, error) {
+	out, err := s.git(nil, "symbolic-ref", "--quiet", "HEAD")
+	return strings.TrimSpace(string(out)), err
+}
+
+// Pull fetches the upstream of the checked out branch and fast-forwards to
+// it, and returns the revisions before and after. It fails rather than
+// merging if the branches have diverged.
+func (s *GitStore) Pull() (from string, to string, err error) {
+	gitMutex.Lock()
+	defer gitMutex.Unlock()
+	head := func() string {
+		out, _ := s.git(nil, "rev-parse", "--quiet", "--verify", "HEAD")
+		return strings.TrimSpace(string(out))
+	}
+	from = head()
+	ctx, cancel := context.WithTimeout(context.Background(), fetchTimeout)
+	defer cancel()
+	// Fail rather than wait for credentials nobody will type
+	if _, err := s.gitContext(ctx, []string{"GIT_TERMINAL_PROMPT=0"}, "fetch", "--quiet"); err != nil {
+		return from, from, err
+	}
+	if _, err := s.git(nil, "merge", "--ff-only", "--quiet", "@{upstream}"); err != nil {
+		return from, from, err
+	}
+	to = head()
+	if to != from {
+		s.committed()
+	}
+	return from, to, nil
+}
+
+// Watch reports commits as well as changed files, since a commit changes
+// the times of a file without changing the file
+func (s *GitStore) Watch(ctx context.Context, onChange func()) error {
+	if err := s.FSStore.Watch(ctx, onChange); err != nil {
+		return err
+	}
+	s.watchMutex.Lock()
+	defer s.watchMutex.Unlock()
+	s.watchers = append(s.watchers, watcher{ctx: ctx, onChange: onChange})
+	return nil
+}
+
+// committed refreshes the times after HEAD has moved, and tells the watchers
+func (s *GitStore) committed() {
+	s.timesMutex.Lock()
+	s.loadTimes()
+	s.timesMutex.Unlock()
+	s.watchMutex.Lock()
+	s.watchers = slices.DeleteFunc(s.watchers, func(w watcher) bool { return w.ctx.Err() != nil })
+	watchers := slices.Clone(s.watchers)
+	s.watchMutex.Unlock()
+	for _, w := range watchers {
+		go w.onChange()
+	}
+}
+
+// git runs a git command in the directory of the store, and returns its output
+func (s *GitStore) git(env []string, args ...string) ([]byte, error) {
+	return s.gitContext(context.Background(), env, args...)
+}
+
+// gitContext runs a git command that is killed when ctx is done
+func (s *GitStore) gitContext(ctx context.Context, env []string, args ...string) ([]byte, error) {
+	// Paths are printed as they are rather than quoted
+	cmd := exec.CommandContext(ctx, "git", append([]string{"-C", s.dir, "-c", "core.quotePath=false"}, args...)...)
+	cmd.Env = append(os.Environ(), env...)
+	// Don't wait for children, such as ssh, that still hold the output open
+	cmd.WaitDelay = time.Second
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("git %s: %w: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
+
+// commit records the current state of a file, if it changed
+func (s *GitStore) commit(name string, author Author, message string) error {
+	if _, err := s.git(nil, "add", "--all", "--", name); err != nil {
+		return err
+	}
+	if _, err := s.git(nil, "diff", "--cached", "--quiet", "--", name); err == nil {
+		return nil // nothing changed
+	}
+	env := []string{
+		"GIT_AUTHOR_NAME=" + author.Name, "GIT_AUTHOR_EMAIL=" + author.Email,
+		"GIT_COMMITTER_NAME=" + author.Name, "GIT_COMMITTER_EMAIL=" + author.Email,
+	}
+	if _, err := s.git(env, "commit", "--quiet", "--message", message, "--", name); err != nil {
+		return err
+	}
+	s.committed()
+	return nil
+}
+
+func (s *GitStore) Write(name string, data []byte) error {
+	return s.WriteAs(name, data, s.author, "Update "+name)
+}
+
+func (s *GitStore) WriteAs(name string, data []byte, author Author, message string) error {
+	gitMutex.Lock()
+	defer gitMutex.Unlock()
+	if err := s.FSStore.Write(name, data); err != nil {
+		return err
+	}
+	return s.commit(name, author, message)
+}
+
+func (s *GitStore) Delete(name string) error {
+	gitMutex.Lock()
+	defer gitMutex.Unlock()
+	if err := s.FSStore.Delete(name); err != nil {
+		return err
+	}
+	return s.commit(name, s.author, "Delete "+name)
+}
+
+func (s *GitStore) History(name string) ([]Revision, error) {
+	if err := validName(name); err != nil {
+		return nil, err
+	}
+	out, err := s.git(nil, "log", "--follow", "--format=%H%x00%an%x00%ae%x00%at%x00%s", "--", name)
+	if err != nil {
+		return nil, err
+	}
+	revisions := []Revision{}
+	for line := range strings.Lines(string(out)) {
+		fields := strings.Split(strings.TrimSuffix(line, "\n"), "\x00")
+		if len(fields) != 5 {
+			continue
+		}
+		seconds, _ := strconv.ParseInt(fields[3], 10, 64)
+		revisions = append(revisions, Revision{
+			ID:      fields[0],
+			Author:  Author{Name: fields[1], Email: fields[2]},
+			Date:    time.Unix(seconds, 0),
+			Message: fields[4],
+		})
+	}
+	return revisions, nil
+}
+
+func checkRevision(revision string) error {
+	if !revisionPattern.MatchString(revision) {
+		return fmt.Errorf("invalid revision %q", revision)
+	}
+	return nil
+}
+
+func (s *GitStore) ReadRevision(name string, revision string) ([]byte, error) {
+	if err := validName(name); err != nil {
+		return nil, err
+	}
+	if err := checkRevision(revision); err != nil {
+		return nil, err
+	}
+	return s.git(nil, "show", revision+":./"+name)
+}
+
+func (s *GitStore) Diff(name string, revision string) (string, error) {
+	if err := validName(name); err != nil {
+		return "", err
+	}
+	if err := checkRevision(revision); err != nil {
+		return "", err
+	}
+	out, err := s.git(nil, "diff", "--no-color", revision, "--", name)
+	return string(out), err
+}
+
+func (s *GitStore) Times(name string) (created time.Time, modified time.Time) {
+	s.timesMutex.Lock()
+	defer s.timesMutex.Unlock()
+	if s.times == nil {
+		s.loadTimes()
+	}
+	t := s.times[name]
+	return t.created, t.modified
+}
+
+// List refreshes the times from the history, if there are new commits,
+// since the index is scanned after listing the files
+func (s *GitStore) List() ([]File, error) {
+	s.timesMutex.Lock()
+	s.loadTimes()
+	s.timesMutex.Unlock()
+	return s.FSStore.List()
+}
+
+// loadTimes reads when every file was created and last modified in one pass
+// over the history. It does nothing if HEAD hasn't moved since the last time.
+func (s *GitStore) loadTimes() {
+	head, err := s.git(nil, "rev-parse", "--quiet", "--verify", "HEAD")
+	if err != nil {
+		// No commits yet
+		s.times = map[string]fileTimes{}
+		return
+	}
+	if s.times != nil && string(head) == s.timesHead {
+		return
+	}
+	out, err := s.git(nil, "log", "--relative", "--name-only", "--format=%x00%at", "--", ".")
+	if err != nil {
+		if s.times == nil {
+			s.times = map[string]fileTimes{}
+		}
+		return
+	}
+	times := map[string]fileTimes{}
+	var date time.Time
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if seconds, ok := strings.CutPrefix(line, "\x00"); ok {
+			n, _ := strconv.ParseInt(seconds, 10, 64)
+			date = time.Unix(n, 0)
+			continue
+		}
+		if line == "" {
+			continue
+		}
+		// The log is newest first
+		t, ok := times[line]
+		if !ok {
+			t.modified = date
+		}
+		t.created = date
+		times[line] = t
+	}
+	s.times = times
+	s.timesHead = string(head)
+}
+
+var _ VersionedStore = (*GitStore)(nil)
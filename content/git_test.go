@@ -0,0 +1,162 @@
+package content
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newGitStore(t *testing.T) *GitStore {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	repo := t.TempDir()
+	if err := os.Mkdir(filepath.Join(repo, "blog"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if out, err := exec.Command("git", "init", "--quiet", repo).CombinedOutput(); err != nil {
+		t.Fatalf("git init: %v: %s", err, out)
+	}
+	// The content is in a subdirectory of the repository, like a blog next to the pages
+	s, err := NewGitStore(filepath.Join(repo, "blog"), Author{Name: "Hubro", Email: "hubro@example.org"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestGitStore(t *testing.T) {
+	testStore(t, newGitStore(t))
+}
+
+func TestGitStoreHistory(t *testing.T) {
+	s := newGitStore(t)
+	if _, err := NewGitStore(t.TempDir(), Author{}); err == nil {
+		t.Errorf("expected an error for a directory outside a repository")
+	}
+	author := Author{Name: "Jane Doe", Email: "jane@example.org"}
+	if err := s.WriteAs("post.md", []byte("first\n"), author, "Create post"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.WriteAs("post.md", []byte("second\n"), author, "Update post"); err != nil {
+		t.Fatal(err)
+	}
+	// Writing the same content again doesn't make an empty commit
+	if err := s.WriteAs("post.md", []byte("second\n"), author, "Nothing"); err != nil {
+		t.Fatal(err)
+	}
+
+	history, err := s.History("post.md")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 2 || history[0].Message != "Update post" || history[1].Author != author {
+		t.Fatalf("unexpected history: %+v", history)
+	}
+	old, err := s.ReadRevision("post.md", history[1].ID)
+	if err != nil || string(old) != "first\n" {
+		t.Errorf("read revision: %v, %q", err, old)
+	}
+	diff, err := s.Diff("post.md", history[1].ID)
+	if err != nil || !strings.Contains(diff, "-first") || !strings.Contains(diff, "+second") {
+		t.Errorf("diff: %v, %q", err, diff)
+	}
+	if _, err := s.ReadRevision("post.md", "--output=/tmp/x"); err == nil {
+		t.Errorf("expected an error for an invalid revision")
+	}
+
+	if _, err := s.List(); err != nil {
+		t.Fatal(err)
+	}
+	created, modified := s.Times("post.md")
+	if created.IsZero() || modified.Before(created) {
+		t.Errorf("unexpected times: created %s, modified %s", created, modified)
+	}
+	if created, _ := s.Times("missing.md"); !created.IsZero() {
+		t.Errorf("expected no times for a file without history")
+	}
+}
+
+// TestGitStoreTimesFollowCommits checks that the times are refreshed by a
+// commit, and watchers told about it, without waiting for the file watcher.
+func TestGitStoreTimesFollowCommits(t *testing.T) {
+	s := newGitStore(t)
+	if _, err := s.List(); err != nil {
+		t.Fatal(err)
+	}
+	changed := make(chan struct{}, 10)
+	if err := s.Watch(t.Context(), func() { changed <- struct{}{} }); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(t.Context())
+	if err := s.Watch(ctx, func() { t.Errorf("expected a cancelled watcher not to be called") }); err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+
+	if err := s.Write("post.md", []byte("first\n")); err != nil {
+		t.Fatal(err)
+	}
+	if created, _ := s.Times("post.md"); created.IsZero() {
+		t.Errorf("expected the times of the commit without listing the files")
+	}
+	select {
+	case <-changed:
+	// The file watcher waits longer than this before reporting
+	case <-time.After(400 * time.Millisecond):
+		t.Errorf("expected the watcher to be told about the commit")
+	}
+}
+
+func TestGitStorePull(t *testing.T) {
+	upstream := newGitStore(t)
+	if err := upstream.Write("post.md", []byte("first\n")); err != nil {
+		t.Fatal(err)
+	}
+	clone := filepath.Join(t.TempDir(), "clone")
+	if out, err := exec.Command("git", "clone", "--quiet", upstream.Root(), clone).CombinedOutput(); err != nil {
+		t.Fatalf("git clone: %v: %s", err, out)
+	}
+	s, err := NewGitStore(filepath.Join(clone, "blog"), Author{Name: "Hubro", Email: "hubro@example.org"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := upstream.Write("post.md", []byte("second\n")); err != nil {
+		t.Fatal(err)
+	}
+	from, to, err := s.Pull()
+	if err != nil || from == to {
+		t.Fatalf("pull: %v, from %s to %s", err, from, to)
+	}
+	if data, _ := s.Read("post.md"); string(data) != "second\n" {
+		t.Errorf("expected the pulled content, got %q", data)
+	}
+	if from, to, err := s.Pull(); err != nil || from != to {
+		t.Errorf("expected nothing to pull: %v, from %s to %s", err, from, to)
+	}
+}
+
+// TestGitStorePullTimeout checks that a fetch that hangs is given up.
+func TestGitStorePullTimeout(t *testing.T) {
+	s := newGitStore(t)
+	if _, err := s.git(nil, "remote", "add", "origin", "ssh://git.example.org/blog.git"); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("GIT_SSH_COMMAND", "sleep 10;:")
+	timeout := fetchTimeout
+	fetchTimeout = 100 * time.Millisecond
+	defer func() { fetchTimeout = timeout }()
+
+	start := time.Now()
+	if _, _, err := s.Pull(); err == nil {
+		t.Error("expected the fetch to fail")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("expected the fetch to be given up, it took %v", elapsed)
+	}
+}
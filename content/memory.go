@@ -0,0 +1,121 @@
+package content
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+type memoryFile struct {
+	data    []byte
+	modTime time.Time
+}
+
+type memoryWatcher struct {
+	ctx      context.Context
+	onChange func()
+}
+
+// MemoryStore keeps the content in memory. It is useful for tests, and for
+// content that is generated rather than written by hand.
+type MemoryStore struct {
+	mtx      sync.RWMutex
+	files    map[string]memoryFile
+	watchers []memoryWatcher
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{files: make(map[string]memoryFile)}
+}
+
+func (s *MemoryStore) List() ([]File, error) {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+	files := make([]File, 0, len(s.files))
+	for name, f := range s.files {
+		files = append(files, File{Name: name, Size: int64(len(f.data)), ModTime: f.modTime})
+	}
+	slices.SortFunc(files, func(a, b File) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return files, nil
+}
+
+func (s *MemoryStore) Stat(name string) (File, error) {
+	if err := validName(name); err != nil {
+		return File{}, err
+	}
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+	f, ok := s.files[name]
+	if !ok {
+		return File{}, notExist("stat", name)
+	}
+	return File{Name: name, Size: int64(len(f.data)), ModTime: f.modTime}, nil
+}
+
+func (s *MemoryStore) Read(name string) ([]byte, error) {
+	if err := validName(name); err != nil {
+		return nil, err
+	}
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+	f, ok := s.files[name]
+	if !ok {
+		return nil, notExist("open", name)
+	}
+	return slices.Clone(f.data), nil
+}
+
+func (s *MemoryStore) Write(name string, data []byte) error {
+	if err := validName(name); err != nil {
+		return err
+	}
+	s.mtx.Lock()
+	modTime := time.Now()
+	if old, ok := s.files[name]; ok && !modTime.After(old.modTime) {
+		// Scanning relies on the modification time changing on every write
+		modTime = old.modTime.Add(time.Nanosecond)
+	}
+	s.files[name] = memoryFile{data: slices.Clone(data), modTime: modTime}
+	s.mtx.Unlock()
+	s.changed()
+	return nil
+}
+
+func (s *MemoryStore) Delete(name string) error {
+	if err := validName(name); err != nil {
+		return err
+	}
+	s.mtx.Lock()
+	_, ok := s.files[name]
+	delete(s.files, name)
+	s.mtx.Unlock()
+	if !ok {
+		return notExist("remove", name)
+	}
+	s.changed()
+	return nil
+}
+
+func (s *MemoryStore) Watch(ctx context.Context, onChange func()) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.watchers = append(s.watchers, memoryWatcher{ctx: ctx, onChange: onChange})
+	return nil
+}
+
+// changed notifies the watchers, and forgets those whose context is done
+func (s *MemoryStore) changed() {
+	s.mtx.Lock()
+	s.watchers = slices.DeleteFunc(s.watchers, func(w memoryWatcher) bool {
+		return w.ctx.Err() != nil
+	})
+	watchers := slices.Clone(s.watchers)
+	s.mtx.Unlock()
+	for _, w := range watchers {
+		go w.onChange()
+	}
+}
@@ -0,0 +1,34 @@
+package content
+
+import "time"
+
+// Author identifies who made a change to a versioned store
+type Author struct {
+	Name  string
+	Email string
+}
+
+// Revision is a version of a file in a versioned store
+type Revision struct {
+	ID      string
+	Author  Author
+	Date    time.Time
+	Message string
+}
+
+// VersionedStore is a ContentStore that keeps the history of every file.
+// Every write is recorded as a revision.
+type VersionedStore interface {
+	ContentStore
+	// WriteAs writes a file, recording author and message with the revision
+	WriteAs(name string, data []byte, author Author, message string) error
+	// History returns the revisions of a file, newest first
+	History(name string) ([]Revision, error)
+	// ReadRevision returns the contents of a file at a revision
+	ReadRevision(name string, revision string) ([]byte, error)
+	// Diff returns a unified diff from a revision of a file to its current contents
+	Diff(name string, revision string) (string, error)
+	// Times returns when a file was first and last changed according to its
+	// history. Both are zero if the file has no history yet.
+	Times(name string) (created time.Time, modified time.Time)
+}
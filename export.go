@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/sokkalf/hubro/config"
+	"github.com/sokkalf/hubro/export"
+	"github.com/sokkalf/hubro/logging"
+)
+
+// runExport implements the export subcommand, which writes the site as static HTML
+func runExport(args []string) int {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	outputDir := flags.String("o", "./public", "output directory")
+	baseURL := flags.String("base-url", "", "base URL of the exported site (default: HUBRO_BASE_URL)")
+	site := flags.String("site", "", "name of the site to export, when several are configured (default: the first one)")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s export [-o dir] [-base-url url] [-site name]\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if !initConfig() {
+		return 1
+	}
+	closeFunc := logging.InitLogger()
+	defer closeFunc()
+	sites := config.Get().SiteNames()
+	if *site == "" && len(sites) > 0 {
+		*site = sites[0]
+	} else if *site != "" && !slices.Contains(sites, *site) {
+		fmt.Fprintf(os.Stderr, "Unknown site %q, configured sites are: %s\n", *site, strings.Join(sites, ", "))
+		return 1
+	}
+	c := config.Get().Site(*site)
+	if *baseURL == "" {
+		*baseURL = c.BaseURL
+	}
+
+	h := setupSite(context.Background(), *site)
+	assets := map[string]fs.FS{
+		"/static": viewDir(c.ThemeDir, staticPath),
+		"/vendor": viewDir(c.ThemeDir, vendorPath),
+		"/":       viewDir(c.ThemeDir, publicPath),
+	}
+	if fi, err := os.Stat(c.UserStaticDir); err == nil && fi.IsDir() {
+		assets["/userfiles"] = os.DirFS(c.UserStaticDir)
+	}
+
+	_, err := export.Export(h, export.Options{
+		OutputDir: *outputDir,
+		BaseURL:   *baseURL,
+		Assets:    assets,
+	})
+	if err != nil {
+		slog.Error("Error exporting site", "error", err)
+		return 1
+	}
+	return 0
+}
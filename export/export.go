@@ -0,0 +1,360 @@
+package export
+
+import (
+	"fmt"
+	"html"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sokkalf/hubro/server"
+)
+
+type Options struct {
+	// OutputDir is where the exported site is written
+	OutputDir string
+	// BaseURL is the URL the exported site will be served from
+	BaseURL string
+	// Assets maps URL prefixes to the directories served under them,
+	// every file in them is exported
+	Assets map[string]fs.FS
+}
+
+type resource struct {
+	url         string
+	contentType string
+	status      int
+	body        []byte
+}
+
+// Paths that only make sense on a running server
+var excludedPrefixes = []string{"/admin", "/api", "/search", "/healthz", "/hooks", "/test"}
+
+var linkAttr = regexp.MustCompile(`(href|src|action|content)="([^"]*)"`)
+
+// A srcset lists images separated by commas, each followed by its width
+var srcsetAttr = regexp.MustCompile(`srcset="([^"]*)"`)
+
+type exporter struct {
+	site      *server.Hubro
+	handler   http.Handler
+	opts      Options
+	rootPath  string
+	siteURL   *url.URL
+	resources map[string]*resource
+	queue     []string
+}
+
+// Export crawls every route the Hubro instance knows about, and writes the
+// responses as a static directory tree with links rewritten to opts.BaseURL.
+func Export(h *server.Hubro, opts Options) (int, error) {
+	start := time.Now()
+	siteURL, err := url.Parse(h.Config().BaseURL)
+	if err != nil {
+		return 0, fmt.Errorf("invalid base URL %q: %w", h.Config().BaseURL, err)
+	}
+	e := &exporter{
+		site:      h,
+		handler:   h.GetHandler(),
+		opts:      opts,
+		rootPath:  strings.TrimSuffix(h.Config().RootPath, "/"),
+		siteURL:   siteURL,
+		resources: make(map[string]*resource),
+	}
+
+	e.seed()
+	for len(e.queue) > 0 {
+		u := e.queue[0]
+		e.queue = e.queue[1:]
+		e.fetch(u)
+	}
+
+	n := 0
+	for _, res := range e.resources {
+		if res.status != http.StatusOK {
+			continue
+		}
+		if err := e.write(e.outputPath(res), e.rewrite(res)); err != nil {
+			return n, err
+		}
+		n++
+	}
+	if err := e.writeNotFoundPage(); err != nil {
+		return n, err
+	}
+	slog.Info("Exported site", "files", n, "outputDir", opts.OutputDir, "duration", time.Since(start))
+	return n, nil
+}
+
+// seed queues all routes known from the indices and asset directories.
+// Pagination and other routes are discovered by following links.
+func (e *exporter) seed() {
+	e.enqueue("/")
+	e.enqueue("/sitemap.xml")
+	tags := make(map[string]bool)
+	for _, idx := range e.site.Indices() {
+		for _, entry := range idx.GetEntries() {
+			if entry.Draft || entry.Scheduled {
+				continue
+			}
+			e.enqueue(strings.TrimPrefix(entry.Path, e.rootPath))
+			if entry.Visible {
+				for _, tag := range entry.Tags {
+					tags[tag] = true
+				}
+			}
+		}
+	}
+	for tag := range tags {
+		e.enqueue("/?" + url.Values{"tag": {tag}}.Encode())
+	}
+	if e.site.HasModule("/feeds") {
+		e.enqueue("/feeds/rss")
+		e.enqueue("/feeds/atom")
+		e.enqueue("/feeds/json")
+	}
+	for prefix, dir := range e.opts.Assets {
+		fs.WalkDir(dir, ".", func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				slog.Warn("Error walking asset directory", "prefix", prefix, "error", err)
+				return nil
+			}
+			if !d.IsDir() && !strings.HasPrefix(d.Name(), ".") {
+				e.enqueue(path.Join("/", prefix, p))
+			}
+			return nil
+		})
+	}
+}
+
+// normalize turns a link into a request URI on this site, or returns false
+// if the link points elsewhere
+func (e *exporter) normalize(link string, base *url.URL) (string, bool) {
+	u, err := url.Parse(html.UnescapeString(link))
+	if err != nil {
+		return "", false
+	}
+	if u.Scheme != "" && u.Scheme != "http" && u.Scheme != "https" {
+		return "", false
+	}
+	if u.Host != "" && u.Host != e.siteURL.Host {
+		return "", false
+	}
+	if u.Path == "" && u.RawQuery == "" && u.Host == "" {
+		// Empty links and fragments on the same page
+		return "", false
+	}
+	u = base.ResolveReference(u)
+	if u.Host != "" && u.Host != e.siteURL.Host {
+		return "", false
+	}
+	p := u.Path
+	if e.rootPath != "" {
+		if !strings.HasPrefix(p, e.rootPath) {
+			return "", false
+		}
+		p = strings.TrimPrefix(p, e.rootPath)
+	}
+	if p == "" {
+		p = "/"
+	}
+	for _, prefix := range excludedPrefixes {
+		if p == prefix || strings.HasPrefix(p, prefix+"/") {
+			return "", false
+		}
+	}
+	// Query strings on files are only used for cache busting
+	if path.Ext(p) != "" || u.RawQuery == "" {
+		return p, true
+	}
+	return p + "?" + u.Query().Encode(), true
+}
+
+func (e *exporter) enqueue(uri string) {
+	if _, ok := e.resources[uri]; ok || slices.Contains(e.queue, uri) {
+		return
+	}
+	e.queue = append(e.queue, uri)
+}
+
+func (e *exporter) fetch(uri string) {
+	req := httptest.NewRequest(http.MethodGet, uri, nil)
+	rec := httptest.NewRecorder()
+	e.handler.ServeHTTP(rec, req)
+	res := &resource{
+		url:         uri,
+		contentType: rec.Header().Get("Content-Type"),
+		status:      rec.Code,
+		body:        rec.Body.Bytes(),
+	}
+	if res.contentType == "" {
+		res.contentType = http.DetectContentType(res.body)
+	}
+	e.resources[uri] = res
+	if res.status != http.StatusOK {
+		slog.Warn("Skipping route", "url", uri, "status", res.status)
+		return
+	}
+	if !isHTML(res.contentType) {
+		return
+	}
+	base, _ := url.Parse(e.rootPath + uri)
+	for _, m := range linkAttr.FindAllSubmatch(res.body, -1) {
+		if string(m[1]) == "content" {
+			continue
+		}
+		if link, ok := e.normalize(string(m[2]), base); ok {
+			e.enqueue(link)
+		}
+	}
+	for _, m := range srcsetAttr.FindAllSubmatch(res.body, -1) {
+		for candidate := range strings.SplitSeq(html.UnescapeString(string(m[1])), ",") {
+			fields := strings.Fields(candidate)
+			if len(fields) == 0 {
+				continue
+			}
+			if link, ok := e.normalize(fields[0], base); ok {
+				e.enqueue(link)
+			}
+		}
+	}
+}
+
+func isHTML(contentType string) bool {
+	return strings.HasPrefix(contentType, "text/html")
+}
+
+// outputPath maps a resource to a file below the output directory. Pages are
+// written as directories with an index.html, so that links stay clean, and
+// query parameters become path segments, e.g. /?tag=go&p=2 is written to
+// /p/2/tag/go/index.html.
+func (e *exporter) outputPath(res *resource) string {
+	p, rawQuery, _ := strings.Cut(res.url, "?")
+	if path.Ext(p) != "" {
+		return p
+	}
+	query, _ := url.ParseQuery(rawQuery)
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		for _, v := range query[k] {
+			p = path.Join(p, pathSegment(k), pathSegment(v))
+		}
+	}
+	switch {
+	case isHTML(res.contentType):
+		return path.Join(p, "index.html")
+	case strings.Contains(res.contentType, "json"):
+		return p + ".json"
+	case strings.Contains(res.contentType, "xml"):
+		return p + ".xml"
+	default:
+		return p
+	}
+}
+
+// pathSegment escapes a query key or value for use as one path segment,
+// including . and .., which would otherwise climb the directory tree
+func pathSegment(s string) string {
+	switch s {
+	case ".":
+		return "%2E"
+	case "..":
+		return "%2E%2E"
+	}
+	return url.PathEscape(s)
+}
+
+// link returns the URL a resource is reachable at in the exported site
+func (e *exporter) link(res *resource) string {
+	base := strings.TrimSuffix(e.opts.BaseURL, "/")
+	p := e.outputPath(res)
+	if dir, ok := strings.CutSuffix(p, "/index.html"); ok {
+		return base + dir + "/"
+	}
+	return base + p
+}
+
+// rewriteLink returns where a link points to in the exported site, if it was
+// exported
+func (e *exporter) rewriteLink(link string, base *url.URL) (string, bool) {
+	uri, ok := e.normalize(link, base)
+	if !ok {
+		return "", false
+	}
+	target, ok := e.resources[uri]
+	if !ok || target.status != http.StatusOK {
+		return "", false
+	}
+	rewritten := e.link(target)
+	if u, err := url.Parse(html.UnescapeString(link)); err == nil && u.Fragment != "" {
+		rewritten += "#" + u.Fragment
+	}
+	return rewritten, true
+}
+
+func (e *exporter) rewrite(res *resource) []byte {
+	switch {
+	case isHTML(res.contentType):
+		base, _ := url.Parse(e.rootPath + res.url)
+		body := linkAttr.ReplaceAllFunc(res.body, func(m []byte) []byte {
+			sub := linkAttr.FindSubmatch(m)
+			link, ok := e.rewriteLink(string(sub[2]), base)
+			if !ok {
+				return m
+			}
+			return fmt.Appendf(nil, `%s="%s"`, sub[1], html.EscapeString(link))
+		})
+		return srcsetAttr.ReplaceAllFunc(body, func(m []byte) []byte {
+			candidates := strings.Split(html.UnescapeString(string(srcsetAttr.FindSubmatch(m)[1])), ",")
+			for i, candidate := range candidates {
+				fields := strings.Fields(candidate)
+				if len(fields) == 0 {
+					continue
+				}
+				if link, ok := e.rewriteLink(fields[0], base); ok {
+					fields[0] = link
+				}
+				candidates[i] = strings.Join(fields, " ")
+			}
+			return fmt.Appendf(nil, `srcset="%s"`, html.EscapeString(strings.Join(candidates, ", ")))
+		})
+	case strings.Contains(res.contentType, "xml") || strings.Contains(res.contentType, "json"):
+		// Feeds use absolute links
+		siteBase := strings.TrimSuffix(e.site.Config().BaseURL, "/")
+		return []byte(strings.ReplaceAll(string(res.body), siteBase, strings.TrimSuffix(e.opts.BaseURL, "/")))
+	default:
+		return res.body
+	}
+}
+
+func (e *exporter) write(p string, data []byte) error {
+	fileName := filepath.Join(e.opts.OutputDir, filepath.FromSlash(p))
+	if err := os.MkdirAll(filepath.Dir(fileName), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(fileName, data, 0644)
+}
+
+// writeNotFoundPage renders the error page for a missing route as 404.html,
+// which most static hosts serve for unknown paths
+func (e *exporter) writeNotFoundPage() error {
+	req := httptest.NewRequest(http.MethodGet, "/404.html", nil)
+	rec := httptest.NewRecorder()
+	e.handler.ServeHTTP(rec, req)
+	res := &resource{url: req.URL.Path, contentType: "text/html", status: rec.Code, body: rec.Body.Bytes()}
+	return e.write("/404.html", e.rewrite(res))
+}
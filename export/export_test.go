@@ -0,0 +1,101 @@
+package export
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/sokkalf/hubro/config"
+	"github.com/sokkalf/hubro/server"
+)
+
+func testSite(t *testing.T) *server.Hubro {
+	t.Setenv("HUBRO_CONFIG_FILE", "")
+	t.Setenv("HUBRO_BASE_URL", "http://localhost:8080/")
+	if err := config.Init(); err != nil {
+		t.Fatal(err)
+	}
+	views := fstest.MapFS{
+		"app.gohtml":            {Data: []byte(`<html>{{yield}}</html>`)},
+		"errors/layout.gohtml":  {Data: []byte(`{{yield}}`)},
+		"errors/default.gohtml": {Data: []byte(`Error {{.Status}}`)},
+		"blogindex.gohtml": {Data: []byte(`<a href="/blog/first#top">First</a>` +
+			`<a href="https://example.org/elsewhere">Elsewhere</a><a href="/admin/">Admin</a>`)},
+	}
+	h := server.NewHubro(server.Config{
+		LayoutDir:   views,
+		TemplateDir: views,
+		PublicDir:   fstest.MapFS{},
+		StaticDir:   fstest.MapFS{},
+		VendorDir:   fstest.MapFS{},
+	})
+	h.Mux.HandleFunc("GET /blog/first", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(`<a href="second?p=2">Next</a><img src="/img/a.png" srcset="/img/a-40.png?v=1a2b 40w, /img/a.png 80w">`))
+	})
+	h.Mux.HandleFunc("GET /blog/second", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(`<a href="/blog/first">Back</a><a href="/blog/missing">Missing</a>`))
+	})
+	h.Mux.HandleFunc("GET /img/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write([]byte("png"))
+	})
+	return h
+}
+
+// TestExportFollowsLinks checks that pages and images linked from the index
+// are exported, links elsewhere are not, and links point into the export.
+func TestExportFollowsLinks(t *testing.T) {
+	h := testSite(t)
+	out := t.TempDir()
+	if _, err := Export(h, Options{OutputDir: out, BaseURL: "https://static.example.org/"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, file := range []string{"index.html", "blog/first/index.html", "blog/second/p/2/index.html",
+		"img/a.png", "img/a-40.png", "404.html"} {
+		if _, err := os.Stat(filepath.Join(out, file)); err != nil {
+			t.Errorf("expected %s to be exported: %v", file, err)
+		}
+	}
+	for _, file := range []string{"admin", "blog/missing"} {
+		if _, err := os.Stat(filepath.Join(out, file)); err == nil {
+			t.Errorf("expected %s not to be exported", file)
+		}
+	}
+
+	index, _ := os.ReadFile(filepath.Join(out, "index.html"))
+	for _, want := range []string{`href="https://static.example.org/blog/first/#top"`,
+		`href="https://example.org/elsewhere"`, `href="/admin/"`} {
+		if !strings.Contains(string(index), want) {
+			t.Errorf("expected %s in %s", want, index)
+		}
+	}
+	first, _ := os.ReadFile(filepath.Join(out, "blog/first/index.html"))
+	for _, want := range []string{`href="https://static.example.org/blog/second/p/2/"`,
+		`srcset="https://static.example.org/img/a-40.png 40w, https://static.example.org/img/a.png 80w"`} {
+		if !strings.Contains(string(first), want) {
+			t.Errorf("expected %s in %s", want, first)
+		}
+	}
+}
+
+// TestOutputPath checks that query parameters can't place files outside the
+// directory of their page.
+func TestOutputPath(t *testing.T) {
+	e := &exporter{}
+	for url, want := range map[string]string{
+		"/blog/?tag=go&p=2":  "/blog/p/2/tag/go/index.html",
+		"/blog/?tag=..":      "/blog/tag/%2E%2E/index.html",
+		"/blog/?tag=../../x": "/blog/tag/..%2F..%2Fx/index.html",
+		"/blog/?tag=.&..=x":  "/blog/%2E%2E/x/tag/%2E/index.html",
+		"/blog/?tag=a%2Fb+c": "/blog/tag/a%2Fb%20c/index.html",
+	} {
+		if got := e.outputPath(&resource{url: url, contentType: "text/html"}); got != want {
+			t.Errorf("%s: expected %s, got %s", url, want, got)
+		}
+	}
+}
@@ -0,0 +1,335 @@
+package fulltext
+
+import (
+	"encoding/json"
+	"hash/fnv"
+	"log/slog"
+	"math"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sokkalf/hubro/index"
+)
+
+// BM25 tuning parameters
+const (
+	k1 = 1.2
+	b  = 0.75
+)
+
+// Field weights, a term found in the title counts more than one in the body
+const (
+	titleWeight       = 3.0
+	descriptionWeight = 2.0
+	tagWeight         = 2.0
+	bodyWeight        = 1.0
+)
+
+// fieldGap separates the positions of different fields, so that phrase
+// queries can't match across field boundaries
+const fieldGap = 100
+
+type posting struct {
+	freq      float64
+	positions []int
+}
+
+type document struct {
+	key         string
+	index       string
+	entry       index.IndexEntry
+	fingerprint uint64
+	length      int
+	tags        []string
+	terms       map[string]*posting
+}
+
+// Engine is an in-memory inverted index over the entries of one or more
+// indices, ranked with BM25.
+type Engine struct {
+	mtx      sync.RWMutex
+	docs     map[string]*document
+	postings map[string]map[string]*posting
+	totalLen int
+}
+
+type Result struct {
+	Index string
+	Entry index.IndexEntry
+	Score float64
+}
+
+func NewEngine() *Engine {
+	return &Engine{
+		docs:     make(map[string]*document),
+		postings: make(map[string]map[string]*posting),
+	}
+}
+
+// Watch indexes all entries of idx, and re-indexes them whenever the index
+// publishes index.Updated.
+func (e *Engine) Watch(idx *index.Index) {
+	e.Sync(idx)
+	go func() {
+		msgChan := idx.MsgBroker.Subscribe()
+		for {
+			switch <-msgChan {
+			case index.Updated:
+				start := time.Now()
+				added, removed := e.Sync(idx)
+				slog.Debug("Updated search index", "index", idx.GetName(),
+					"added", added, "removed", removed, "duration", time.Since(start))
+			default: // Ignore other messages
+			}
+		}
+	}()
+}
+
+func searchable(entry index.IndexEntry) bool {
+	return entry.Visible && !entry.Draft && !entry.Scheduled
+}
+
+// Sync brings the documents belonging to idx up to date. Only entries that
+// are new or have changed since the last sync are tokenized again.
+func (e *Engine) Sync(idx *index.Index) (added, removed int) {
+	name := idx.GetName()
+	seen := make(map[string]bool)
+	for _, entry := range idx.GetEntries() {
+		if !searchable(entry) {
+			continue
+		}
+		key := name + "/" + entry.Id
+		seen[key] = true
+		fp := fingerprint(entry)
+		e.mtx.RLock()
+		doc, ok := e.docs[key]
+		e.mtx.RUnlock()
+		if ok && doc.fingerprint == fp {
+			continue
+		}
+		doc = newDocument(key, name, entry, fp)
+		e.mtx.Lock()
+		e.remove(key)
+		e.add(doc)
+		e.mtx.Unlock()
+		added++
+	}
+
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+	for key, doc := range e.docs {
+		if doc.index == name && !seen[key] {
+			e.remove(key)
+			removed++
+		}
+	}
+	return added, removed
+}
+
+// Len returns the number of indexed documents
+func (e *Engine) Len() int {
+	e.mtx.RLock()
+	defer e.mtx.RUnlock()
+	return len(e.docs)
+}
+
+func fingerprint(entry index.IndexEntry) uint64 {
+	h := fnv.New64a()
+	b, err := json.Marshal(entry)
+	if err != nil {
+		slog.Warn("Error fingerprinting entry", "id", entry.Id, "error", err)
+	}
+	h.Write(b)
+	return h.Sum64()
+}
+
+func newDocument(key string, indexName string, entry index.IndexEntry, fp uint64) *document {
+	doc := &document{
+		key:         key,
+		index:       indexName,
+		entry:       entry,
+		fingerprint: fp,
+		terms:       make(map[string]*posting),
+	}
+	pos := 0
+	addField := func(text string, weight float64) {
+		for _, t := range tokenize(text) {
+			p, ok := doc.terms[t]
+			if !ok {
+				p = &posting{}
+				doc.terms[t] = p
+			}
+			p.freq += weight
+			p.positions = append(p.positions, pos)
+			pos++
+			doc.length++
+		}
+		pos += fieldGap
+	}
+	addField(entry.Title, titleWeight)
+	addField(entry.Description, descriptionWeight)
+	for _, tag := range entry.Tags {
+		addField(tag, tagWeight)
+		doc.tags = append(doc.tags, strings.ToLower(tag))
+	}
+	if entry.Body != nil {
+		addField(stripHTML(string(*entry.Body)), bodyWeight)
+	}
+	return doc
+}
+
+// add and remove must be called with the write lock held
+func (e *Engine) add(doc *document) {
+	e.docs[doc.key] = doc
+	e.totalLen += doc.length
+	for term, p := range doc.terms {
+		docs, ok := e.postings[term]
+		if !ok {
+			docs = make(map[string]*posting)
+			e.postings[term] = docs
+		}
+		docs[doc.key] = p
+	}
+}
+
+func (e *Engine) remove(key string) {
+	doc, ok := e.docs[key]
+	if !ok {
+		return
+	}
+	for term := range doc.terms {
+		delete(e.postings[term], key)
+		if len(e.postings[term]) == 0 {
+			delete(e.postings, term)
+		}
+	}
+	e.totalLen -= doc.length
+	delete(e.docs, key)
+}
+
+// Search returns all documents matching the query, best match first.
+// Every term and phrase must be present, and every tag must be set on the entry.
+func (e *Engine) Search(q Query) []Result {
+	if q.Empty() {
+		return []Result{}
+	}
+	e.mtx.RLock()
+	defer e.mtx.RUnlock()
+
+	terms := q.allTerms()
+	candidates := e.candidates(terms)
+	results := make([]Result, 0, len(candidates))
+	for _, doc := range candidates {
+		if !doc.hasTags(q.Tags) || !doc.hasPhrases(q.Phrases) {
+			continue
+		}
+		results = append(results, Result{
+			Index: doc.index,
+			Entry: doc.entry,
+			Score: e.score(doc, terms),
+		})
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].Entry.Date.After(results[j].Entry.Date)
+	})
+	return results
+}
+
+func (e *Engine) candidates(terms []string) []*document {
+	docs := make([]*document, 0)
+	if len(terms) == 0 {
+		for _, doc := range e.docs {
+			docs = append(docs, doc)
+		}
+		return docs
+	}
+	// Start with the rarest term to keep the intersection small
+	sorted := slices.Clone(terms)
+	sort.Slice(sorted, func(i, j int) bool {
+		return len(e.postings[sorted[i]]) < len(e.postings[sorted[j]])
+	})
+	for key := range e.postings[sorted[0]] {
+		doc := e.docs[key]
+		if doc.hasTerms(sorted[1:]) {
+			docs = append(docs, doc)
+		}
+	}
+	return docs
+}
+
+func (e *Engine) score(doc *document, terms []string) float64 {
+	n := float64(len(e.docs))
+	avgLen := float64(e.totalLen) / n
+	var score float64
+	for _, term := range terms {
+		p, ok := doc.terms[term]
+		if !ok {
+			continue
+		}
+		df := float64(len(e.postings[term]))
+		idf := math.Log(1 + (n-df+0.5)/(df+0.5))
+		norm := 1 - b
+		if avgLen > 0 {
+			norm += b * float64(doc.length) / avgLen
+		}
+		score += idf * (p.freq * (k1 + 1)) / (p.freq + k1*norm)
+	}
+	return score
+}
+
+func (d *document) hasTerms(terms []string) bool {
+	for _, term := range terms {
+		if _, ok := d.terms[term]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func (d *document) hasTags(tags []string) bool {
+	for _, tag := range tags {
+		if !slices.Contains(d.tags, tag) {
+			return false
+		}
+	}
+	return true
+}
+
+func (d *document) hasPhrases(phrases [][]string) bool {
+	for _, phrase := range phrases {
+		if !d.hasPhrase(phrase) {
+			return false
+		}
+	}
+	return true
+}
+
+func (d *document) hasPhrase(phrase []string) bool {
+	first, ok := d.terms[phrase[0]]
+	if !ok {
+		return false
+	}
+	for _, start := range first.positions {
+		match := true
+		for offset, term := range phrase[1:] {
+			p, ok := d.terms[term]
+			if !ok {
+				return false
+			}
+			if _, found := slices.BinarySearch(p.positions, start+offset+1); !found {
+				match = false
+				break
+			}
+		}
+		if match {
+			return true
+		}
+	}
+	return false
+}
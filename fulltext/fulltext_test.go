@@ -0,0 +1,136 @@
+package fulltext
+
+import (
+	"html/template"
+	"testing"
+	"time"
+
+	"github.com/sokkalf/hubro/index"
+)
+
+func body(s string) *template.HTML {
+	b := template.HTML(s)
+	return &b
+}
+
+func newTestIndex(t *testing.T, name string, entries ...index.IndexEntry) *index.Index {
+	t.Helper()
+	idx := index.NewIndex(name, "/"+name)
+	for _, e := range entries {
+		if err := idx.AddEntry(e); err != nil {
+			t.Fatalf("unexpected error adding entry: %v", err)
+		}
+	}
+	return idx
+}
+
+func ids(results []Result) []string {
+	res := make([]string, len(results))
+	for i, r := range results {
+		res[i] = r.Entry.Id
+	}
+	return res
+}
+
+// TestParseQuery checks that terms, phrases and tags are separated.
+func TestParseQuery(t *testing.T) {
+	q := ParseQuery(`Go "error handling" tag:Programming foo-bar "unterminated`)
+	if len(q.Terms) != 2 || q.Terms[0] != "go" || q.Terms[1] != "unterminated" {
+		t.Errorf("unexpected terms: %v", q.Terms)
+	}
+	if len(q.Phrases) != 2 || len(q.Phrases[0]) != 2 || q.Phrases[0][1] != "handling" || q.Phrases[1][0] != "foo" {
+		t.Errorf("unexpected phrases: %v", q.Phrases)
+	}
+	if len(q.Tags) != 1 || q.Tags[0] != "programming" {
+		t.Errorf("unexpected tags: %v", q.Tags)
+	}
+	if !ParseQuery("   ").Empty() {
+		t.Errorf("expected empty query")
+	}
+}
+
+// TestSearchRanking checks that title matches rank above body matches.
+func TestSearchRanking(t *testing.T) {
+	idx := newTestIndex(t, "ftRanking",
+		index.IndexEntry{Id: "body", Title: "Something else", Visible: true, Body: body("<p>all about <b>owls</b></p>")},
+		index.IndexEntry{Id: "title", Title: "Owls", Visible: true, Body: body("<p>birds of the night</p>")},
+		index.IndexEntry{Id: "none", Title: "Cats", Visible: true, Body: body("<p>meow</p>")},
+	)
+	e := NewEngine()
+	e.Sync(idx)
+
+	got := ids(e.Search(ParseQuery("owls")))
+	if len(got) != 2 || got[0] != "title" || got[1] != "body" {
+		t.Errorf("unexpected ranking: %v", got)
+	}
+}
+
+// TestSearchPhraseAndTags checks phrase matching and tag filtering.
+func TestSearchPhraseAndTags(t *testing.T) {
+	idx := newTestIndex(t, "ftPhrase",
+		index.IndexEntry{Id: "a", Title: "A", Visible: true, Tags: []string{"go"}, Body: body("error handling in go")},
+		index.IndexEntry{Id: "b", Title: "B", Visible: true, Tags: []string{"rust"}, Body: body("handling of every error")},
+		index.IndexEntry{Id: "c", Title: "C", Visible: true, Tags: []string{"go"}, Body: body("nothing here")},
+	)
+	e := NewEngine()
+	e.Sync(idx)
+
+	if got := ids(e.Search(ParseQuery(`"error handling"`))); len(got) != 1 || got[0] != "a" {
+		t.Errorf("phrase query: unexpected results %v", got)
+	}
+	if got := ids(e.Search(ParseQuery("error handling"))); len(got) != 2 {
+		t.Errorf("term query: expected 2 results, got %v", got)
+	}
+	if got := ids(e.Search(ParseQuery("handling tag:rust"))); len(got) != 1 || got[0] != "b" {
+		t.Errorf("tag filter: unexpected results %v", got)
+	}
+	if got := ids(e.Search(ParseQuery("tag:go"))); len(got) != 2 {
+		t.Errorf("tag only: expected 2 results, got %v", got)
+	}
+}
+
+// TestSearchExcludesHidden checks that drafts and invisible entries are not indexed.
+func TestSearchExcludesHidden(t *testing.T) {
+	idx := newTestIndex(t, "ftHidden",
+		index.IndexEntry{Id: "draft", Title: "Secret draft", Visible: false, Draft: true},
+		index.IndexEntry{Id: "hidden", Title: "Secret page", Visible: false},
+		index.IndexEntry{Id: "public", Title: "Public page", Visible: true},
+	)
+	e := NewEngine()
+	e.Sync(idx)
+
+	if e.Len() != 1 {
+		t.Errorf("expected 1 indexed document, got %d", e.Len())
+	}
+	if got := e.Search(ParseQuery("secret")); len(got) != 0 {
+		t.Errorf("expected no results, got %v", ids(got))
+	}
+}
+
+// TestSyncIncremental checks that updated and deleted entries are reflected after a sync.
+func TestSyncIncremental(t *testing.T) {
+	idx := newTestIndex(t, "ftIncremental",
+		index.IndexEntry{Id: "1", Title: "First", Visible: true, Date: time.Now()},
+		index.IndexEntry{Id: "2", Title: "Second", Visible: true},
+	)
+	e := NewEngine()
+	if added, _ := e.Sync(idx); added != 2 {
+		t.Fatalf("expected 2 added documents, got %d", added)
+	}
+	if added, removed := e.Sync(idx); added != 0 || removed != 0 {
+		t.Errorf("expected no changes, got %d added and %d removed", added, removed)
+	}
+
+	idx.UpdateEntry(index.IndexEntry{Id: "1", Title: "Renamed", Visible: true})
+	idx.DeleteEntry("2")
+	added, removed := e.Sync(idx)
+	if added != 1 || removed != 1 {
+		t.Errorf("expected 1 added and 1 removed, got %d and %d", added, removed)
+	}
+	if got := e.Search(ParseQuery("first")); len(got) != 0 {
+		t.Errorf("expected old title to be gone, got %v", ids(got))
+	}
+	if got := e.Search(ParseQuery("renamed")); len(got) != 1 {
+		t.Errorf("expected new title to be found, got %v", ids(got))
+	}
+}
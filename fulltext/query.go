@@ -0,0 +1,103 @@
+package fulltext
+
+import (
+	"html"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// Query is a parsed search query. Plain words end up in Terms, quoted text in
+// Phrases, and tag:<name> in Tags.
+type Query struct {
+	Terms   []string
+	Phrases [][]string
+	Tags    []string
+}
+
+// ParseQuery parses a query string such as
+//
+//	go "error handling" tag:programming
+func ParseQuery(s string) Query {
+	var q Query
+	addWords := func(text string) {
+		tokens := tokenize(text)
+		switch len(tokens) {
+		case 0:
+		case 1:
+			q.Terms = append(q.Terms, tokens[0])
+		default:
+			// Words like "foo-bar" tokenize to several terms, keep them together
+			q.Phrases = append(q.Phrases, tokens)
+		}
+	}
+
+	for len(s) > 0 {
+		s = strings.TrimLeftFunc(s, unicode.IsSpace)
+		if s == "" {
+			break
+		}
+		if s[0] == '"' {
+			end := strings.IndexByte(s[1:], '"')
+			if end < 0 {
+				addWords(s[1:])
+				break
+			}
+			addWords(s[1 : end+1])
+			s = s[end+2:]
+			continue
+		}
+		end := strings.IndexFunc(s, unicode.IsSpace)
+		if end < 0 {
+			end = len(s)
+		}
+		word := s[:end]
+		s = s[end:]
+		if tag, ok := strings.CutPrefix(word, "tag:"); ok {
+			if tag != "" {
+				q.Tags = append(q.Tags, strings.ToLower(tag))
+			}
+			continue
+		}
+		addWords(word)
+	}
+	return q
+}
+
+func (q Query) Empty() bool {
+	return len(q.Terms) == 0 && len(q.Phrases) == 0 && len(q.Tags) == 0
+}
+
+// allTerms returns the unique terms of the query, including those in phrases
+func (q Query) allTerms() []string {
+	terms := slices.Clone(q.Terms)
+	for _, phrase := range q.Phrases {
+		terms = append(terms, phrase...)
+	}
+	slices.Sort(terms)
+	return slices.Compact(terms)
+}
+
+func tokenize(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// stripHTML removes tags from rendered markdown, leaving the text content
+func stripHTML(s string) string {
+	var sb strings.Builder
+	inTag := false
+	for _, r := range s {
+		switch {
+		case r == '<':
+			inTag = true
+		case r == '>' && inTag:
+			inTag = false
+			sb.WriteRune(' ')
+		case !inTag:
+			sb.WriteRune(r)
+		}
+	}
+	return html.UnescapeString(sb.String())
+}
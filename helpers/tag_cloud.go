@@ -7,7 +7,6 @@ import (
 	"sort"
 	"strings"
 
-	"github.com/sokkalf/hubro/config"
 	"github.com/sokkalf/hubro/index"
 	"github.com/sokkalf/hubro/utils"
 )
@@ -30,7 +29,7 @@ func TagCloudInit(idx *index.Index) {
 func tagCloudMap(idx *index.Index) map[string]int {
 	tagCloud := make(map[string]int)
 	for _, entry := range idx.GetEntries() {
-		if !entry.Visible {
+		if !entry.Visible || entry.Scheduled {
 			continue
 		}
 		for _, tag := range entry.Tags {
@@ -40,7 +39,7 @@ func tagCloudMap(idx *index.Index) map[string]int {
 	return tagCloud
 }
 
-func GenerateTagCloud(idx *index.Index) template.HTML {
+func GenerateTagCloud(idx *index.Index, rootPath string) template.HTML {
 	if t, ok := globalCache.get(idx); ok {
 		return *t
 	}
@@ -70,7 +69,7 @@ func GenerateTagCloud(idx *index.Index) template.HTML {
 		return fmt.Sprintf(
 			`<span class="%s"><a data-hx-boost="true" href="%s?tag=%s">%s</a></span>%s`,
 			class,
-			config.Config.RootPath,
+			rootPath,
 			tag,
 			tag,
 			"\n",
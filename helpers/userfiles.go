@@ -6,12 +6,12 @@ import (
 	"github.com/sokkalf/hubro/config"
 )
 
-func GetLogoImage() template.HTML {
-	switch config.Config.LogoImage {
+func GetLogoImage(c *config.HubroConfig) template.HTML {
+	switch c.LogoImage {
 	case "":
 		return template.HTML(`<span class="text-6xl">🦉</span>`)
 	default:
-		return template.HTML(`<img src="` + config.Config.RootPath +
-			"userfiles/" + config.Config.LogoImage + `" alt="Logo" class="avatar">`)
+		return template.HTML(`<img src="` + c.RootPath +
+			"userfiles/" + c.LogoImage + `" alt="Logo" class="avatar">`)
 	}
 }
@@ -0,0 +1,421 @@
+// Package images makes resized and re-encoded copies of the images in the
+// userfiles directory, in the widths and formats configured, and keeps them
+// on disk. The markdown extension in this package links to them.
+package images
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"io/fs"
+	"log/slog"
+	"math"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	_ "image/gif"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+// Formats variants can be written in. Original keeps the format of each
+// image, except WebP, which can only be read, and is written as PNG.
+const (
+	Original = "original"
+	JPEG     = "jpeg"
+	PNG      = "png"
+)
+
+// ErrNoVariant is returned for a variant that is not configured, or of a
+// file that is not an image that can be resized
+var ErrNoVariant = errors.New("no such image variant")
+
+// Options configure a pipeline
+type Options struct {
+	// Dir holds the original images, which are served at OriginalPath
+	Dir          string
+	OriginalPath string
+	// CacheDir holds the variants, which are served at VariantPath
+	CacheDir    string
+	VariantPath string
+	Widths      []int
+	Formats     []string
+	// Quality of JPEG variants, from 1 to 100
+	Quality int
+	// Pregenerate makes all variants of an image when a page linking to it
+	// is rendered, rather than each one when it is first requested
+	Pregenerate bool
+	// MaxPixels is the size, width times height, of the largest image that
+	// is resized, since decoding takes memory for every pixel. 0 is no limit.
+	MaxPixels int
+}
+
+// Info describes an original image. Width and Height are as displayed, after
+// the orientation from its EXIF data.
+type Info struct {
+	Width  int
+	Height int
+	Format string
+
+	orientation int
+	modTime     time.Time
+}
+
+type keyLock struct {
+	sync.Mutex
+	users int
+}
+
+// Pipeline makes variants of images on demand, one at a time per variant,
+// and at most one per CPU at once
+type Pipeline struct {
+	opts  Options
+	mtx   sync.Mutex
+	info  map[string]Info
+	locks map[string]*keyLock
+	work  chan struct{}
+	queue chan string
+}
+
+func New(opts Options) *Pipeline {
+	opts.Widths = slices.Clone(opts.Widths)
+	slices.Sort(opts.Widths)
+	opts.Widths = slices.Compact(opts.Widths)
+	p := &Pipeline{
+		opts:  opts,
+		info:  map[string]Info{},
+		locks: map[string]*keyLock{},
+		work:  make(chan struct{}, runtime.NumCPU()),
+	}
+	if opts.Pregenerate {
+		p.queue = make(chan string, 1000)
+		go p.pregenerate()
+	}
+	return p
+}
+
+func (p *Pipeline) original(name string) string {
+	return filepath.Join(p.opts.Dir, filepath.FromSlash(name))
+}
+
+// validName reports whether name is a file below the userfiles directory
+// that is not hidden
+func validName(name string) bool {
+	if !fs.ValidPath(name) || name == "." || strings.Contains(name, "\\") {
+		return false
+	}
+	return !slices.ContainsFunc(strings.Split(name, "/"), func(s string) bool { return strings.HasPrefix(s, ".") })
+}
+
+// Source returns the name of the original image a link points to, if it is
+// one that is served from the userfiles directory
+func (p *Pipeline) Source(link string) (string, bool) {
+	u, err := url.Parse(link)
+	if err != nil || u.Scheme != "" || u.Host != "" || u.RawQuery != "" {
+		return "", false
+	}
+	name, ok := strings.CutPrefix(u.Path, p.opts.OriginalPath)
+	if !ok || !validName(name) {
+		return "", false
+	}
+	return name, true
+}
+
+// Info returns the size and format of an original image. It is read once
+// for every version of the file.
+func (p *Pipeline) Info(name string) (Info, error) {
+	if !validName(name) {
+		return Info{}, ErrNoVariant
+	}
+	fi, err := os.Stat(p.original(name))
+	if err != nil {
+		return Info{}, err
+	}
+	p.mtx.Lock()
+	info, ok := p.info[name]
+	p.mtx.Unlock()
+	if ok && info.modTime.Equal(fi.ModTime()) {
+		return info, nil
+	}
+	f, err := os.Open(p.original(name))
+	if err != nil {
+		return Info{}, err
+	}
+	defer f.Close()
+	config, format, err := image.DecodeConfig(bufio.NewReader(f))
+	if err != nil {
+		return Info{}, fmt.Errorf("%w: %s: %v", ErrNoVariant, name, err)
+	}
+	info = Info{Width: config.Width, Height: config.Height, Format: format, orientation: 1, modTime: fi.ModTime()}
+	if format == "jpeg" {
+		info.orientation = orientation(io.NewSectionReader(f, 0, 1<<16))
+		if info.orientation >= 5 {
+			info.Width, info.Height = info.Height, info.Width
+		}
+	}
+	p.mtx.Lock()
+	p.info[name] = info
+	p.mtx.Unlock()
+	return info, nil
+}
+
+// tooLarge reports whether an image has more pixels than may be decoded
+func (p *Pipeline) tooLarge(width int, height int) bool {
+	return p.opts.MaxPixels > 0 && int64(width)*int64(height) > int64(p.opts.MaxPixels)
+}
+
+// Formats returns the formats of the variants of an image, in the order
+// configured. GIFs are left as they are, since they may be animated, and so
+// are images over the size limit.
+func (p *Pipeline) Formats(info Info) []string {
+	if info.Format == "gif" || p.tooLarge(info.Width, info.Height) {
+		return nil
+	}
+	formats := []string{}
+	for _, format := range p.opts.Formats {
+		if format == Original {
+			format = info.Format
+			if format == "webp" {
+				format = PNG
+			}
+		}
+		if !slices.Contains(formats, format) {
+			formats = append(formats, format)
+		}
+	}
+	return formats
+}
+
+// version changes whenever the image does
+func version(info Info) string {
+	return strconv.FormatInt(info.modTime.UnixNano(), 36)
+}
+
+// URL returns the path a variant is served at, with the version of the image,
+// so that it can be cached until the image changes
+func (p *Pipeline) URL(name string, info Info, width int, format string) string {
+	escaped := (&url.URL{Path: name}).EscapedPath()
+	// Commas separate the candidates in a srcset
+	escaped = strings.ReplaceAll(escaped, ",", "%2C")
+	return p.opts.VariantPath + strconv.Itoa(width) + "/" + format + "/" + escaped + "?v=" + version(info)
+}
+
+// Current reports whether v is the version of an image in the URLs of its
+// variants
+func (p *Pipeline) Current(name string, v string) bool {
+	info, err := p.Info(name)
+	return err == nil && v == version(info)
+}
+
+// originalURL returns the path the original image is served at
+func (p *Pipeline) originalURL(name string) string {
+	return strings.ReplaceAll((&url.URL{Path: p.opts.OriginalPath + name}).EscapedPath(), ",", "%2C")
+}
+
+// candidate is an image in a srcset, and the width it is described by
+type candidate struct {
+	url   string
+	width int
+	// The width of the variant to make, 0 for the original
+	variant int
+}
+
+// candidates returns the images to offer for an image in format. Images are
+// never enlarged, so the widths smaller than the image are offered, and the
+// image at its own size: the original if it has the format, or the largest
+// variant, which is only resized down to the size of the image.
+func (p *Pipeline) candidates(name string, info Info, format string) []candidate {
+	candidates := []candidate{}
+	for _, width := range p.opts.Widths {
+		if width < info.Width {
+			candidates = append(candidates, candidate{p.URL(name, info, width, format), width, width})
+		}
+	}
+	if format == info.Format {
+		candidates = append(candidates, candidate{p.originalURL(name), info.Width, 0})
+	} else if largest := slices.Max(append([]int{0}, p.opts.Widths...)); largest >= info.Width {
+		candidates = append(candidates, candidate{p.URL(name, info, largest, format), info.Width, largest})
+	}
+	return candidates
+}
+
+// Srcset returns the srcset attribute of an image in format
+func (p *Pipeline) Srcset(name string, info Info, format string) string {
+	entries := []string{}
+	for _, c := range p.candidates(name, info, format) {
+		entries = append(entries, fmt.Sprintf("%s %dw", c.url, c.width))
+	}
+	return strings.Join(entries, ", ")
+}
+
+// lock serializes work on one key, and removes the lock when nobody needs it
+func (p *Pipeline) lock(key string) func() {
+	p.mtx.Lock()
+	l, ok := p.locks[key]
+	if !ok {
+		l = &keyLock{}
+		p.locks[key] = l
+	}
+	l.users++
+	p.mtx.Unlock()
+	l.Lock()
+	return func() {
+		l.Unlock()
+		p.mtx.Lock()
+		l.users--
+		if l.users == 0 {
+			delete(p.locks, key)
+		}
+		p.mtx.Unlock()
+	}
+}
+
+// Variant returns the file of a variant of an image, which is made if it
+// doesn't exist or is older than the image
+func (p *Pipeline) Variant(name string, width int, format string) (string, error) {
+	if !slices.Contains(p.opts.Widths, width) {
+		return "", ErrNoVariant
+	}
+	info, err := p.Info(name)
+	if err != nil {
+		return "", err
+	}
+	if !slices.Contains(p.Formats(info), format) {
+		return "", ErrNoVariant
+	}
+	file := filepath.Join(p.opts.CacheDir, strconv.Itoa(width), filepath.FromSlash(name)+"."+format)
+	fresh := func() bool {
+		fi, err := os.Stat(file)
+		return err == nil && !fi.ModTime().Before(info.modTime)
+	}
+	if fresh() {
+		return file, nil
+	}
+	unlock := p.lock(file)
+	defer unlock()
+	if fresh() {
+		return file, nil
+	}
+	start := time.Now()
+	if err := p.generate(name, info, width, format, file); err != nil {
+		return "", err
+	}
+	slog.Debug("Generated image variant", "image", name, "width", width, "format", format, "duration", time.Since(start))
+	return file, nil
+}
+
+// generate resizes an image to width, or leaves its size if it is narrower,
+// and writes it to file
+func (p *Pipeline) generate(name string, info Info, width int, format string, file string) error {
+	p.work <- struct{}{}
+	defer func() { <-p.work }()
+	f, err := os.Open(p.original(name))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	// The size is checked again, as the image may have changed since it
+	// was read, before a small file can take gigabytes to decode
+	config, _, err := image.DecodeConfig(bufio.NewReader(f))
+	if err != nil {
+		return fmt.Errorf("%w: %s: %v", ErrNoVariant, name, err)
+	}
+	if p.tooLarge(config.Width, config.Height) {
+		return fmt.Errorf("%w: %s is %dx%d, more than %d pixels", ErrNoVariant, name, config.Width, config.Height, p.opts.MaxPixels)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	src, _, err := image.Decode(bufio.NewReader(f))
+	if err != nil {
+		return err
+	}
+	w := min(width, info.Width)
+	h := max(1, int(math.Round(float64(info.Height)*float64(w)/float64(info.Width))))
+	// The image is resized as stored, and turned afterwards
+	if info.orientation >= 5 {
+		w, h = h, w
+	}
+	rect := image.Rect(0, 0, w, h)
+	var dst draw.Image
+	if format == JPEG {
+		// JPEG has no transparency, so transparent images are put on white
+		rgba := image.NewRGBA(rect)
+		draw.Draw(rgba, rect, image.White, image.Point{}, draw.Src)
+		draw.CatmullRom.Scale(rgba, rect, src, src.Bounds(), draw.Over, nil)
+		dst = rgba
+	} else {
+		nrgba := image.NewNRGBA(rect)
+		draw.CatmullRom.Scale(nrgba, rect, src, src.Bounds(), draw.Src, nil)
+		dst = nrgba
+	}
+	out := orient(dst, info.orientation)
+
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(file), ".hubro-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	switch format {
+	case JPEG:
+		err = jpeg.Encode(tmp, out, &jpeg.Options{Quality: p.opts.Quality})
+	case PNG:
+		err = png.Encode(tmp, out)
+	default:
+		err = fmt.Errorf("%w: can't write %s", ErrNoVariant, format)
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), file)
+}
+
+// Prepare queues all variants of an image to be made in the background, if
+// the pipeline pregenerates them. Images are skipped if the queue is full,
+// their variants are then made when requested.
+func (p *Pipeline) Prepare(name string) {
+	if p.queue == nil {
+		return
+	}
+	select {
+	case p.queue <- name:
+	default:
+	}
+}
+
+func (p *Pipeline) pregenerate() {
+	for name := range p.queue {
+		info, err := p.Info(name)
+		if err != nil {
+			continue
+		}
+		for _, format := range p.Formats(info) {
+			for _, c := range p.candidates(name, info, format) {
+				if c.variant == 0 {
+					continue
+				}
+				if _, err := p.Variant(name, c.variant, format); err != nil {
+					slog.Error("Error generating image variant", "image", name, "width", c.variant, "format", format, "error", err)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,271 @@
+package images
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/parser"
+)
+
+func testPipeline(t *testing.T, formats ...string) (*Pipeline, string) {
+	dir := t.TempDir()
+	p := New(Options{
+		Dir:          dir,
+		OriginalPath: "/userfiles/",
+		CacheDir:     filepath.Join(t.TempDir(), "cache"),
+		VariantPath:  "/images/",
+		Widths:       []int{80, 40, 200},
+		Formats:      formats,
+		Quality:      90,
+	})
+	return p, dir
+}
+
+// halves returns an image with a red left half and a blue right half
+func halves(w, h int) image.Image {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := range h {
+		for x := range w {
+			c := color.NRGBA{R: 255, A: 255}
+			if x >= w/2 {
+				c = color.NRGBA{B: 255, A: 255}
+			}
+			img.Set(x, y, c)
+		}
+	}
+	return img
+}
+
+func writePNG(t *testing.T, file string, img image.Image) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(file, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func decode(t *testing.T, file string) (image.Image, string) {
+	f, err := os.Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	img, format, err := image.Decode(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return img, format
+}
+
+func TestVariants(t *testing.T) {
+	p, dir := testPipeline(t, Original, JPEG)
+	writePNG(t, filepath.Join(dir, "a.png"), halves(100, 50))
+
+	info, err := p.Info("a.png")
+	if err != nil || info.Width != 100 || info.Height != 50 || info.Format != "png" {
+		t.Fatalf("info: %v, %+v", err, info)
+	}
+	if got := p.Formats(info); strings.Join(got, ",") != "png,jpeg" {
+		t.Errorf("formats: %v", got)
+	}
+	// Variants carry the version of the image, the original doesn't
+	v := "?v=" + version(info)
+	if got, want := p.Srcset("a.png", info, PNG), "/images/40/png/a.png"+v+" 40w, /images/80/png/a.png"+v+" 80w, /userfiles/a.png 100w"; got != want {
+		t.Errorf("png srcset: got %q, want %q", got, want)
+	}
+	// The original is not a JPEG, so the widest variant stands in for it
+	if got, want := p.Srcset("a.png", info, JPEG), "/images/40/jpeg/a.png"+v+" 40w, /images/80/jpeg/a.png"+v+" 80w, /images/200/jpeg/a.png"+v+" 100w"; got != want {
+		t.Errorf("jpeg srcset: got %q, want %q", got, want)
+	}
+
+	file, err := p.Variant("a.png", 80, PNG)
+	if err != nil {
+		t.Fatalf("variant: %v", err)
+	}
+	img, format := decode(t, file)
+	if format != "png" || img.Bounds().Dx() != 80 || img.Bounds().Dy() != 40 {
+		t.Errorf("expected an 80x40 png, got a %dx%d %s", img.Bounds().Dx(), img.Bounds().Dy(), format)
+	}
+	file, err = p.Variant("a.png", 200, JPEG)
+	if err != nil {
+		t.Fatalf("variant: %v", err)
+	}
+	img, format = decode(t, file)
+	if format != "jpeg" || img.Bounds().Dx() != 100 {
+		t.Errorf("expected the image not to be enlarged, got a %d wide %s", img.Bounds().Dx(), format)
+	}
+
+	for _, c := range []struct {
+		name   string
+		width  int
+		format string
+	}{{"a.png", 50, PNG}, {"a.png", 80, "gif"}, {"../a.png", 80, PNG}, {".hidden.png", 80, PNG}} {
+		if _, err := p.Variant(c.name, c.width, c.format); !errors.Is(err, ErrNoVariant) {
+			t.Errorf("expected no variant %+v, got %v", c, err)
+		}
+	}
+
+	// Variants are made again when the image changes
+	later := time.Now().Add(time.Minute)
+	writePNG(t, filepath.Join(dir, "a.png"), halves(60, 60))
+	os.Chtimes(filepath.Join(dir, "a.png"), later, later)
+	if p.Current("a.png", version(info)) {
+		t.Errorf("expected the version to change with the image")
+	}
+	file, err = p.Variant("a.png", 40, PNG)
+	if err != nil {
+		t.Fatalf("variant: %v", err)
+	}
+	if img, _ := decode(t, file); img.Bounds().Dy() != 40 {
+		t.Errorf("expected a 40x40 variant of the new image, got %v", img.Bounds())
+	}
+}
+
+// TestPixelLimit checks that images over the size limit are not decoded,
+// also when one is replaced after its size was read.
+func TestPixelLimit(t *testing.T) {
+	p, dir := testPipeline(t, Original)
+	p.opts.MaxPixels = 4000
+	writePNG(t, filepath.Join(dir, "large.png"), halves(100, 50))
+	info, err := p.Info("large.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if formats := p.Formats(info); len(formats) != 0 {
+		t.Errorf("expected no variants of an image over the limit, got %v", formats)
+	}
+	if _, err := p.Variant("large.png", 40, PNG); !errors.Is(err, ErrNoVariant) {
+		t.Errorf("expected ErrNoVariant, got %v", err)
+	}
+
+	writePNG(t, filepath.Join(dir, "small.png"), halves(60, 60))
+	info, err = p.Info("small.png")
+	if err != nil || len(p.Formats(info)) != 1 {
+		t.Fatalf("expected variants of an image under the limit, got %v, %v", err, p.Formats(info))
+	}
+	writePNG(t, filepath.Join(dir, "small.png"), halves(100, 100))
+	file := filepath.Join(t.TempDir(), "small.png.png")
+	if err := p.generate("small.png", info, 40, PNG, file); !errors.Is(err, ErrNoVariant) {
+		t.Errorf("expected ErrNoVariant for an image that grew over the limit, got %v", err)
+	}
+	if _, err := os.Stat(file); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected no variant to be written, got %v", err)
+	}
+}
+
+// exifJPEG returns a JPEG with an EXIF orientation
+func exifJPEG(t *testing.T, img image.Image, orientation byte) []byte {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 95}); err != nil {
+		t.Fatal(err)
+	}
+	tiff := []byte{'M', 'M', 0, 42, 0, 0, 0, 8, 0, 1, 0x01, 0x12, 0, 3, 0, 0, 0, 1, 0, orientation, 0, 0, 0, 0, 0, 0}
+	app1 := append([]byte("Exif\x00\x00"), tiff...)
+	segment := append([]byte{0xff, 0xe1, byte((len(app1) + 2) >> 8), byte(len(app1) + 2)}, app1...)
+	data := buf.Bytes()
+	return append(append(data[:2:2], segment...), data[2:]...)
+}
+
+func TestOrientation(t *testing.T) {
+	p, dir := testPipeline(t, Original)
+	// Turned a quarter to the left, as a camera held upright would store it
+	if err := os.WriteFile(filepath.Join(dir, "photo.jpg"), exifJPEG(t, halves(80, 40), 6), 0644); err != nil {
+		t.Fatal(err)
+	}
+	info, err := p.Info("photo.jpg")
+	if err != nil || info.Width != 40 || info.Height != 80 {
+		t.Fatalf("expected the size as displayed, 40x80, got %v, %+v", err, info)
+	}
+	file, err := p.Variant("photo.jpg", 200, JPEG)
+	if err != nil {
+		t.Fatalf("variant: %v", err)
+	}
+	img, _ := decode(t, file)
+	if img.Bounds().Dx() != 40 || img.Bounds().Dy() != 80 {
+		t.Fatalf("expected a 40x80 variant, got %v", img.Bounds())
+	}
+	// Turning it to the right puts the left half on top
+	if r, _, b, _ := img.At(20, 10).RGBA(); r < b {
+		t.Errorf("expected red at the top, got %v", img.At(20, 10))
+	}
+	if r, _, b, _ := img.At(20, 70).RGBA(); b < r {
+		t.Errorf("expected blue at the bottom, got %v", img.At(20, 70))
+	}
+}
+
+func TestSource(t *testing.T) {
+	p, _ := testPipeline(t, Original)
+	for link, want := range map[string]string{
+		"/userfiles/a.png":                    "a.png",
+		"/userfiles/photos/b%20.jpg":          "photos/b .jpg",
+		"/userfiles/../a.png":                 "",
+		"/userfiles/.cache/a.png":             "",
+		"/static/a.png":                       "",
+		"https://example.org/userfiles/a.png": "",
+		"/userfiles/a.png?w=100":              "",
+	} {
+		name, ok := p.Source(link)
+		if name != want || ok != (want != "") {
+			t.Errorf("%s: got %q, %v, want %q", link, name, ok, want)
+		}
+	}
+}
+
+func TestMarkdown(t *testing.T) {
+	p, dir := testPipeline(t, Original, JPEG)
+	writePNG(t, filepath.Join(dir, "a.png"), halves(100, 50))
+	md := goldmark.New(goldmark.WithExtensions(Markdown))
+	render := func(source string, p *Pipeline) string {
+		var buf bytes.Buffer
+		pc := parser.NewContext()
+		SetPipeline(pc, p)
+		if err := md.Convert([]byte(source), &buf, parser.WithContext(pc)); err != nil {
+			t.Fatal(err)
+		}
+		return buf.String()
+	}
+
+	info, err := p.Info("a.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := "?v=" + version(info)
+	got := render(`![A photo](/userfiles/a.png "Title")`, p)
+	for _, want := range []string{
+		`<picture><source type="image/png" srcset="/images/40/png/a.png` + v + ` 40w, /images/80/png/a.png` + v + ` 80w, /userfiles/a.png 100w" sizes="(max-width: 100px) 100vw, 100px">`,
+		`<img src="/userfiles/a.png" alt="A photo" title="Title"`,
+		`width="100"`, `height="50"`, `loading="lazy"`,
+		`srcset="/images/40/jpeg/a.png` + v + ` 40w, /images/80/jpeg/a.png` + v + ` 80w, /images/200/jpeg/a.png` + v + ` 100w"`,
+		`</picture>`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("expected %s in %s", want, got)
+		}
+	}
+
+	p, dir = testPipeline(t, Original)
+	writePNG(t, filepath.Join(dir, "a.png"), halves(100, 50))
+	if got := render(`![](/userfiles/a.png)`, p); strings.Contains(got, "<picture>") || !strings.Contains(got, `srcset="/images/40/png/a.png?v=`) {
+		t.Errorf("expected an image with a srcset for one format, got %s", got)
+	}
+	for _, source := range []string{`![](/userfiles/missing.png)`, `![](https://example.org/userfiles/a.png)`} {
+		if got := render(source, p); strings.Contains(got, "srcset") {
+			t.Errorf("expected %s to be left alone, got %s", source, got)
+		}
+	}
+	if got := render(`![](/userfiles/a.png)`, nil); got != "<p><img src=\"/userfiles/a.png\" alt=\"\"></p>\n" {
+		t.Errorf("expected images to be left alone without a pipeline, got %s", got)
+	}
+}
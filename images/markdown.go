@@ -0,0 +1,136 @@
+package images
+
+import (
+	"fmt"
+	"mime"
+	"strconv"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+var pipelineKey = parser.NewContextKey()
+
+// SetPipeline makes the images in a document converted with pc use p. The
+// markdown parser is shared by all sites, so the pipeline of the site is
+// given with every document.
+func SetPipeline(pc parser.Context, p *Pipeline) {
+	if p != nil {
+		pc.Set(pipelineKey, p)
+	}
+}
+
+// Markdown is a goldmark extension that renders images from the userfiles
+// directory with their size, lazily loaded, and with a srcset of their
+// variants. If more than one format is configured, the image is wrapped in a
+// picture element with a source for each format but the last.
+var Markdown goldmark.Extender = &extension{}
+
+type extension struct{}
+
+func (e *extension) Extend(m goldmark.Markdown) {
+	m.Parser().AddOptions(parser.WithASTTransformers(util.Prioritized(&transformer{}, 500)))
+	m.Renderer().AddOptions(renderer.WithNodeRenderers(util.Prioritized(&pictureRenderer{}, 500)))
+}
+
+var kindPicture = ast.NewNodeKind("Picture")
+
+type source struct {
+	contentType string
+	srcset      string
+}
+
+// picture wraps an image, and offers it in other formats
+type picture struct {
+	ast.BaseInline
+	sources []source
+	sizes   string
+}
+
+func (n *picture) Kind() ast.NodeKind {
+	return kindPicture
+}
+
+func (n *picture) Dump(src []byte, level int) {
+	ast.DumpHelper(n, src, level, nil, nil)
+}
+
+type transformer struct{}
+
+func (t *transformer) Transform(doc *ast.Document, reader text.Reader, pc parser.Context) {
+	p, ok := pc.Get(pipelineKey).(*Pipeline)
+	if !ok {
+		return
+	}
+	images := []*ast.Image{}
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if img, ok := n.(*ast.Image); ok && entering {
+			images = append(images, img)
+		}
+		return ast.WalkContinue, nil
+	})
+	for _, img := range images {
+		name, ok := p.Source(string(img.Destination))
+		if !ok {
+			continue
+		}
+		info, err := p.Info(name)
+		if err != nil {
+			continue
+		}
+		img.SetAttributeString("width", []byte(strconv.Itoa(info.Width)))
+		img.SetAttributeString("height", []byte(strconv.Itoa(info.Height)))
+		img.SetAttributeString("loading", []byte("lazy"))
+		formats := p.Formats(info)
+		if len(formats) == 0 {
+			continue
+		}
+		p.Prepare(name)
+		// The image is shown at most at its own width
+		sizes := fmt.Sprintf("(max-width: %dpx) 100vw, %dpx", info.Width, info.Width)
+		last := formats[len(formats)-1]
+		img.SetAttributeString("srcset", []byte(p.Srcset(name, info, last)))
+		img.SetAttributeString("sizes", []byte(sizes))
+		if len(formats) == 1 {
+			continue
+		}
+		pic := &picture{sizes: sizes}
+		for _, format := range formats[:len(formats)-1] {
+			pic.sources = append(pic.sources, source{mime.TypeByExtension("." + format), p.Srcset(name, info, format)})
+		}
+		parent := img.Parent()
+		parent.ReplaceChild(parent, img, pic)
+		pic.AppendChild(pic, img)
+	}
+}
+
+type pictureRenderer struct{}
+
+func (r *pictureRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(kindPicture, r.render)
+}
+
+func (r *pictureRenderer) render(w util.BufWriter, src []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		_, _ = w.WriteString("</picture>")
+		return ast.WalkContinue, nil
+	}
+	n := node.(*picture)
+	_, _ = w.WriteString("<picture>")
+	for _, s := range n.sources {
+		_, _ = w.WriteString(`<source type="`)
+		_, _ = w.Write(util.EscapeHTML([]byte(s.contentType)))
+		_, _ = w.WriteString(`" srcset="`)
+		_, _ = w.Write(util.EscapeHTML([]byte(s.srcset)))
+		_, _ = w.WriteString(`" sizes="`)
+		_, _ = w.Write(util.EscapeHTML([]byte(n.sizes)))
+		_, _ = w.WriteString(`">`)
+	}
+	return ast.WalkContinue, nil
+}
+
+var _ renderer.NodeRenderer = (*pictureRenderer)(nil)
@@ -1,15 +1,16 @@
 package index
 
 import (
+	"context"
 	"fmt"
 	"html/template"
-	"io/fs"
 	"log/slog"
 	"slices"
 	"sort"
 	"sync"
 	"time"
 
+	"github.com/sokkalf/hubro/content"
 	"github.com/sokkalf/hubro/utils/broker"
 )
 
@@ -18,24 +19,30 @@ type Indices map[string]*Index
 var indices Indices
 
 type IndexEntry struct {
-	Id          string         `json:"id"`
-	Slug        string         `json:"slug"`
-	Title       string         `json:"title"`
-	ShortTitle  string         `json:"shortTitle"`
-	Author      string         `json:"author"`
-	Path        string         `json:"path"`
-	Date        time.Time      `json:"date"`
-	SortOrder   int            `json:"sortOrder"`
-	Metadata    map[string]any `json:"metadata"`
-	Visible     bool           `json:"visible"`
-	HideAuthor  bool           `json:"hideAuthor"`
-	HideTitle   bool           `json:"hideTitle"`
-	Tags        []string       `json:"tags"`
-	Summary     *template.HTML `json:"summary"`
-	Body        *template.HTML `json:"body"`
-	Description string         `json:"description"`
-	FileName    string         `json:"fileName"`
-	Draft       bool           `json:"draft"`
+	Id         string    `json:"id"`
+	Slug       string    `json:"slug"`
+	Title      string    `json:"title"`
+	ShortTitle string    `json:"shortTitle"`
+	Author     string    `json:"author"`
+	Path       string    `json:"path"`
+	Date       time.Time `json:"date"`
+	ModTime    time.Time `json:"modTime"`
+	// Created and LastModified are when the file was first and last changed,
+	// taken from the history of the content store if it has one
+	Created      time.Time      `json:"created"`
+	LastModified time.Time      `json:"lastModified"`
+	SortOrder    int            `json:"sortOrder"`
+	Metadata     map[string]any `json:"metadata"`
+	Visible      bool           `json:"visible"`
+	HideAuthor   bool           `json:"hideAuthor"`
+	HideTitle    bool           `json:"hideTitle"`
+	Tags         []string       `json:"tags"`
+	Summary      *template.HTML `json:"summary"`
+	Body         *template.HTML `json:"body"`
+	Description  string         `json:"description"`
+	FileName     string         `json:"fileName"`
+	Draft        bool           `json:"draft"`
+	Scheduled    bool           `json:"scheduled"`
 }
 
 type Message int
@@ -54,8 +61,7 @@ type Index struct {
 	mtx        sync.RWMutex
 	sortMode   int
 	MsgBroker  *broker.Broker[Message]
-	FilesDir   fs.FS
-	DirPath    string
+	Store      content.ContentStore
 }
 
 const (
@@ -63,12 +69,17 @@ const (
 	SortByDate
 )
 
+// NewIndex creates an index in the global indices
 func NewIndex(name string, rootPath string) *Index {
 	if indices == nil {
 		indices = make(Indices)
 	}
+	return indices.NewIndex(name, rootPath)
+}
 
-	if i, ok := indices[name]; ok {
+// NewIndex creates an index in in, or returns the existing one with the same name
+func (in Indices) NewIndex(name string, rootPath string) *Index {
+	if i, ok := in[name]; ok {
 		slog.Error("Index already exists", "name", name)
 		return i
 	}
@@ -83,12 +94,16 @@ func NewIndex(name string, rootPath string) *Index {
 	entry.MsgBroker = broker.NewBroker[Message]()
 	go entry.MsgBroker.Start()
 
-	indices[name] = entry
+	in[name] = entry
 	return entry
 }
 
 func GetIndex(name string) *Index {
-	if i, ok := indices[name]; ok {
+	return indices.GetIndex(name)
+}
+
+func (in Indices) GetIndex(name string) *Index {
+	if i, ok := in[name]; ok {
 		return i
 	}
 	return nil
@@ -98,6 +113,15 @@ func GetIndices() Indices {
 	return indices
 }
 
+// Watch publishes Scanned whenever the files in the store of the index change,
+// until ctx is done
+func (i *Index) Watch(ctx context.Context) error {
+	return i.Store.Watch(ctx, func() {
+		slog.Info("Starting content scan", "index", i.name)
+		i.MsgBroker.Publish(Scanned)
+	})
+}
+
 func (i *Index) GetName() string {
 	return i.name
 }
@@ -233,6 +257,39 @@ func (i *Index) SortByDate() {
 	})
 }
 
+// NextScheduled returns the earliest date among entries waiting to be published
+func (i *Index) NextScheduled() (time.Time, bool) {
+	i.mtx.RLock()
+	defer i.mtx.RUnlock()
+	var next time.Time
+	found := false
+	for _, entry := range i.entries {
+		if entry.Scheduled && (!found || entry.Date.Before(next)) {
+			next = entry.Date
+			found = true
+		}
+	}
+	return next, found
+}
+
+// PublishScheduled makes scheduled entries dated at or before now visible,
+// and returns the number of entries published
+func (i *Index) PublishScheduled(now time.Time) int {
+	i.mtx.Lock()
+	defer i.mtx.Unlock()
+	published := 0
+	for j := range i.entries {
+		if i.entries[j].Scheduled && !i.entries[j].Date.After(now) {
+			i.entries[j].Scheduled = false
+			e := i.entries[j]
+			i.lookup[e.Id] = &e
+			i.slugLookup[e.Slug] = &e
+			published++
+		}
+	}
+	return published
+}
+
 func (i *Index) Count() int {
 	i.mtx.RLock()
 	defer i.mtx.RUnlock()
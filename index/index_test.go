@@ -277,3 +277,48 @@ func TestDeleteEntry(t *testing.T) {
 		}
 	})
 }
+
+// TestPublishScheduled verifies that scheduled entries are published once their date has passed.
+func TestPublishScheduled(t *testing.T) {
+	name := "scheduledTest"
+	rootPath := "/"
+	delete(indices, name)
+
+	idx := NewIndex(name, rootPath)
+	now := time.Now()
+
+	if _, ok := idx.NextScheduled(); ok {
+		t.Errorf("expected no scheduled entries in an empty index")
+	}
+
+	idx.AddEntry(IndexEntry{Id: "published", Slug: "published", Date: now.Add(-time.Hour)})
+	idx.AddEntry(IndexEntry{Id: "soon", Slug: "soon", Date: now.Add(time.Hour), Scheduled: true})
+	idx.AddEntry(IndexEntry{Id: "later", Slug: "later", Date: now.Add(48 * time.Hour), Scheduled: true})
+
+	next, ok := idx.NextScheduled()
+	if !ok || !next.Equal(now.Add(time.Hour)) {
+		t.Errorf("expected next scheduled date %v, got %v (found: %v)", now.Add(time.Hour), next, ok)
+	}
+
+	if n := idx.PublishScheduled(now); n != 0 {
+		t.Errorf("expected no entries to be published yet, got %d", n)
+	}
+
+	if n := idx.PublishScheduled(now.Add(2 * time.Hour)); n != 1 {
+		t.Fatalf("expected 1 entry to be published, got %d", n)
+	}
+	if idx.GetEntry("soon").Scheduled {
+		t.Errorf("expected entry to be published in the ID lookup")
+	}
+	if idx.GetEntryBySlug("soon").Scheduled {
+		t.Errorf("expected entry to be published in the slug lookup")
+	}
+	if !idx.GetEntry("later").Scheduled {
+		t.Errorf("expected later entry to still be scheduled")
+	}
+
+	next, ok = idx.NextScheduled()
+	if !ok || !next.Equal(now.Add(48*time.Hour)) {
+		t.Errorf("expected next scheduled date %v, got %v (found: %v)", now.Add(48*time.Hour), next, ok)
+	}
+}
@@ -19,10 +19,10 @@ func replaceDuration(groups []string, attr slog.Attr) slog.Attr {
 }
 
 func InitLogger() func() {
-	env := config.Config.Environment
+	env := config.Get().Environment
 	if env != "development" {
-		if config.Config.SeqEndpoint != nil {
-			return InitSeqLog(slog.LevelInfo, *config.Config.SeqEndpoint, *config.Config.SeqAPIKey)
+		if config.Get().SeqEndpoint != nil {
+			return InitSeqLog(slog.LevelInfo, *config.Get().SeqEndpoint, *config.Get().SeqAPIKey)
 		} else {
 			slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelInfo})))
 		}
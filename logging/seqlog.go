@@ -1,6 +1,7 @@
 package logging
 
 import (
+	"context"
 	"log/slog"
 	"os"
 	"time"
@@ -24,13 +25,19 @@ func InitSeqLog(logLevel slog.Level, seqEndpoint string, seqAPIKey string) (clos
 	textLogger := slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: logLevel})
 	logger := slog.New(slogmulti.Fanout(handler, textLogger))
 	slog.SetDefault(logger.With("appname", "hubro").
-		With("appversion", config.Config.Version).
-		With("environment", config.Config.Environment))
+		With("appversion", config.Get().Version).
+		With("environment", config.Get().Environment))
 
 	spanProcessor := trace.NewBatchSpanProcessor(&slogseq.LoggingSpanProcessor{Handler: handler})
 	tp := trace.NewTracerProvider(trace.WithSpanProcessor(spanProcessor), trace.WithSampler(trace.AlwaysSample()))
-	config.Config.Tracer = tp.Tracer("hubro")
+	config.Update(func(c *config.HubroConfig) { c.Tracer = tp.Tracer("hubro") })
 	return func() {
+		// Flush spans before closing the handler they are logged to
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := tp.Shutdown(ctx); err != nil {
+			slog.Error("Error shutting down tracer provider", "error", err)
+		}
 		handler.Close()
 	}
 }
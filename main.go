@@ -6,50 +6,111 @@ import (
 	"io/fs"
 	"log/slog"
 	"os"
+	"path/filepath"
+	"strings"
 	"sync"
 	"time"
 
 	pagesAPI "github.com/sokkalf/hubro/api/pages"
+	searchAPI "github.com/sokkalf/hubro/api/search"
 	"github.com/sokkalf/hubro/config"
+	"github.com/sokkalf/hubro/content"
+	"github.com/sokkalf/hubro/fulltext"
 	"github.com/sokkalf/hubro/gzip"
 	"github.com/sokkalf/hubro/helpers"
+	"github.com/sokkalf/hubro/images"
 	"github.com/sokkalf/hubro/index"
 	"github.com/sokkalf/hubro/logging"
 	"github.com/sokkalf/hubro/modules/admin"
 	"github.com/sokkalf/hubro/modules/feeds"
 	"github.com/sokkalf/hubro/modules/healthcheck"
+	imagevariants "github.com/sokkalf/hubro/modules/image_variants"
 	"github.com/sokkalf/hubro/modules/page"
 	"github.com/sokkalf/hubro/modules/redirects"
+	"github.com/sokkalf/hubro/modules/search"
+	"github.com/sokkalf/hubro/modules/sitemap"
 	userstatic "github.com/sokkalf/hubro/modules/user_static"
+	"github.com/sokkalf/hubro/modules/webhook"
 	"github.com/sokkalf/hubro/server"
-	"github.com/sokkalf/hubro/utils/watchfs"
+	"github.com/sokkalf/hubro/utils/overlayfs"
+	"github.com/sokkalf/hubro/view"
 )
 
 // Overwritten by the build system
 var Version = "v0.0.1-dev"
 
+const (
+	vendorPath   = "view/assets/vendor"
+	layoutPath   = "view/layouts"
+	templatePath = "view/templates"
+	publicPath   = "view/public"
+	staticPath   = "view/static"
+)
+
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "export":
+			os.Exit(runExport(os.Args[2:]))
+		case "config":
+			os.Exit(runConfig(os.Args[2:]))
+		case "users":
+			os.Exit(runUsers(os.Args[2:]))
+		}
+	}
+
 	start := time.Now()
-	config.Init()
-	config.Config.Version = Version
+	if !initConfig() {
+		os.Exit(1)
+	}
 	closeFunc := logging.InitLogger()
 	defer closeFunc()
 	ctx := context.Background()
-	tr := config.Config.Tracer
+	h := setup(ctx)
+	err := h.Start(ctx, start)
+	if err != nil {
+		slog.Error("Error starting Hubro", "error", err)
+	}
+}
+
+// setup creates the Hubro server and registers all modules. If several sites
+// are configured, the first one is served for unknown hosts, and the others
+// are added to it as virtual hosts.
+func setup(ctx context.Context) *server.Hubro {
+	tr := config.Get().Tracer
 	spanCtx, span := tr.Start(ctx, "main")
 	slog.InfoContext(spanCtx, "Starting Hubro 🦉")
-	vendorDir := os.DirFS("view/assets/vendor")
-	layoutDir := os.DirFS("view/layouts")
-	templateDir := os.DirFS("view/templates")
-	publicDir := os.DirFS("view/public")
+	span.End()
+	sites := config.Get().SiteNames()
+	if len(sites) == 0 {
+		return setupSite(spanCtx, "")
+	}
+	h := setupSite(spanCtx, sites[0])
+	for _, site := range sites[1:] {
+		h.AddVirtualHost(setupSite(spanCtx, site))
+	}
+	return h
+}
 
+// setupSite creates the Hubro instance for a site, with its own indices, and
+// registers all modules. The main configuration is used if site is empty.
+func setupSite(ctx context.Context, site string) *server.Hubro {
+	c := config.Get().Site(site)
+	tr := c.Tracer
+	spanCtx, span := tr.Start(ctx, "site setup")
+	if site != "" {
+		slog.InfoContext(spanCtx, "Setting up site", "site", site, "baseURL", c.BaseURL)
+	}
 	cfg := server.Config{
-		RootPath:    config.Config.RootPath,
-		Port:        config.Config.Port,
-		VendorDir:   vendorDir,
-		LayoutDir:   layoutDir,
-		TemplateDir: templateDir,
-		PublicDir:   publicDir,
+		Site:        site,
+		RootPath:    c.RootPath,
+		Port:        c.Port,
+		VendorDir:   viewDir(c.ThemeDir, vendorPath),
+		LayoutDir:   viewDir(c.ThemeDir, layoutPath),
+		TemplateDir: viewDir(c.ThemeDir, templatePath),
+		PublicDir:   viewDir(c.ThemeDir, publicPath),
+		StaticDir:   viewDir(c.ThemeDir, staticPath),
+		WatchDirs:   viewDirPaths(c.ThemeDir, layoutPath, templatePath),
 	}
 	h := server.NewHubro(cfg)
 	span.AddEvent("Initializing middleware")
@@ -61,33 +122,50 @@ func main() {
 	h.AddModule("/healthz", healthcheck.Register, nil)
 	span.End()
 	spanCtx, span = tr.Start(spanCtx, "Adding pages and blog entries")
+	if c.AdminEnabled {
+		// Files uploaded in the admin interface are served without a restart
+		if err := os.MkdirAll(c.UserStaticDir, 0755); err != nil {
+			slog.ErrorContext(spanCtx, "Error creating userfiles directory", "error", err)
+		}
+	}
 	var userStaticDir fs.FS
-	usd, err := os.Stat(config.Config.UserStaticDir)
+	usd, err := os.Stat(c.UserStaticDir)
 	if err != nil {
 		slog.InfoContext(spanCtx, "No userfiles directory found")
 	} else if usd.IsDir() {
-		userStaticDir = os.DirFS(config.Config.UserStaticDir)
+		userStaticDir = os.DirFS(c.UserStaticDir)
 		h.AddModule("/userfiles", userstatic.Register, userStaticDir)
 	} else {
 		slog.ErrorContext(spanCtx, "User static directory is not a directory")
 	}
+	var pipeline *images.Pipeline
+	if c.ImagesEnabled && userStaticDir != nil {
+		pipeline = images.New(images.Options{
+			Dir:          c.UserStaticDir,
+			OriginalPath: c.RootPath + "userfiles/",
+			CacheDir:     c.ImageCacheDir,
+			VariantPath:  c.RootPath + "images/",
+			Widths:       c.ImageWidths,
+			Formats:      c.ImageFormats,
+			Quality:      c.ImageQuality,
+			Pregenerate:  c.ImagePregenerate,
+			MaxPixels:    c.ImageMaxPixels,
+		})
+		h.AddModule("/images", imagevariants.Register, pipeline)
+	}
 	span.AddEvent("Creating indices for pages and blog entries")
-	pageIndex := index.NewIndex("pages", config.Config.RootPath+"page")
+	pageIndex := h.Indices().NewIndex("pages", c.RootPath+"page")
 	pageIndex.SetSortMode(index.SortBySortOrder)
-	blogIndex := index.NewIndex("blog", config.Config.RootPath+"blog")
+	blogIndex := h.Indices().NewIndex("blog", c.RootPath+"blog")
 	blogIndex.SetSortMode(index.SortByDate)
-	pagesDir, err := watchfs.WatchFS(config.Config.PagesDir, pageIndex)
-	if err != nil {
+	pageIndex.Store = contentStore(c, c.PagesDir)
+	blogIndex.Store = contentStore(c, c.BlogDir)
+	if err := pageIndex.Watch(h.Context()); err != nil {
 		slog.ErrorContext(spanCtx, "Error watching pages directory", "error", err)
 	}
-	blogDir, err := watchfs.WatchFS(config.Config.BlogDir, blogIndex)
-	if err != nil {
+	if err := blogIndex.Watch(h.Context()); err != nil {
 		slog.ErrorContext(spanCtx, "Error watching blog directory", "error", err)
 	}
-	pageIndex.FilesDir = *pagesDir
-	blogIndex.FilesDir = *blogDir
-	pageIndex.DirPath = config.Config.PagesDir
-	blogIndex.DirPath = config.Config.BlogDir
 	helpers.TagCloudInit(pageIndex)
 	helpers.TagCloudInit(blogIndex)
 	span.AddEvent("Searching for pages and blog entries")
@@ -95,31 +173,41 @@ func main() {
 	wg.Add(2)
 	go func() {
 		defer wg.Done()
-		h.AddModule("/page", page.Register, page.PageOptions{Index: pageIndex, Ctx: spanCtx})
+		h.AddModule("/page", page.Register, page.PageOptions{Index: pageIndex, Ctx: spanCtx, Images: pipeline})
 	}()
 	go func() {
 		defer wg.Done()
-		h.AddModule("/blog", page.Register, page.PageOptions{Index: blogIndex, Ctx: spanCtx})
+		h.AddModule("/blog", page.Register, page.PageOptions{Index: blogIndex, Ctx: spanCtx, Images: pipeline})
 	}()
 	wg.Wait()
+	span.AddEvent("Building search index")
+	searchEngine := fulltext.NewEngine()
+	searchEngine.Watch(pageIndex)
+	searchEngine.Watch(blogIndex)
+	h.AddModule("/search", search.Register, searchEngine)
 	span.End()
 	spanCtx, span = tr.Start(spanCtx, "Adding API endpoints, feeds and legacy routes")
 	span.AddEvent("Adding API endpoints")
 	h.AddModule("/api/pages", pagesAPI.Register, []*index.Index{pageIndex, blogIndex})
-	if config.Config.AdminEnabled {
-		h.AddModule("/admin", admin.Register, nil)
+	h.AddModule("/api/search", searchAPI.Register, searchEngine)
+	if c.AdminEnabled {
+		h.AddModule("/admin", admin.Register, pipeline)
+	}
+	if c.WebhookSecret != "" {
+		h.AddModule("/hooks", webhook.Register, []*index.Index{pageIndex, blogIndex})
 	}
 	span.AddEvent("Adding feeds")
-	if config.Config.FeedsEnabled {
+	if c.FeedsEnabled {
 		if blogIndex.Count() > 0 {
 			h.AddModule("/feeds", feeds.Register, blogIndex)
 		} else {
-			config.Config.FeedsEnabled = false
 			slog.InfoContext(spanCtx, "No blog entries found, skipping feeds")
 		}
 	}
+	span.AddEvent("Adding sitemap")
+	h.AddModule("", sitemap.Register, nil)
 	span.AddEvent("Adding legacy routes")
-	b, err := os.ReadFile(config.Config.LegacyRoutesFile)
+	b, err := os.ReadFile(c.LegacyRoutesFile)
 	if err != nil {
 		slog.Info("No legacy routes found")
 	} else {
@@ -134,8 +222,57 @@ func main() {
 		}
 	}
 	span.End()
-	err = h.Start(start)
+	return h
+}
+
+// contentStore returns the store for a content directory, which commits
+// every change if git is enabled
+func contentStore(c *config.HubroConfig, dir string) content.ContentStore {
+	if c.GitEnabled {
+		store, err := content.NewGitStore(dir, content.Author{Name: c.AuthorName, Email: c.AuthorEmail})
+		if err == nil {
+			return store
+		}
+		slog.Error("Error opening git repository, changes will not be committed", "directory", dir, "error", err)
+	}
+	return content.NewFSStore(dir)
+}
+
+// themePath returns where theme overrides the built-in view directory at path
+func themePath(theme string, path string) string {
+	return filepath.Join(theme, strings.TrimPrefix(path, "view/"))
+}
+
+func isDir(path string) bool {
+	fi, err := os.Stat(path)
+	return err == nil && fi.IsDir()
+}
+
+// viewDir returns the view directory at path compiled into the binary,
+// overlaid with the same directory on disk if it exists, and the theme.
+func viewDir(theme string, path string) fs.FS {
+	embedded, err := fs.Sub(view.FS, strings.TrimPrefix(path, "view/"))
 	if err != nil {
-		slog.Error("Error starting Hubro", "error", err)
+		panic(err)
+	}
+	layers := overlayfs.New()
+	for _, dir := range viewDirPaths(theme, path) {
+		layers = append(layers, os.DirFS(dir))
+	}
+	return append(layers, embedded)
+}
+
+// viewDirPaths returns the directories on disk overlaying the given view
+// directories, the theme first
+func viewDirPaths(theme string, paths ...string) []string {
+	dirs := []string{}
+	for _, path := range paths {
+		if theme != "" && isDir(themePath(theme, path)) {
+			dirs = append(dirs, themePath(theme, path))
+		}
+		if isDir(path) {
+			dirs = append(dirs, path)
+		}
 	}
+	return dirs
 }
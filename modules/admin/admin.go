@@ -4,48 +4,77 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io/fs"
 	"log/slog"
 	"net/http"
-	"os"
+	"strings"
 	"time"
+	"unicode"
 
 	"github.com/coder/websocket"
-	"github.com/sokkalf/hubro/config"
+	"github.com/sokkalf/hubro/content"
+	"github.com/sokkalf/hubro/images"
 	"github.com/sokkalf/hubro/index"
 	"github.com/sokkalf/hubro/modules/page"
+	"github.com/sokkalf/hubro/modules/webhook"
 	"github.com/sokkalf/hubro/server"
+	"github.com/sokkalf/hubro/sso"
+	"github.com/sokkalf/hubro/users"
 	"github.com/sokkalf/hubro/utils"
 	meta "github.com/yuin/goldmark-meta"
 	"github.com/yuin/goldmark/parser"
+	"gopkg.in/yaml.v2"
 )
 
+// Register adds the admin interface. options is the image pipeline of the
+// site, for the preview, or nil.
 func Register(prefix string, h *server.Hubro, mux *http.ServeMux, options any) {
 	slog.Info("Registering admin module")
-
-	mux.Handle("/", basicAuth(adminIndexHandler(h)))
-	mux.Handle("/edit", basicAuth(adminEditHandler(h)))
-	mux.Handle("/new", basicAuth(adminCreateHandler(h)))
-	mux.Handle("/ws", basicAuth(adminWebSocketHandler(h)))
-}
-
-func basicAuth(h http.HandlerFunc) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		username, password, ok := r.BasicAuth()
-		if !ok || username != "admin" || password != config.Config.AdminPassword {
-			w.Header().Set("WWW-Authenticate", `Basic realm="Restricted"`)
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			return
-		}
-		h(w, r)
+	pipeline, _ := options.(*images.Pipeline)
+
+	a := newAuth(h)
+	mux.Handle("GET /login", a.loginFormHandler())
+	mux.Handle("POST /login", a.loginHandler())
+	mux.Handle("POST /login/totp", a.totpLoginHandler())
+	mux.Handle("POST /logout", a.require(a.logoutHandler()))
+	mux.Handle("GET /account/totp", a.require(a.totpHandler()))
+	mux.Handle("POST /account/totp", a.require(a.totpEnableHandler()))
+	mux.Handle("POST /account/totp/disable", a.require(a.totpDisableHandler()))
+	ssoClient := sso.NewClient()
+	mux.Handle("GET /oidc/login", a.ssoLoginHandler(ssoClient))
+	mux.Handle("GET /oidc/callback", a.ssoCallbackHandler(ssoClient))
+	mux.Handle("/", a.require(adminIndexHandler(h)))
+	mux.Handle("/edit", a.require(adminEditHandler(h)))
+	mux.Handle("/new", a.require(adminCreateHandler(h)))
+	mux.Handle("/ws", a.require(adminWebSocketHandler(h, pipeline)))
+	mux.Handle("POST /config/reload", a.require(adminOnly(h, adminReloadConfigHandler(h))))
+	mux.Handle("GET /history", a.require(adminHistoryHandler(h)))
+	mux.Handle("GET /diff", a.require(adminDiffHandler(h)))
+	mux.Handle("POST /revert", a.require(adminRevertHandler(h)))
+	mux.Handle("GET /media", a.require(adminMediaHandler(h)))
+	mux.Handle("GET /media/files", a.require(adminMediaFilesHandler(h)))
+	mux.Handle("POST /media", limitUpload(h, a.require(adminMediaUploadHandler(h))))
+	mux.Handle("POST /media/delete", a.require(adminMediaDeleteHandler(h)))
+	mux.Handle("GET /users", a.require(adminOnly(h, adminUsersHandler(h))))
+	mux.Handle("POST /users/add", a.require(adminOnly(h, usersAction(h, "add", addUser))))
+	mux.Handle("POST /users/role", a.require(adminOnly(h, usersAction(h, "role", setRole))))
+	mux.Handle("POST /users/password", a.require(adminOnly(h, usersAction(h, "password", setPassword))))
+	mux.Handle("POST /users/delete", a.require(adminOnly(h, usersAction(h, "delete", deleteUser))))
+	mux.Handle("POST /users/totp/reset", a.require(adminOnly(h, usersAction(h, "reset two-factor", resetTOTP))))
+
+	if store, err := userStore(h); err != nil {
+		slog.Error("Error reading users", "file", h.Config().UsersFile, "error", err)
+	} else if store.Empty() && h.Config().OIDCIssuer == "" {
+		slog.Warn("No users found, log in as admin with the admin password and add users", "file", h.Config().UsersFile)
 	}
 }
 
-func renderMarkdown(markdown []byte) ([]byte, map[string]any, error) {
+func renderMarkdown(markdown []byte, pipeline *images.Pipeline) ([]byte, map[string]any, error) {
 	md := page.GetMarkdownParser()
 	var buf bytes.Buffer
 	context := parser.NewContext()
+	images.SetPipeline(context, pipeline)
 
 	err := md.Convert(markdown, &buf, parser.WithContext(context))
 	if err != nil {
@@ -58,15 +87,39 @@ func renderMarkdown(markdown []byte) ([]byte, map[string]any, error) {
 
 func adminIndexHandler(h *server.Hubro) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		indices := index.GetIndices()
-		h.RenderWithLayout(w, r, "admin/app", "admin/index", indices)
+		data := struct {
+			User           *users.User
+			Indices        index.Indices
+			WebhookEnabled bool
+			Webhook        []webhook.Result
+		}{
+			User:           currentUser(r),
+			Indices:        h.Indices(),
+			WebhookEnabled: webhook.Enabled(h),
+			Webhook:        webhook.Results(h),
+		}
+		h.RenderWithLayout(w, r, "admin/app", "admin/index", data)
+	}
+}
+
+func adminReloadConfigHandler(h *server.Hubro) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		needsRestart, err := h.ReloadConfig()
+		data := struct {
+			Error        error
+			NeedsRestart []string
+		}{
+			Error:        err,
+			NeedsRestart: needsRestart,
+		}
+		h.RenderWithLayout(w, r, "admin/app", "admin/reload", data)
 	}
 }
 
 func adminCreateHandler(h *server.Hubro) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		idxName := r.URL.Query().Get("idx")
-		idx, err := getIndexByName(idxName)
+		idx, err := getIndexByName(h, idxName)
 		if err != nil {
 			msg := err.Error()
 			h.ErrorHandler(w, r, http.StatusNotFound, &msg)
@@ -82,7 +135,7 @@ func adminEditHandler(h *server.Hubro) http.HandlerFunc {
 		slug := r.URL.Query().Get("p")
 		idxName := r.URL.Query().Get("idx")
 
-		i, err := getIndexByName(idxName)
+		i, err := getIndexByName(h, idxName)
 		if err != nil {
 			msg := err.Error()
 			h.ErrorHandler(w, r, http.StatusNotFound, &msg)
@@ -95,8 +148,13 @@ func adminEditHandler(h *server.Hubro) http.HandlerFunc {
 			h.ErrorHandler(w, r, http.StatusNotFound, &msg)
 			return
 		}
+		if !currentUser(r).CanEdit(entry.Author) {
+			msg := errNotAuthor.Error()
+			h.ErrorHandler(w, r, http.StatusForbidden, &msg)
+			return
+		}
 
-		fileContent, err := fs.ReadFile(i.FilesDir, entry.FileName)
+		fileContent, err := i.Store.Read(entry.FileName)
 		if err != nil {
 			msg := "Error reading file"
 			slog.Error(msg, "error", err)
@@ -104,31 +162,56 @@ func adminEditHandler(h *server.Hubro) http.HandlerFunc {
 			return
 		}
 
+		_, versioned := i.Store.(content.VersionedStore)
 		data := struct {
 			Entry      *index.IndexEntry
+			Index      string
 			RawContent string
+			Versioned  bool
 		}{
 			Entry:      entry,
+			Index:      idxName,
 			RawContent: string(fileContent),
+			Versioned:  versioned,
 		}
 
 		h.RenderWithLayout(w, r, "admin/app", "admin/edit", data)
 	}
 }
 
-func adminWebSocketHandler(_ *server.Hubro) http.HandlerFunc {
+func adminWebSocketHandler(h *server.Hubro, pipeline *images.Pipeline) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		conn, err := websocket.Accept(w, r, nil)
-		conn.SetReadLimit(256 * 1024)
+		// Browsers send cookies with websocket requests from any site, and
+		// there is no CSRF token, so only the site itself may connect
+		if !sameOrigin(h, r) {
+			slog.Warn("Websocket connection from another origin refused", "origin", r.Header.Get("Origin"))
+			msg := "Cross-origin request refused"
+			h.ErrorHandler(w, r, http.StatusForbidden, &msg)
+			return
+		}
+		// The origin was checked above, also against the base URL when the
+		// request was proxied
+		conn, err := websocket.Accept(w, r, &websocket.AcceptOptions{InsecureSkipVerify: true})
 		if err != nil {
 			slog.Error("Error accepting websocket connection", "error", err)
 			return
 		}
+		conn.SetReadLimit(256 * 1024)
 		defer conn.Close(websocket.StatusInternalError, "closing")
 
-		ctx := context.Background()
+		user := currentUser(r)
+		ctx, done, ok := h.TrackConnection()
+		defer done()
+		if !ok {
+			conn.Close(websocket.StatusGoingAway, "server shutting down")
+			return
+		}
 		for {
 			msgType, rawMsg, err := conn.Read(ctx)
+			if ctx.Err() != nil {
+				conn.Close(websocket.StatusGoingAway, "server shutting down")
+				return
+			}
 			if err != nil {
 				slog.Error("Error reading message", "error", err)
 				return
@@ -142,16 +225,16 @@ func adminWebSocketHandler(_ *server.Hubro) http.HandlerFunc {
 
 			switch msg["type"] {
 			case "markdown":
-				handleMarkdownMessage(ctx, conn, msgType, msg)
+				handleMarkdownMessage(ctx, conn, msgType, msg, pipeline)
 
 			case "load":
-				handleLoadMessage(ctx, conn, msgType, msg)
+				handleLoadMessage(ctx, h, user, conn, msgType, msg)
 
 			case "save":
-				handleSaveMessage(ctx, conn, msg)
+				handleSaveMessage(ctx, h, user, conn, msg)
 
 			case "create":
-				handleCreateMessage(ctx, conn, msg)
+				handleCreateMessage(ctx, h, user, conn, msg)
 
 			default:
 				slog.Debug("Received unknown message", "message", string(rawMsg), "type", msgType)
@@ -160,9 +243,9 @@ func adminWebSocketHandler(_ *server.Hubro) http.HandlerFunc {
 	}
 }
 
-func handleMarkdownMessage(ctx context.Context, conn *websocket.Conn, msgType websocket.MessageType, msg map[string]any) {
+func handleMarkdownMessage(ctx context.Context, conn *websocket.Conn, msgType websocket.MessageType, msg map[string]any, pipeline *images.Pipeline) {
 	content, _ := msg["content"].(string)
-	rendered, metaData, err := renderMarkdown([]byte(content))
+	rendered, metaData, err := renderMarkdown([]byte(content), pipeline)
 	if err != nil {
 		slog.Error("Error rendering markdown", "error", err)
 		return
@@ -176,11 +259,11 @@ func handleMarkdownMessage(ctx context.Context, conn *websocket.Conn, msgType we
 	_ = writeJSON(ctx, conn, msgType, responses)
 }
 
-func handleLoadMessage(ctx context.Context, conn *websocket.Conn, msgType websocket.MessageType, msg map[string]any) {
+func handleLoadMessage(ctx context.Context, h *server.Hubro, user *users.User, conn *websocket.Conn, msgType websocket.MessageType, msg map[string]any) {
 	fileSlug, _ := msg["id"].(string)
 	idxName, _ := msg["idx"].(string)
 
-	idx, err := getIndexByName(idxName)
+	idx, err := getIndexByName(h, idxName)
 	if err != nil {
 		slog.Error(err.Error())
 		return
@@ -191,8 +274,12 @@ func handleLoadMessage(ctx context.Context, conn *websocket.Conn, msgType websoc
 		slog.Error("Entry not found", "slug", fileSlug)
 		return
 	}
+	if !user.CanEdit(entry.Author) {
+		handleError(ctx, conn, errNotAuthor.Error())
+		return
+	}
 
-	content, err := fs.ReadFile(idx.FilesDir, entry.FileName)
+	content, err := idx.Store.Read(entry.FileName)
 	if err != nil {
 		slog.Error("Error reading file", "error", err)
 		return
@@ -206,30 +293,43 @@ func handleLoadMessage(ctx context.Context, conn *websocket.Conn, msgType websoc
 	_ = writeJSON(ctx, conn, msgType, responses)
 }
 
-func handleSaveMessage(ctx context.Context, conn *websocket.Conn, msg map[string]any) {
+func handleSaveMessage(ctx context.Context, h *server.Hubro, user *users.User, conn *websocket.Conn, msg map[string]any) {
 	fileName, _ := msg["id"].(string)
 	content, _ := msg["content"].(string)
 	idxName, _ := msg["idx"].(string)
 
-	idx, err := getIndexByName(idxName)
+	idx, err := getIndexByName(h, idxName)
 	if err != nil {
 		slog.Error(err.Error())
+		handleError(ctx, conn, err.Error())
 		return
 	}
 
-	stat, err := fs.Stat(idx.FilesDir, fileName)
-	if err != nil {
+	// Only existing files are saved, new ones are made with the create message
+	if _, err := idx.Store.Stat(fileName); err != nil {
 		slog.Error("Error getting file info", "error", err)
+		handleError(ctx, conn, "File not found")
+		return
+	}
+	entry := entryByFileName(idx, fileName)
+	if entry == nil {
+		slog.Error("Entry not found", "file", fileName)
+		handleError(ctx, conn, "Entry not found")
+		return
+	}
+	if err := checkChange(user, entry, []byte(content)); err != nil {
+		slog.Warn("Change not allowed", "file", fileName, "user", user.Name, "error", err)
+		handleError(ctx, conn, err.Error())
 		return
 	}
 
-	path := idx.DirPath + "/" + fileName
-	if err := os.WriteFile(path, []byte(content), stat.Mode()); err != nil {
+	if err := writeContent(h, user, idx, fileName, []byte(content), "Update "+fileName); err != nil {
 		slog.Error("Error writing to file", "error", err)
+		handleError(ctx, conn, "Error saving file")
 		return
 	}
 
-	slog.Info("File saved", "file", path)
+	slog.Info("File saved", "file", fileName, "index", idx.GetName())
 	responses := map[string]any{
 		"type": "saved",
 		"id":   fileName,
@@ -237,34 +337,61 @@ func handleSaveMessage(ctx context.Context, conn *websocket.Conn, msg map[string
 	_ = writeJSON(ctx, conn, websocket.MessageText, responses)
 }
 
-func handleCreateMessage(ctx context.Context, conn *websocket.Conn, msg map[string]any) {
+// newEntry is the front matter of a new entry. It is marshalled, so that a
+// title can't end the front matter or add fields.
+type newEntry struct {
+	Title  string `yaml:"title"`
+	Date   string `yaml:"date"`
+	Author string `yaml:"author"`
+	Draft  bool   `yaml:"draft"`
+}
+
+var errTitle = errors.New("Invalid title")
+
+// newEntryData returns the content of a new draft by user
+func newEntryData(user *users.User, title string, date string) ([]byte, error) {
+	// A line break would end the front matter, even in a quoted title
+	if strings.TrimSpace(title) == "" || strings.ContainsFunc(title, unicode.IsControl) {
+		return nil, errTitle
+	}
+	frontMatter, err := yaml.Marshal(newEntry{Title: title, Date: date, Author: user.Author(), Draft: true})
+	if err != nil {
+		return nil, err
+	}
+	data := []byte("---\n" + string(frontMatter) + "---\n")
+	return data, checkChange(user, nil, data)
+}
+
+func handleCreateMessage(ctx context.Context, h *server.Hubro, user *users.User, conn *websocket.Conn, msg map[string]any) {
 	idxName, _ := msg["index"].(string)
-	idx, err := getIndexByName(idxName)
+	idx, err := getIndexByName(h, idxName)
 	if err != nil {
 		slog.Error(err.Error())
 		return
 	}
-	title := msg["title"].(string)
+	title, _ := msg["title"].(string)
 	date := time.Now().Format("2006-01-02")
 	fileName := date + "-" + utils.Slugify(title) + ".md"
-	path := idx.DirPath + "/" + fileName
-	_, err = fs.Stat(idx.FilesDir, fileName)
+	_, err = idx.Store.Stat(fileName)
 	if err == nil {
 		// file exists
-		slog.Error("File already exists", "file", path)
+		slog.Error("File already exists", "file", fileName, "index", idx.GetName())
 		handleError(ctx, conn, "File already exists")
 		return
 	}
-	data := `---
-title: ` + title + `
-date: ` + date + `
-author: ` + config.Config.AuthorName + `
-draft: true
----
-`
+	data, err := newEntryData(user, title, date)
+	if err != nil {
+		slog.Warn("Refused to create file", "file", fileName, "user", user.Name, "error", err)
+		handleError(ctx, conn, err.Error())
+		return
+	}
 
-	os.WriteFile(path, []byte(data), 0644)
-	slog.Info("File created", "file", path)
+	if err := writeContent(h, user, idx, fileName, data, "Create "+fileName); err != nil {
+		slog.Error("Error creating file", "file", fileName, "error", err)
+		handleError(ctx, conn, "Error creating file")
+		return
+	}
+	slog.Info("File created", "file", fileName, "index", idx.GetName())
 	responses := map[string]any{
 		"type":  "created",
 		"id":    fileName,
@@ -283,11 +410,11 @@ func handleError(ctx context.Context, conn *websocket.Conn, msg string) {
 	_ = writeJSON(ctx, conn, websocket.MessageText, responses)
 }
 
-func getIndexByName(name string) (*index.Index, error) {
+func getIndexByName(h *server.Hubro, name string) (*index.Index, error) {
 	if name == "" {
 		return nil, fmt.Errorf("Index name not provided")
 	}
-	idx := index.GetIndex(name)
+	idx := h.Indices().GetIndex(name)
 	if idx == nil {
 		return nil, fmt.Errorf("Index not found: %s", name)
 	}
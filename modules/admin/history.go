@@ -0,0 +1,172 @@
+package admin
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/sokkalf/hubro/content"
+	"github.com/sokkalf/hubro/index"
+	"github.com/sokkalf/hubro/server"
+	"github.com/sokkalf/hubro/users"
+)
+
+// author returns who changes made by user are recorded as, using the site
+// author's email if the user has none
+func author(h *server.Hubro, user *users.User) content.Author {
+	email := user.Email
+	if email == "" {
+		email = h.Config().AuthorEmail
+	}
+	return content.Author{Name: user.Author(), Email: email}
+}
+
+// writeContent writes a file to the store of an index, recording message
+// with the change if the store keeps history
+func writeContent(h *server.Hubro, user *users.User, idx *index.Index, fileName string, data []byte, message string) error {
+	if store, ok := idx.Store.(content.VersionedStore); ok {
+		return store.WriteAs(fileName, data, author(h, user), message)
+	}
+	return idx.Store.Write(fileName, data)
+}
+
+// versionedEntry looks up the entry and versioned store from the idx and p
+// query or form values, and writes an error response if either is missing or
+// the user may not edit the entry
+func versionedEntry(h *server.Hubro, w http.ResponseWriter, r *http.Request) (*index.IndexEntry, content.VersionedStore, bool) {
+	idx, err := getIndexByName(h, r.FormValue("idx"))
+	if err != nil {
+		msg := err.Error()
+		h.ErrorHandler(w, r, http.StatusNotFound, &msg)
+		return nil, nil, false
+	}
+	store, ok := idx.Store.(content.VersionedStore)
+	if !ok {
+		msg := "History is only available when git is enabled"
+		h.ErrorHandler(w, r, http.StatusNotFound, &msg)
+		return nil, nil, false
+	}
+	entry := idx.GetEntryBySlug(r.FormValue("p"))
+	if entry == nil {
+		msg := "Entry not found"
+		h.ErrorHandler(w, r, http.StatusNotFound, &msg)
+		return nil, nil, false
+	}
+	if !currentUser(r).CanEdit(entry.Author) {
+		msg := errNotAuthor.Error()
+		h.ErrorHandler(w, r, http.StatusForbidden, &msg)
+		return nil, nil, false
+	}
+	return entry, store, true
+}
+
+func adminHistoryHandler(h *server.Hubro) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		entry, store, ok := versionedEntry(h, w, r)
+		if !ok {
+			return
+		}
+		revisions, err := store.History(entry.FileName)
+		if err != nil {
+			msg := "Error reading history"
+			slog.Error(msg, "file", entry.FileName, "error", err)
+			h.ErrorHandler(w, r, http.StatusInternalServerError, &msg)
+			return
+		}
+		data := struct {
+			Entry     *index.IndexEntry
+			Index     string
+			Revisions []content.Revision
+		}{
+			Entry:     entry,
+			Index:     r.FormValue("idx"),
+			Revisions: revisions,
+		}
+		h.RenderWithLayout(w, r, "admin/app", "admin/history", data)
+	}
+}
+
+type diffLine struct {
+	Text string
+	// Kind is "added", "removed" or empty for context and headers
+	Kind string
+}
+
+func diffLines(diff string) []diffLine {
+	lines := []diffLine{}
+	for line := range strings.Lines(diff) {
+		line = strings.TrimSuffix(line, "\n")
+		kind := ""
+		if strings.HasPrefix(line, "+") && !strings.HasPrefix(line, "+++") {
+			kind = "added"
+		} else if strings.HasPrefix(line, "-") && !strings.HasPrefix(line, "---") {
+			kind = "removed"
+		}
+		lines = append(lines, diffLine{Text: line, Kind: kind})
+	}
+	return lines
+}
+
+func adminDiffHandler(h *server.Hubro) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		entry, store, ok := versionedEntry(h, w, r)
+		if !ok {
+			return
+		}
+		revision := r.FormValue("rev")
+		diff, err := store.Diff(entry.FileName, revision)
+		if err != nil {
+			msg := "Revision not found"
+			slog.Warn(msg, "file", entry.FileName, "revision", revision, "error", err)
+			h.ErrorHandler(w, r, http.StatusNotFound, &msg)
+			return
+		}
+		data := struct {
+			Entry    *index.IndexEntry
+			Index    string
+			Revision string
+			Lines    []diffLine
+		}{
+			Entry:    entry,
+			Index:    r.FormValue("idx"),
+			Revision: revision,
+			Lines:    diffLines(diff),
+		}
+		h.RenderWithLayout(w, r, "admin/app", "admin/diff", data)
+	}
+}
+
+// adminRevertHandler restores an old revision of an entry, as a new revision
+func adminRevertHandler(h *server.Hubro) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		entry, store, ok := versionedEntry(h, w, r)
+		if !ok {
+			return
+		}
+		revision := r.FormValue("rev")
+		old, err := store.ReadRevision(entry.FileName, revision)
+		if err != nil {
+			msg := "Revision not found"
+			slog.Warn(msg, "file", entry.FileName, "revision", revision, "error", err)
+			h.ErrorHandler(w, r, http.StatusNotFound, &msg)
+			return
+		}
+		if err := checkChange(currentUser(r), entry, old); err != nil {
+			msg := err.Error()
+			h.ErrorHandler(w, r, http.StatusForbidden, &msg)
+			return
+		}
+		message := fmt.Sprintf("Revert %s to %.7s", entry.FileName, revision)
+		if err := store.WriteAs(entry.FileName, old, author(h, currentUser(r)), message); err != nil {
+			msg := "Error reverting entry"
+			slog.Error(msg, "file", entry.FileName, "revision", revision, "error", err)
+			h.ErrorHandler(w, r, http.StatusInternalServerError, &msg)
+			return
+		}
+		slog.Info("Entry reverted", "file", entry.FileName, "revision", revision)
+		query := url.Values{"idx": {r.FormValue("idx")}, "p": {entry.Slug}}
+		http.Redirect(w, r, h.Config().RootPath+"admin/history?"+query.Encode(), http.StatusSeeOther)
+	}
+}
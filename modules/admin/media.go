@@ -0,0 +1,320 @@
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sokkalf/hubro/content"
+	"github.com/sokkalf/hubro/server"
+	"github.com/sokkalf/hubro/utils"
+)
+
+const mediaField = "file"
+
+// The types that can be uploaded, and their extensions, the first one given
+// to files with another extension. The type is sniffed from the content, not
+// taken from the name or the browser. SVG is left out, since it can contain
+// scripts, which would run on the site.
+var mediaTypes = map[string][]string{
+	"image/jpeg":      {".jpg", ".jpeg"},
+	"image/png":       {".png"},
+	"image/gif":       {".gif"},
+	"image/webp":      {".webp"},
+	"application/pdf": {".pdf"},
+}
+
+var (
+	errMediaType     = errors.New("only JPEG, PNG, GIF, WebP and PDF files can be uploaded")
+	errMediaMissing  = errors.New("choose a file to upload")
+	errMediaName     = errors.New("invalid file name")
+	errMediaNotFound = errors.New("no such file")
+	errMediaSize     = errors.New("the file is too large")
+)
+
+// Picking a free name and writing the file must not interleave
+var mediaMutex sync.Mutex
+
+// mediaFile is a file in the userfiles directory, as listed to the editor
+type mediaFile struct {
+	Name     string    `json:"name"`
+	URL      string    `json:"url"`
+	Size     int64     `json:"size"`
+	ModTime  time.Time `json:"modTime"`
+	Image    bool      `json:"image"`
+	Markdown string    `json:"markdown"`
+}
+
+// HumanSize returns the size in bytes, kB or MB
+func (f mediaFile) HumanSize() string {
+	return humanSize(f.Size)
+}
+
+func humanSize(size int64) string {
+	switch {
+	case size >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(size)/(1<<20))
+	case size >= 1<<10:
+		return fmt.Sprintf("%d kB", size>>10)
+	}
+	return fmt.Sprintf("%d bytes", size)
+}
+
+func mediaStore(h *server.Hubro) *content.FSStore {
+	return content.NewFSStore(h.Config().UserStaticDir)
+}
+
+var markdownEscaper = strings.NewReplacer("[", `\[`, "]", `\]`, "(", "%28", ")", "%29")
+
+func newMediaFile(h *server.Hubro, f content.File) mediaFile {
+	u := (&url.URL{Path: h.Config().RootPath + "userfiles/" + f.Name}).EscapedPath()
+	ext := path.Ext(f.Name)
+	image := strings.HasPrefix(mime.TypeByExtension(ext), "image/")
+	link := fmt.Sprintf("[%s](%s)", markdownEscaper.Replace(path.Base(f.Name)), markdownEscaper.Replace(u))
+	if image {
+		alt := strings.ReplaceAll(strings.TrimSuffix(path.Base(f.Name), ext), "-", " ")
+		link = fmt.Sprintf("![%s](%s)", markdownEscaper.Replace(alt), markdownEscaper.Replace(u))
+	}
+	return mediaFile{Name: f.Name, URL: u, Size: f.Size, ModTime: f.ModTime, Image: image, Markdown: link}
+}
+
+// listMedia returns the files in the userfiles directory, newest first
+func listMedia(h *server.Hubro) ([]mediaFile, error) {
+	files, err := mediaStore(h).List()
+	if errors.Is(err, fs.ErrNotExist) {
+		return []mediaFile{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	slices.SortFunc(files, func(a, b content.File) int {
+		return b.ModTime.Compare(a.ModTime)
+	})
+	return utils.Map(func(f content.File) mediaFile { return newMediaFile(h, f) }, files), nil
+}
+
+// mediaName returns the name to save an upload of type contentType as. It is
+// the slug of the name it was uploaded with, and a number is added if a file
+// with that name exists.
+func mediaName(store *content.FSStore, uploaded string, contentType string) (string, error) {
+	exts, ok := mediaTypes[contentType]
+	if !ok {
+		return "", errMediaType
+	}
+	ext := strings.ToLower(path.Ext(uploaded))
+	if !slices.Contains(exts, ext) {
+		ext = exts[0]
+	}
+	base := utils.Slugify(strings.TrimSuffix(path.Base(uploaded), path.Ext(uploaded)))
+	if base == "" {
+		base = "upload"
+	}
+	name := base + ext
+	for i := 2; ; i++ {
+		if _, err := store.Stat(name); errors.Is(err, fs.ErrNotExist) {
+			return name, nil
+		} else if err != nil {
+			return "", err
+		}
+		name = fmt.Sprintf("%s-%d%s", base, i, ext)
+	}
+}
+
+// saveUpload checks the uploaded file and saves it in the userfiles directory
+func saveUpload(h *server.Hubro, r *http.Request) (mediaFile, error) {
+	file, header, err := r.FormFile(mediaField)
+	if errors.Is(err, http.ErrMissingFile) {
+		return mediaFile{}, errMediaMissing
+	}
+	if err != nil {
+		return mediaFile{}, err
+	}
+	defer file.Close()
+	if header.Size > h.Config().MediaMaxUploadBytes {
+		return mediaFile{}, tooLarge(h)
+	}
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return mediaFile{}, err
+	}
+	contentType, _, _ := mime.ParseMediaType(http.DetectContentType(data))
+	store := mediaStore(h)
+	mediaMutex.Lock()
+	defer mediaMutex.Unlock()
+	name, err := mediaName(store, header.Filename, contentType)
+	if err != nil {
+		return mediaFile{}, err
+	}
+	if err := store.Write(name, data); err != nil {
+		return mediaFile{}, err
+	}
+	f, err := store.Stat(name)
+	if err != nil {
+		return mediaFile{}, err
+	}
+	return newMediaFile(h, f), nil
+}
+
+// wantsJSON reports whether the request comes from the editor rather than a form
+func wantsJSON(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "application/json")
+}
+
+func writeMediaJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error("Error encoding media response", "error", err)
+	}
+}
+
+func renderMedia(h *server.Hubro, w http.ResponseWriter, r *http.Request, status int, mediaErr error) {
+	files, err := listMedia(h)
+	if err != nil {
+		msg := "Error reading userfiles directory"
+		slog.Error(msg, "error", err)
+		h.ErrorHandler(w, r, http.StatusInternalServerError, &msg)
+		return
+	}
+	data := struct {
+		Files     []mediaFile
+		MaxSize   string
+		CanDelete bool
+		Error     error
+	}{
+		Files:     files,
+		MaxSize:   humanSize(h.Config().MediaMaxUploadBytes),
+		CanDelete: currentUser(r).CanPublish(),
+		Error:     mediaErr,
+	}
+	w.WriteHeader(status)
+	h.RenderWithLayout(w, r, "admin/app", "admin/media", data)
+}
+
+func adminMediaHandler(h *server.Hubro) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		renderMedia(h, w, r, http.StatusOK, nil)
+	}
+}
+
+// adminMediaFilesHandler lists the files for the editor
+func adminMediaFilesHandler(h *server.Hubro) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		files, err := listMedia(h)
+		if err != nil {
+			slog.Error("Error reading userfiles directory", "error", err)
+			writeMediaJSON(w, http.StatusInternalServerError, map[string]string{"error": "Error reading userfiles directory"})
+			return
+		}
+		writeMediaJSON(w, http.StatusOK, files)
+	}
+}
+
+// tooLarge is the error for a file over the upload limit
+func tooLarge(h *server.Hubro) error {
+	return fmt.Errorf("%w, the limit is %s", errMediaSize, humanSize(h.Config().MediaMaxUploadBytes))
+}
+
+// limitUpload caps the request body before anything reads the form, leaving
+// some room for the multipart headers and the CSRF token. Requests that are
+// too large are turned away up front, since the CSRF token can't be read
+// from a form that is cut off.
+func limitUpload(h *server.Hubro, next http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		limit := h.Config().MediaMaxUploadBytes + 64<<10
+		if r.ContentLength > limit {
+			msg := tooLarge(h).Error()
+			if wantsJSON(r) {
+				writeMediaJSON(w, http.StatusRequestEntityTooLarge, map[string]string{"error": msg})
+			} else {
+				h.ErrorHandler(w, r, http.StatusRequestEntityTooLarge, &msg)
+			}
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, limit)
+		next.ServeHTTP(w, r)
+	}
+}
+
+// adminMediaUploadHandler saves one file, and answers the editor with the
+// file as JSON, and the upload form with the list of files
+func adminMediaUploadHandler(h *server.Hubro) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		f, err := saveUpload(h, r)
+		if err != nil {
+			status := http.StatusBadRequest
+			var maxBytes *http.MaxBytesError
+			if errors.As(err, &maxBytes) {
+				err = tooLarge(h)
+			}
+			if errors.Is(err, errMediaSize) {
+				status = http.StatusRequestEntityTooLarge
+			}
+			slog.Warn("Error uploading file", "user", currentUser(r).Name, "error", err)
+			if wantsJSON(r) {
+				writeMediaJSON(w, status, map[string]string{"error": err.Error()})
+			} else {
+				renderMedia(h, w, r, status, err)
+			}
+			return
+		}
+		slog.Info("File uploaded", "name", f.Name, "size", f.Size, "by", currentUser(r).Name)
+		if wantsJSON(r) {
+			writeMediaJSON(w, http.StatusCreated, f)
+			return
+		}
+		http.Redirect(w, r, h.Config().RootPath+"admin/media", http.StatusSeeOther)
+	}
+}
+
+// deleteMedia deletes a file that is listed, not a directory or a hidden file
+func deleteMedia(h *server.Hubro, name string) error {
+	hidden := func(s string) bool { return strings.HasPrefix(s, ".") }
+	if slices.ContainsFunc(strings.Split(name, "/"), hidden) {
+		return errMediaName
+	}
+	store := mediaStore(h)
+	_, err := store.Stat(name)
+	if err == nil {
+		err = store.Delete(name)
+	}
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return errMediaNotFound
+	case errors.Is(err, fs.ErrInvalid):
+		return errMediaName
+	}
+	return err
+}
+
+// adminMediaDeleteHandler deletes a file. Files don't belong to anyone, so
+// only those who may change any entry may delete them.
+func adminMediaDeleteHandler(h *server.Hubro) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := currentUser(r)
+		if !user.CanPublish() {
+			msg := "Only editors and admins can delete files"
+			h.ErrorHandler(w, r, http.StatusForbidden, &msg)
+			return
+		}
+		name := r.PostFormValue("name")
+		if err := deleteMedia(h, name); err != nil {
+			slog.Warn("Error deleting file", "name", name, "user", user.Name, "error", err)
+			renderMedia(h, w, r, http.StatusBadRequest, err)
+			return
+		}
+		slog.Info("File deleted", "name", name, "by", user.Name)
+		http.Redirect(w, r, h.Config().RootPath+"admin/media", http.StatusSeeOther)
+	}
+}
@@ -0,0 +1,214 @@
+package admin
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sokkalf/hubro/config"
+	"github.com/sokkalf/hubro/content"
+	"github.com/sokkalf/hubro/server"
+	"github.com/sokkalf/hubro/users"
+)
+
+const (
+	pngHeader  = "\x89PNG\r\n\x1a\n"
+	jpegHeader = "\xff\xd8\xff\xe0"
+)
+
+// testMedia returns a site with an empty userfiles directory, an admin named
+// boss and an author named writer
+func testMedia(t *testing.T) *server.Hubro {
+	h := testAdmin(t)
+	config.Update(func(c *config.HubroConfig) {
+		c.UserStaticDir = t.TempDir()
+		c.MediaMaxUploadBytes = 4 << 10
+	})
+	store, err := userStore(h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, role := range map[string]users.Role{"boss": users.RoleAdmin, "writer": users.RoleAuthor} {
+		if err := store.Add(users.User{Name: name, Role: role}, userPassword); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return h
+}
+
+// upload posts a file to the media library as the editor does, with the CSRF
+// token in a form field if one is given
+func upload(h *server.Hubro, cookie *http.Cookie, token string, name string, data []byte) *httptest.ResponseRecorder {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	if token != "" {
+		mw.WriteField(csrfField, token)
+	}
+	fw, _ := mw.CreateFormFile(mediaField, name)
+	fw.Write(data)
+	mw.Close()
+	r := httptest.NewRequest(http.MethodPost, "/admin/media", &body)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	r.Header.Set("Accept", "application/json")
+	return serve(h, r, cookie)
+}
+
+func postDelete(h *server.Hubro, cookie *http.Cookie, token string, name string) *httptest.ResponseRecorder {
+	form := url.Values{csrfField: {token}, "name": {name}}
+	r := httptest.NewRequest(http.MethodPost, "/admin/media/delete", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return serve(h, r, cookie)
+}
+
+// TestMediaUpload checks that uploads are named by their sniffed type, that
+// scripts renamed as images are refused, and the CSRF token and size limit.
+func TestMediaUpload(t *testing.T) {
+	h := testMedia(t)
+	cookie, token := loginAs(t, h, "writer", userPassword)
+	png := []byte(pngHeader + strings.Repeat("\x00", 64))
+	jpeg := []byte(jpegHeader + strings.Repeat("\x00", 64))
+	for _, c := range []struct {
+		name   string
+		data   []byte
+		status int
+		saved  string
+	}{
+		{"Summer Trip.JPG", jpeg, http.StatusCreated, "summer-trip.jpg"},
+		{"Summer Trip.JPG", jpeg, http.StatusCreated, "summer-trip-2.jpg"},
+		{"photo.jpg", png, http.StatusCreated, "photo.png"},
+		{"../../photo.png", png, http.StatusCreated, "photo-2.png"},
+		{"drawing.jpg", []byte(`<svg xmlns="http://www.w3.org/2000/svg"><script>alert(1)</script></svg>`), http.StatusBadRequest, ""},
+		{"page.jpg", []byte(`<!DOCTYPE html><html><script>alert(1)</script></html>`), http.StatusBadRequest, ""},
+		{"notes.png", []byte("just some text"), http.StatusBadRequest, ""},
+	} {
+		w := upload(h, cookie, token, c.name, c.data)
+		if w.Code != c.status {
+			t.Errorf("%s: expected %d, got %d: %s", c.name, c.status, w.Code, w.Body.String())
+			continue
+		}
+		if c.saved != "" && !strings.Contains(w.Body.String(), `"name":"`+c.saved+`"`) {
+			t.Errorf("%s: expected to be saved as %s, got %s", c.name, c.saved, w.Body.String())
+		}
+	}
+	files, _ := os.ReadDir(h.Config().UserStaticDir)
+	if len(files) != 4 {
+		t.Errorf("expected only the four images to be saved, got %d files", len(files))
+	}
+
+	if w := upload(h, cookie, "", "photo.png", png); w.Code != http.StatusForbidden {
+		t.Errorf("expected 403 without a CSRF token, got %d", w.Code)
+	}
+	if w := upload(h, cookie, token, "large.png", append(png, make([]byte, 4<<10)...)); w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected 413 for a file over the limit, got %d", w.Code)
+	}
+	if w := upload(h, cookie, token, "huge.png", append(png, make([]byte, 128<<10)...)); w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected 413 for a request over the limit, got %d", w.Code)
+	}
+
+	// Without a length the body is cut off while it is read
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fw, _ := mw.CreateFormFile(mediaField, "huge.png")
+	fw.Write(append(png, make([]byte, 128<<10)...))
+	mw.Close()
+	r := httptest.NewRequest(http.MethodPost, "/admin/media", io.MultiReader(&body))
+	r.ContentLength = -1
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	r.Header.Set("Accept", "application/json")
+	r.Header.Set(csrfHeader, token)
+	if w := serve(h, r, cookie); w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected 413 for a body over the limit, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+// TestMediaName checks how uploads are named.
+func TestMediaName(t *testing.T) {
+	store := content.NewFSStore(t.TempDir())
+	for _, c := range []struct {
+		uploaded, contentType, want string
+	}{
+		{"Summer Trip.JPEG", "image/jpeg", "summer-trip.jpeg"},
+		{"Blåbær & Fløte.png", "image/png", "blabaer-and-flote.png"},
+		{"scan.PDF", "application/pdf", "scan.pdf"},
+		{"photo.exe", "image/webp", "photo.webp"},
+		{"../../etc/passwd", "image/gif", "passwd.gif"},
+		{".png", "image/png", "upload.png"},
+		{"!!!.gif", "image/gif", "upload.gif"},
+	} {
+		if got, err := mediaName(store, c.uploaded, c.contentType); err != nil || got != c.want {
+			t.Errorf("%q: expected %q, got %q, %v", c.uploaded, c.want, got, err)
+		}
+	}
+	if _, err := mediaName(store, "drawing.svg", "image/svg+xml"); !errors.Is(err, errMediaType) {
+		t.Errorf("expected errMediaType for SVG, got %v", err)
+	}
+
+	for _, want := range []string{"photo.png", "photo-2.png", "photo-3.png"} {
+		name, err := mediaName(store, "Photo.png", "image/png")
+		if err != nil || name != want {
+			t.Fatalf("expected %q, got %q, %v", want, name, err)
+		}
+		if err := store.Write(name, []byte(pngHeader)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// TestMediaDelete checks that only listed files can be deleted, and only by
+// editors and admins.
+func TestMediaDelete(t *testing.T) {
+	h := testMedia(t)
+	dir := h.Config().UserStaticDir
+	for _, name := range []string{"photo.png", ".hidden", "sub/.secret"} {
+		os.MkdirAll(filepath.Join(dir, filepath.Dir(name)), 0755)
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(pngHeader), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	os.WriteFile(filepath.Join(filepath.Dir(dir), "outside.png"), []byte(pngHeader), 0644)
+
+	for name, want := range map[string]error{
+		"../outside.png": errMediaName,
+		"sub/../../x":    errMediaName,
+		"/etc/passwd":    errMediaName,
+		".hidden":        errMediaName,
+		"sub/.secret":    errMediaName,
+		"sub":            errMediaNotFound,
+		"missing.png":    errMediaNotFound,
+	} {
+		if err := deleteMedia(h, name); !errors.Is(err, want) {
+			t.Errorf("%q: expected %v, got %v", name, want, err)
+		}
+	}
+	for _, name := range []string{".hidden", "sub/.secret", "../outside.png"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("expected %s to be kept, got %v", name, err)
+		}
+	}
+
+	cookie, token := loginAs(t, h, "writer", userPassword)
+	if w := postDelete(h, cookie, token, "photo.png"); w.Code != http.StatusForbidden {
+		t.Errorf("expected 403 for an author, got %d", w.Code)
+	}
+	cookie, token = loginAs(t, h, "boss", userPassword)
+	if w := postDelete(h, cookie, "", "photo.png"); w.Code != http.StatusForbidden {
+		t.Errorf("expected 403 without a CSRF token, got %d", w.Code)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "photo.png")); err != nil {
+		t.Fatalf("expected photo.png to be kept, got %v", err)
+	}
+	if w := postDelete(h, cookie, token, "photo.png"); w.Code != http.StatusSeeOther {
+		t.Errorf("expected photo.png to be deleted, got %d: %s", w.Code, w.Body.String())
+	}
+	if _, err := os.Stat(filepath.Join(dir, "photo.png")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected photo.png to be gone, got %v", err)
+	}
+}
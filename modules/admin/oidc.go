@@ -0,0 +1,116 @@
+package admin
+
+import (
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/sokkalf/hubro/server"
+	"github.com/sokkalf/hubro/sso"
+	"github.com/sokkalf/hubro/users"
+)
+
+const (
+	ssoCookie = "hubro_sso"
+	// How long the provider has to send the user back
+	ssoFlowTimeout = 10 * time.Minute
+)
+
+// ssoFlow is kept in a cookie while the user logs in at the provider
+type ssoFlow struct {
+	sso.Flow
+	Next    string `json:"next"`
+	Expires int64  `json:"expires"`
+}
+
+func ssoConfig(h *server.Hubro) sso.Config {
+	c := h.Config()
+	return sso.Config{
+		Issuer:       c.OIDCIssuer,
+		ClientID:     c.OIDCClientID,
+		ClientSecret: c.OIDCClientSecret,
+		RedirectURL:  strings.TrimSuffix(c.BaseURL, "/") + "/admin/oidc/callback",
+		GroupsClaim:  c.OIDCGroupsClaim,
+	}
+}
+
+// ssoUser returns who someone logged in by single sign-on is. A user in the
+// users file with the same verified email address keeps their role, anyone
+// else on the allow-lists is an admin. It returns nil if they may not log in.
+func ssoUser(h *server.Hubro, id *sso.Identity) *users.User {
+	if h.Config().OIDCIssuer == "" || !id.EmailVerified {
+		return nil
+	}
+	if store, err := userStore(h); err != nil {
+		slog.Error("Error reading users", "error", err)
+	} else if user, err := store.GetByEmail(id.Email); err == nil {
+		return user
+	}
+	if !id.Allowed(h.Config().OIDCAllowedEmails, h.Config().OIDCAllowedGroups) {
+		return nil
+	}
+	return &users.User{Name: strings.ToLower(id.Email), DisplayName: id.Name, Email: id.Email, Role: users.RoleAdmin}
+}
+
+func (a *auth) ssoLoginHandler(client *sso.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if a.h.Config().OIDCIssuer == "" {
+			msg := "Single sign-on is not configured"
+			a.h.ErrorHandler(w, r, http.StatusNotFound, &msg)
+			return
+		}
+		next := nextPath(a.h, r.FormValue("next"))
+		url, flow, err := client.Start(r.Context(), ssoConfig(a.h))
+		if err != nil {
+			slog.Error("Error starting single sign-on", "error", err)
+			a.renderLogin(w, r, http.StatusBadGateway, next, "The single sign-on provider is not available")
+			return
+		}
+		expires := time.Now().Add(ssoFlowTimeout)
+		a.setCookie(w, ssoCookie, "admin/oidc", ssoFlow{Flow: flow, Next: next, Expires: expires.Unix()}, expires)
+		http.Redirect(w, r, url, http.StatusFound)
+	}
+}
+
+func (a *auth) ssoCallbackHandler(client *sso.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var flow ssoFlow
+		if !a.readCookie(r, ssoCookie, &flow) || time.Now().Unix() > flow.Expires {
+			a.renderLogin(w, r, http.StatusBadRequest, nextPath(a.h, ""), "The login took too long, try again")
+			return
+		}
+		a.clearCookie(w, ssoCookie, "admin/oidc")
+		if providerErr := r.FormValue("error"); providerErr != "" {
+			slog.Warn("Single sign-on refused by the provider", "error", providerErr, "description", r.FormValue("error_description"))
+			a.renderLogin(w, r, http.StatusUnauthorized, flow.Next, "The single sign-on provider refused the login")
+			return
+		}
+		id, err := client.Finish(r.Context(), ssoConfig(a.h), flow.Flow, r.FormValue("state"), r.FormValue("code"))
+		if err != nil {
+			slog.Warn("Single sign-on failed", "error", err, "remoteAddr", clientIP(r))
+			a.renderLogin(w, r, http.StatusUnauthorized, flow.Next, "Single sign-on failed, try again")
+			return
+		}
+		user := ssoUser(a.h, id)
+		if user == nil {
+			slog.Warn("Single sign-on by someone not allowed", "email", id.Email, "groups", id.Groups)
+			a.renderLogin(w, r, http.StatusForbidden, flow.Next, "Your account is not allowed to use the admin interface")
+			return
+		}
+		// Single sign-on stands in for the password only, users who turned on
+		// two-factor authentication still have to give a code
+		if user.HasTOTP() {
+			a.askForCode(w, r, user, flow.Next)
+			return
+		}
+		// Only the allowed groups are kept, the provider may list many
+		groups := slices.DeleteFunc(id.Groups, func(group string) bool {
+			return !slices.Contains(a.h.Config().OIDCAllowedGroups, group)
+		})
+		a.startSession(w, session{User: user.Name, SSO: true, Email: id.Email, Name: id.Name, Groups: groups})
+		slog.Info("Logged in with single sign-on", "user", user.Name, "email", id.Email, "remoteAddr", clientIP(r))
+		http.Redirect(w, r, flow.Next, http.StatusSeeOther)
+	}
+}
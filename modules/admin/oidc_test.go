@@ -0,0 +1,65 @@
+package admin
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sokkalf/hubro/config"
+	"github.com/sokkalf/hubro/sso"
+	"github.com/sokkalf/hubro/totp"
+	"github.com/sokkalf/hubro/users"
+)
+
+// TestSSOUser checks that users in the users file are only found by a
+// verified email address, and that sessions from single sign-on end once the
+// user has two-factor authentication.
+func TestSSOUser(t *testing.T) {
+	h := testAdmin(t)
+	config.Update(func(c *config.HubroConfig) {
+		c.OIDCIssuer = "https://sso.example.org"
+		c.OIDCAllowedEmails = []string{"jane@example.org"}
+	})
+	store, err := userStore(h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Add(users.User{Name: "john", Email: "john@example.org", Role: users.RoleAuthor}, "long enough password"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		id   sso.Identity
+		want string
+	}{
+		{sso.Identity{Email: "john@example.org", EmailVerified: true}, "john"},
+		{sso.Identity{Email: "john@example.org"}, ""},
+		{sso.Identity{Email: "jane@example.org", EmailVerified: true}, "jane@example.org"},
+		{sso.Identity{Email: "jane@example.org"}, ""},
+		{sso.Identity{Email: "eve@example.org", EmailVerified: true}, ""},
+	} {
+		got := ""
+		if user := ssoUser(h, &c.id); user != nil {
+			got = user.Name
+		}
+		if got != c.want {
+			t.Errorf("%+v: expected %q, got %q", c.id, c.want, got)
+		}
+	}
+
+	a := newAuth(h)
+	w := httptest.NewRecorder()
+	a.startSession(w, session{User: "john", SSO: true, Email: "john@example.org"})
+	cookie := w.Result().Cookies()[0]
+	r := httptest.NewRequest(http.MethodGet, "/admin/", nil)
+	r.AddCookie(cookie)
+	if _, user := a.session(r); user == nil || user.Name != "john" {
+		t.Fatalf("expected a session for john, got %+v", user)
+	}
+	if err := store.EnableTOTP("john", totp.NewSecret(), nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, user := a.session(r); user != nil {
+		t.Errorf("expected the single sign-on session to end once john has two-factor authentication")
+	}
+}
@@ -0,0 +1,388 @@
+package admin
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sokkalf/hubro/server"
+	"github.com/sokkalf/hubro/sso"
+	"github.com/sokkalf/hubro/users"
+)
+
+const (
+	sessionCookie = "hubro_session"
+	csrfField     = "csrf_token"
+	csrfHeader    = "X-CSRF-Token"
+
+	// Failed logins allowed from one address within loginWindow
+	maxLoginFailures = 5
+	loginWindow      = 15 * time.Minute
+)
+
+// session is stored in a signed cookie, so nothing has to be kept on the
+// server except the sessions ended by logging out
+type session struct {
+	User string `json:"u"`
+	// A fingerprint of the password hash, so that changing the password ends the session
+	Password string `json:"p,omitempty"`
+	ID       string `json:"i"`
+	Expires  int64  `json:"e"`
+
+	// Sessions started by single sign-on keep the identity given by the
+	// provider, to check it against the allow-lists on every request
+	SSO    bool     `json:"s,omitempty"`
+	Email  string   `json:"m,omitempty"`
+	Name   string   `json:"n,omitempty"`
+	Groups []string `json:"g,omitempty"`
+}
+
+type loginFailures struct {
+	count int
+	first time.Time
+}
+
+// auth keeps the sessions and login throttling of one site
+type auth struct {
+	h *server.Hubro
+	// Signs sessions if no session secret is configured, so they end on restart
+	randomKey []byte
+
+	mtx      sync.Mutex
+	revoked  map[string]time.Time
+	failures map[string]*loginFailures
+	// The time step of the last TOTP code used by each user
+	lastStep map[string]int64
+}
+
+func newAuth(h *server.Hubro) *auth {
+	a := &auth{
+		h:         h,
+		randomKey: make([]byte, 32),
+		revoked:   map[string]time.Time{},
+		failures:  map[string]*loginFailures{},
+		lastStep:  map[string]int64{},
+	}
+	rand.Read(a.randomKey)
+	if h.Config().SessionSecret == "" {
+		slog.Info("No session secret set, admin sessions end when Hubro restarts")
+	}
+	return a
+}
+
+func (a *auth) key() []byte {
+	if secret := a.h.Config().SessionSecret; secret != "" {
+		return []byte(secret)
+	}
+	return a.randomKey
+}
+
+func (a *auth) sign(data string) string {
+	mac := hmac.New(sha256.New, a.key())
+	mac.Write([]byte(data))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// fingerprint changes when the password or second factor of user does. It
+// is signed so the cookie reveals nothing about the password.
+func (a *auth) fingerprint(user *users.User) string {
+	return a.sign("fingerprint:" + user.PasswordHash + "\x00" + user.TOTPSecret)[:16]
+}
+
+func randomID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// setCookie sets a cookie for the admin interface, holding v as signed JSON
+func (a *auth) setCookie(w http.ResponseWriter, name string, path string, v any, expires time.Time) {
+	data, _ := json.Marshal(v)
+	payload := base64.RawURLEncoding.EncodeToString(data)
+	http.SetCookie(w, a.cookie(name, path, payload+"."+a.sign(payload), expires))
+}
+
+// readCookie reads a cookie set by setCookie into v, and reports whether it
+// was there with a valid signature
+func (a *auth) readCookie(r *http.Request, name string, v any) bool {
+	c, err := r.Cookie(name)
+	if err != nil {
+		return false
+	}
+	payload, signature, ok := strings.Cut(c.Value, ".")
+	if !ok || !hmac.Equal([]byte(signature), []byte(a.sign(payload))) {
+		return false
+	}
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	return err == nil && json.Unmarshal(data, v) == nil
+}
+
+func (a *auth) clearCookie(w http.ResponseWriter, name string, path string) {
+	http.SetCookie(w, a.cookie(name, path, "", time.Unix(0, 0)))
+}
+
+func (a *auth) cookie(name string, path string, value string, expires time.Time) *http.Cookie {
+	return &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     a.h.Config().RootPath + path,
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(a.h.Config().BaseURL, "https://"),
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+// startSession sets the cookie logging the user of s in, and returns the
+// session with its ID
+func (a *auth) startSession(w http.ResponseWriter, s session) session {
+	expires := time.Now().Add(a.h.Config().SessionTimeout)
+	s.ID = randomID()
+	s.Expires = expires.Unix()
+	a.setCookie(w, sessionCookie, "admin", s, expires)
+	return s
+}
+
+// session returns the session of the request and its user, or nil if there
+// is none or it is no longer valid
+func (a *auth) session(r *http.Request) (*session, *users.User) {
+	var s session
+	if !a.readCookie(r, sessionCookie, &s) || time.Now().Unix() > s.Expires {
+		return nil, nil
+	}
+	a.mtx.Lock()
+	_, revoked := a.revoked[s.ID]
+	a.mtx.Unlock()
+	if revoked {
+		return nil, nil
+	}
+	// The user is looked up again, so changes to roles apply at once
+	if s.SSO {
+		// Sessions are only started for verified addresses, and users with
+		// two-factor authentication get a session of their own once they
+		// give a code
+		user := ssoUser(a.h, &sso.Identity{Email: s.Email, EmailVerified: true, Name: s.Name, Groups: s.Groups})
+		if user == nil || user.Name != s.User || user.HasTOTP() {
+			return nil, nil
+		}
+		return &s, user
+	}
+	user := lookupUser(a.h, s.User)
+	if user == nil || a.fingerprint(user) != s.Password {
+		return nil, nil
+	}
+	return &s, user
+}
+
+// endSession logs out, and remembers the session until it would have expired
+func (a *auth) endSession(w http.ResponseWriter, s *session) {
+	a.mtx.Lock()
+	now := time.Now()
+	for id, expires := range a.revoked {
+		if now.After(expires) {
+			delete(a.revoked, id)
+		}
+	}
+	a.revoked[s.ID] = time.Unix(s.Expires, 0)
+	a.mtx.Unlock()
+	a.clearCookie(w, sessionCookie, "admin")
+}
+
+// csrfToken is tied to the session, so it can't be used with any other
+func (a *auth) csrfToken(s *session) string {
+	return a.sign("csrf:" + s.ID)
+}
+
+// require lets only logged in users through. Other users are sent to the
+// login form, and changes must come with the CSRF token of the session.
+func (a *auth) require(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s, user := a.session(r)
+		if user == nil {
+			if r.Method == http.MethodGet && r.Header.Get("Upgrade") == "" {
+				query := url.Values{"next": {r.RequestURI}}
+				http.Redirect(w, r, a.h.Config().RootPath+"admin/login?"+query.Encode(), http.StatusSeeOther)
+				return
+			}
+			msg := "Log in to continue"
+			a.h.ErrorHandler(w, r, http.StatusUnauthorized, &msg)
+			return
+		}
+		token := a.csrfToken(s)
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			sent := r.Header.Get(csrfHeader)
+			if sent == "" {
+				sent = r.PostFormValue(csrfField)
+			}
+			if !hmac.Equal([]byte(sent), []byte(token)) {
+				slog.Warn("Request with invalid CSRF token", "path", r.URL.Path, "user", user.Name)
+				msg := "Invalid or missing CSRF token, reload the page and try again"
+				a.h.ErrorHandler(w, r, http.StatusForbidden, &msg)
+				return
+			}
+		}
+		r = r.WithContext(withUser(r.Context(), user))
+		next(w, server.WithCSRFToken(r, token))
+	}
+}
+
+// clientIP returns the address logins are throttled by. Forwarded headers
+// are ignored, since anyone can set them.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// throttled returns how long ip has to wait before trying to log in again
+func (a *auth) throttled(ip string) time.Duration {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	f, ok := a.failures[ip]
+	if !ok {
+		return 0
+	}
+	wait := time.Until(f.first.Add(loginWindow))
+	if wait <= 0 {
+		delete(a.failures, ip)
+		return 0
+	}
+	if f.count < maxLoginFailures {
+		return 0
+	}
+	return wait
+}
+
+func retryAfter(w http.ResponseWriter, wait time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+}
+
+func (a *auth) loginFailed(ip string) {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	now := time.Now()
+	for addr, f := range a.failures {
+		if now.Sub(f.first) > loginWindow {
+			delete(a.failures, addr)
+		}
+	}
+	f, ok := a.failures[ip]
+	if !ok {
+		f = &loginFailures{first: now}
+		a.failures[ip] = f
+	}
+	f.count++
+}
+
+func (a *auth) loginSucceeded(ip string) {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	delete(a.failures, ip)
+}
+
+// sameOrigin reports whether the Origin header names the site itself, either
+// as it was requested or by its base URL
+func sameOrigin(h *server.Hubro, r *http.Request) bool {
+	origin, err := url.Parse(r.Header.Get("Origin"))
+	if err != nil || origin.Host == "" {
+		return false
+	}
+	if strings.EqualFold(origin.Host, r.Host) {
+		return true
+	}
+	base, err := url.Parse(h.Config().BaseURL)
+	return err == nil && strings.EqualFold(origin.Host, base.Host)
+}
+
+// nextPath returns where to go after logging in, which must be in the admin interface
+func nextPath(h *server.Hubro, next string) string {
+	admin := h.Config().RootPath + "admin"
+	if !strings.HasPrefix(next, admin) || strings.HasPrefix(next, "//") || strings.Contains(next, "\\") {
+		return admin + "/"
+	}
+	return next
+}
+
+func (a *auth) renderLogin(w http.ResponseWriter, r *http.Request, status int, next string, loginErr string) {
+	data := struct {
+		Next  string
+		Error string
+		SSO   bool
+	}{
+		Next:  next,
+		Error: loginErr,
+		SSO:   a.h.Config().OIDCIssuer != "",
+	}
+	w.WriteHeader(status)
+	a.h.RenderWithLayout(w, r, "admin/app", "admin/login", data)
+}
+
+func (a *auth) loginFormHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		next := nextPath(a.h, r.FormValue("next"))
+		if _, user := a.session(r); user != nil {
+			http.Redirect(w, r, next, http.StatusSeeOther)
+			return
+		}
+		a.renderLogin(w, r, http.StatusOK, next, "")
+	}
+}
+
+func (a *auth) loginHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// There is no session to tie a CSRF token to yet, so logins from
+		// other sites are refused by their origin instead
+		if r.Header.Get("Origin") != "" && !sameOrigin(a.h, r) {
+			msg := "Cross-origin login refused"
+			a.h.ErrorHandler(w, r, http.StatusForbidden, &msg)
+			return
+		}
+		next := nextPath(a.h, r.FormValue("next"))
+		ip := clientIP(r)
+		if wait := a.throttled(ip); wait > 0 {
+			slog.Warn("Login throttled", "remoteAddr", ip, "retryAfter", wait.Round(time.Second))
+			retryAfter(w, wait)
+			a.renderLogin(w, r, http.StatusTooManyRequests, next, "Too many failed logins, try again later")
+			return
+		}
+		name := r.PostFormValue("name")
+		user, ok := authenticate(a.h, name, r.PostFormValue("password"))
+		if !ok {
+			a.loginFailed(ip)
+			slog.Warn("Failed login", "user", name, "remoteAddr", ip)
+			a.renderLogin(w, r, http.StatusUnauthorized, next, "Wrong user name or password")
+			return
+		}
+		// Failed logins are only forgotten once the code is right too
+		if user.HasTOTP() {
+			a.askForCode(w, r, user, next)
+			return
+		}
+		a.loginSucceeded(ip)
+		a.startSession(w, session{User: user.Name, Password: a.fingerprint(user)})
+		slog.Info("Logged in", "user", user.Name, "remoteAddr", ip)
+		http.Redirect(w, r, next, http.StatusSeeOther)
+	}
+}
+
+func (a *auth) logoutHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s, _ := a.session(r); s != nil {
+			a.endSession(w, s)
+		}
+		slog.Info("Logged out", "user", currentUser(r).Name)
+		http.Redirect(w, r, a.h.Config().RootPath+"admin/login", http.StatusSeeOther)
+	}
+}
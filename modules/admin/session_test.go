@@ -0,0 +1,307 @@
+package admin
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/sokkalf/hubro/config"
+	"github.com/sokkalf/hubro/server"
+)
+
+const (
+	adminPassword = "correct horse"
+	// The password of users added by tests
+	userPassword = "long enough password"
+)
+
+// testAdmin returns a site with the admin interface, where admin logs in with
+// adminPassword until users are added
+func testAdmin(t *testing.T) *server.Hubro {
+	t.Setenv("HUBRO_CONFIG_FILE", "")
+	t.Setenv("HUBRO_BASE_URL", "http://localhost:8080/")
+	if err := config.Init(); err != nil {
+		t.Fatal(err)
+	}
+	config.Update(func(c *config.HubroConfig) {
+		c.AdminPassword = adminPassword
+		c.SessionSecret = "test secret"
+		c.UsersFile = filepath.Join(t.TempDir(), "users.yaml")
+	})
+	views := fstest.MapFS{
+		"app.gohtml":              {Data: []byte(`{{yield}}`)},
+		"admin/app.gohtml":        {Data: []byte(`{{yield}}`)},
+		"admin/login.gohtml":      {Data: []byte(`{{.Error}}`)},
+		"admin/index.gohtml":      {Data: []byte(`{{csrfToken}}`)},
+		"admin/media.gohtml":      {Data: []byte(`{{with .Error}}{{.}}{{end}}`)},
+		"admin/totp_login.gohtml": {Data: []byte(`{{.Error}}`)},
+		"admin/totp.gohtml":       {Data: []byte(`{{.Error}}`)},
+		"errors/layout.gohtml":    {Data: []byte(`{{yield}}`)},
+		"errors/default.gohtml":   {Data: []byte(`Error {{.Status}}`)},
+	}
+	h := server.NewHubro(server.Config{LayoutDir: views, TemplateDir: views, StaticDir: fstest.MapFS{}, VendorDir: fstest.MapFS{}})
+	h.AddModule("/admin", Register, nil)
+	return h
+}
+
+func serve(h *server.Hubro, r *http.Request, cookie *http.Cookie) *httptest.ResponseRecorder {
+	if cookie != nil {
+		r.AddCookie(cookie)
+	}
+	w := httptest.NewRecorder()
+	h.Mux.ServeHTTP(w, r)
+	return w
+}
+
+func postLogin(h *server.Hubro, name string, password string) *httptest.ResponseRecorder {
+	form := url.Values{"name": {name}, "password": {password}, "next": {"/admin/"}}
+	r := httptest.NewRequest(http.MethodPost, "/admin/login", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return serve(h, r, nil)
+}
+
+// login returns the session cookie of admin and its CSRF token
+func login(t *testing.T, h *server.Hubro) (*http.Cookie, string) {
+	t.Helper()
+	return loginAs(t, h, "admin", adminPassword)
+}
+
+// loginAs returns the session cookie of a user and its CSRF token
+func loginAs(t *testing.T, h *server.Hubro, name string, password string) (*http.Cookie, string) {
+	t.Helper()
+	w := postLogin(h, name, password)
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("expected to be logged in, got %d: %s", w.Code, w.Body.String())
+	}
+	var cookie *http.Cookie
+	for _, c := range w.Result().Cookies() {
+		if c.Name == sessionCookie {
+			cookie = c
+		}
+	}
+	if cookie == nil {
+		t.Fatal("expected a session cookie")
+	}
+	w = serve(h, httptest.NewRequest(http.MethodGet, "/admin/", nil), cookie)
+	if w.Code != http.StatusOK || w.Body.Len() == 0 {
+		t.Fatalf("expected the admin page with a CSRF token, got %d", w.Code)
+	}
+	return cookie, w.Body.String()
+}
+
+// TestSessionCookies checks that only untampered and unexpired session
+// cookies log in, and that the cookie holds no hash of the password.
+func TestSessionCookies(t *testing.T) {
+	h := testAdmin(t)
+	cookie, _ := login(t, h)
+	a := newAuth(h)
+	r := httptest.NewRequest(http.MethodGet, "/admin/", nil)
+	r.AddCookie(cookie)
+	s, user := a.session(r)
+	if user == nil || user.Name != "admin" {
+		t.Fatalf("expected the session of admin, got %+v", s)
+	}
+	if s.Password != a.fingerprint(user) {
+		t.Errorf("expected the fingerprint of admin, got %q", s.Password)
+	}
+	payload, signature, _ := strings.Cut(cookie.Value, ".")
+	config.Update(func(c *config.HubroConfig) { c.SessionSecret = "other secret" })
+	if a.fingerprint(user) == s.Password {
+		t.Errorf("expected the fingerprint to depend on the session secret")
+	}
+	otherKey := payload + "." + a.sign(payload)
+	config.Update(func(c *config.HubroConfig) { c.SessionSecret = "test secret" })
+
+	tampered := []byte(payload)
+	tampered[len(tampered)/2] ^= 1
+	w := httptest.NewRecorder()
+	a.setCookie(w, sessionCookie, "admin", session{User: "admin", Password: s.Password, ID: "old", Expires: time.Now().Add(-time.Minute).Unix()},
+		time.Now().Add(time.Hour))
+	expired := w.Result().Cookies()[0]
+	for name, c := range map[string]*http.Cookie{
+		"tampered":    {Name: sessionCookie, Value: string(tampered) + "." + signature},
+		"unsigned":    {Name: sessionCookie, Value: payload},
+		"other key":   {Name: sessionCookie, Value: otherKey},
+		"expired":     expired,
+		"not a value": {Name: sessionCookie, Value: "x.y"},
+	} {
+		w := serve(h, httptest.NewRequest(http.MethodGet, "/admin/", nil), c)
+		if w.Code != http.StatusSeeOther || !strings.HasPrefix(w.Header().Get("Location"), "/admin/login?") {
+			t.Errorf("%s: expected to be sent to the login form, got %d", name, w.Code)
+		}
+	}
+
+	config.Update(func(c *config.HubroConfig) { c.AdminPassword = "changed" })
+	if w := serve(h, httptest.NewRequest(http.MethodGet, "/admin/", nil), cookie); w.Code != http.StatusSeeOther {
+		t.Errorf("expected changing the password to end the session, got %d", w.Code)
+	}
+}
+
+// TestCSRF checks that changes are refused without the CSRF token of the
+// session, sent as a header or a form field.
+func TestCSRF(t *testing.T) {
+	h := testAdmin(t)
+	cookie, token := login(t, h)
+	_, otherToken := login(t, h)
+	reload := func(header string, field string) int {
+		form := url.Values{}
+		if field != "" {
+			form.Set(csrfField, field)
+		}
+		r := httptest.NewRequest(http.MethodPost, "/admin/config/reload", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if header != "" {
+			r.Header.Set(csrfHeader, header)
+		}
+		return serve(h, r, cookie).Code
+	}
+	for _, c := range []struct {
+		header, field string
+		forbidden     bool
+	}{
+		{"", "", true},
+		{otherToken, "", true},
+		{"", otherToken, true},
+		{"wrong", token, true},
+		{token, "", false},
+		{"", token, false},
+	} {
+		if code := reload(c.header, c.field); (code == http.StatusForbidden) != c.forbidden {
+			t.Errorf("header %q and field %q: expected forbidden %v, got %d", c.header, c.field, c.forbidden, code)
+		}
+	}
+	r := httptest.NewRequest(http.MethodPost, "/admin/config/reload", nil)
+	r.Header.Set(csrfHeader, token)
+	if w := serve(h, r, nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401 without a session, got %d", w.Code)
+	}
+}
+
+// TestLoginThrottling checks that an address is refused after five failed
+// logins within the window, even with the right password.
+func TestLoginThrottling(t *testing.T) {
+	h := testAdmin(t)
+	for i := range maxLoginFailures {
+		if w := postLogin(h, "admin", "wrong"); w.Code != http.StatusUnauthorized {
+			t.Fatalf("attempt %d: expected 401, got %d", i+1, w.Code)
+		}
+	}
+	w := postLogin(h, "admin", adminPassword)
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Fatalf("expected 429 with Retry-After, got %d", w.Code)
+	}
+
+	a := newAuth(h)
+	for range maxLoginFailures - 1 {
+		a.loginFailed("192.0.2.2")
+	}
+	if a.throttled("192.0.2.2") != 0 {
+		t.Errorf("expected fewer than five failures to be allowed")
+	}
+	a.loginFailed("192.0.2.2")
+	if wait := a.throttled("192.0.2.2"); wait <= 0 || wait > loginWindow {
+		t.Errorf("expected to wait up to %s, got %s", loginWindow, wait)
+	}
+	if a.throttled("192.0.2.3") != 0 {
+		t.Errorf("expected other addresses not to be throttled")
+	}
+	a.failures["192.0.2.2"].first = time.Now().Add(-loginWindow - time.Second)
+	if a.throttled("192.0.2.2") != 0 {
+		t.Errorf("expected failures to be forgotten after %s", loginWindow)
+	}
+}
+
+// TestSameOrigin checks that websocket connections and logins from other
+// sites are refused.
+func TestSameOrigin(t *testing.T) {
+	h := testAdmin(t)
+	for _, c := range []struct {
+		host, origin string
+		want         bool
+	}{
+		{"localhost:8080", "http://localhost:8080", true},
+		{"blog.example.org", "https://blog.example.org", true},
+		{"blog.example.org", "http://localhost:8080", true},
+		{"localhost:8080", "http://evil.com", false},
+		{"localhost:8080", "http://localhost:8081", false},
+		{"localhost:8080", "null", false},
+		{"localhost:8080", "", false},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/admin/ws", nil)
+		r.Host = c.host
+		r.Header.Set("Origin", c.origin)
+		if got := sameOrigin(h, r); got != c.want {
+			t.Errorf("origin %q for host %q: expected %v, got %v", c.origin, c.host, c.want, got)
+		}
+	}
+
+	cookie, _ := login(t, h)
+	r := httptest.NewRequest(http.MethodGet, "/admin/ws", nil)
+	r.Header.Set("Connection", "Upgrade")
+	r.Header.Set("Upgrade", "websocket")
+	r.Header.Set("Origin", "http://evil.com")
+	if w := serve(h, r, cookie); w.Code != http.StatusForbidden {
+		t.Errorf("expected 403 for a websocket from another origin, got %d", w.Code)
+	}
+
+	form := url.Values{"name": {"admin"}, "password": {adminPassword}}
+	r = httptest.NewRequest(http.MethodPost, "/admin/login", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.Header.Set("Origin", "http://evil.com")
+	if w := serve(h, r, nil); w.Code != http.StatusForbidden {
+		t.Errorf("expected 403 for a login from another origin, got %d", w.Code)
+	}
+}
+
+// TestNextPath checks that logging in only leads to the admin interface.
+func TestNextPath(t *testing.T) {
+	h := testAdmin(t)
+	for next, want := range map[string]string{
+		"/admin/edit?file=post.md":    "/admin/edit?file=post.md",
+		"/admin/":                     "/admin/",
+		"":                            "/admin/",
+		"//evil.com":                  "/admin/",
+		"//evil.com/admin":            "/admin/",
+		"https://evil.com/admin/":     "/admin/",
+		"http://localhost:8080/admin": "/admin/",
+		"/admin\\evil.com":            "/admin/",
+		"/blog/":                      "/admin/",
+	} {
+		if got := nextPath(h, next); got != want {
+			t.Errorf("%q: expected %q, got %q", next, want, got)
+		}
+	}
+}
+
+// TestLogout checks that a session can't be used again after logging out,
+// even if the cookie is kept.
+func TestLogout(t *testing.T) {
+	h := testAdmin(t)
+	cookie, token := login(t, h)
+	r := httptest.NewRequest(http.MethodPost, "/admin/logout", nil)
+	r.Header.Set(csrfHeader, token)
+	w := serve(h, r, cookie)
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("expected to be logged out, got %d", w.Code)
+	}
+	cleared := false
+	for _, c := range w.Result().Cookies() {
+		cleared = cleared || (c.Name == sessionCookie && c.Value == "")
+	}
+	if !cleared {
+		t.Errorf("expected the session cookie to be cleared")
+	}
+	if w := serve(h, httptest.NewRequest(http.MethodGet, "/admin/", nil), cookie); w.Code != http.StatusSeeOther {
+		t.Errorf("expected the session to be revoked, got %d", w.Code)
+	}
+
+	other, _ := login(t, h)
+	if w := serve(h, httptest.NewRequest(http.MethodGet, "/admin/", nil), other); w.Code != http.StatusOK {
+		t.Errorf("expected other sessions to be kept, got %d", w.Code)
+	}
+}
@@ -0,0 +1,242 @@
+package admin
+
+import (
+	"encoding/base64"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/skip2/go-qrcode"
+	"github.com/sokkalf/hubro/server"
+	"github.com/sokkalf/hubro/totp"
+	"github.com/sokkalf/hubro/users"
+)
+
+const (
+	totpCookie = "hubro_totp"
+	// How long the code can be entered after the password
+	totpLoginTimeout  = 5 * time.Minute
+	recoveryCodeCount = 10
+)
+
+// pendingLogin is kept in a cookie between the password and the code
+type pendingLogin struct {
+	User     string `json:"u"`
+	Password string `json:"p"`
+	Next     string `json:"n"`
+	Expires  int64  `json:"e"`
+}
+
+// useStep records the time step of a code used by user, and reports whether
+// it is newer than the last one, so that a code can't be used twice
+func (a *auth) useStep(user string, step int64) bool {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	if step <= a.lastStep[user] {
+		return false
+	}
+	a.lastStep[user] = step
+	return true
+}
+
+// checkCode reports whether code is the current code of user, or one of
+// their recovery codes, which can then not be used again
+func (a *auth) checkCode(user *users.User, code string) bool {
+	if step, ok := totp.Validate(user.TOTPSecret, code, time.Now()); ok {
+		return a.useStep(user.Name, step)
+	}
+	store, err := userStore(a.h)
+	if err == nil && store.UseRecoveryCode(user.Name, code) {
+		slog.Warn("Recovery code used", "user", user.Name, "remaining", len(user.RecoveryCodes)-1)
+		return true
+	}
+	return false
+}
+
+// askForCode continues a login with a correct password by asking for the code
+func (a *auth) askForCode(w http.ResponseWriter, r *http.Request, user *users.User, next string) {
+	expires := time.Now().Add(totpLoginTimeout)
+	a.setCookie(w, totpCookie, "admin/login", pendingLogin{
+		User:     user.Name,
+		Password: a.fingerprint(user),
+		Next:     next,
+		Expires:  expires.Unix(),
+	}, expires)
+	a.renderCodeForm(w, r, http.StatusOK, "")
+}
+
+func (a *auth) renderCodeForm(w http.ResponseWriter, r *http.Request, status int, codeErr string) {
+	w.WriteHeader(status)
+	a.h.RenderWithLayout(w, r, "admin/app", "admin/totp_login", struct{ Error string }{codeErr})
+}
+
+func (a *auth) totpLoginHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Origin") != "" && !sameOrigin(a.h, r) {
+			msg := "Cross-origin login refused"
+			a.h.ErrorHandler(w, r, http.StatusForbidden, &msg)
+			return
+		}
+		var pending pendingLogin
+		if !a.readCookie(r, totpCookie, &pending) || time.Now().Unix() > pending.Expires {
+			a.renderLogin(w, r, http.StatusUnauthorized, nextPath(a.h, ""), "The login took too long, try again")
+			return
+		}
+		ip := clientIP(r)
+		if wait := a.throttled(ip); wait > 0 {
+			slog.Warn("Login throttled", "remoteAddr", ip, "retryAfter", wait.Round(time.Second))
+			retryAfter(w, wait)
+			a.renderCodeForm(w, r, http.StatusTooManyRequests, "Too many failed logins, try again later")
+			return
+		}
+		user := lookupUser(a.h, pending.User)
+		if user == nil || !user.HasTOTP() || a.fingerprint(user) != pending.Password {
+			a.clearCookie(w, totpCookie, "admin/login")
+			a.renderLogin(w, r, http.StatusUnauthorized, pending.Next, "Log in again")
+			return
+		}
+		if !a.checkCode(user, r.PostFormValue("code")) {
+			a.loginFailed(ip)
+			slog.Warn("Failed two-factor login", "user", user.Name, "remoteAddr", ip)
+			a.renderCodeForm(w, r, http.StatusUnauthorized, "Wrong code")
+			return
+		}
+		a.loginSucceeded(ip)
+		a.clearCookie(w, totpCookie, "admin/login")
+		a.startSession(w, session{User: user.Name, Password: a.fingerprint(user)})
+		slog.Info("Logged in", "user", user.Name, "remoteAddr", ip, "twoFactor", true)
+		http.Redirect(w, r, pending.Next, http.StatusSeeOther)
+	}
+}
+
+type totpPage struct {
+	// Users logging in with the admin password or single sign-on can't enable it
+	Unavailable   bool
+	Enabled       bool
+	RecoveryCodes int
+	Secret        string
+	QRCode        template.URL
+	// Shown once, after enabling
+	NewRecoveryCodes []string
+	Error            string
+}
+
+func qrCode(content string) template.URL {
+	png, err := qrcode.Encode(content, qrcode.Medium, 256)
+	if err != nil {
+		slog.Error("Error creating QR code", "error", err)
+		return ""
+	}
+	return template.URL("data:image/png;base64," + base64.StdEncoding.EncodeToString(png))
+}
+
+// storedUser returns the current user as stored in the users file, or nil
+// if they logged in some other way
+func (a *auth) storedUser(r *http.Request) *users.User {
+	store, err := userStore(a.h)
+	if err != nil || store.Empty() {
+		return nil
+	}
+	user, err := store.Get(currentUser(r).Name)
+	if err != nil {
+		return nil
+	}
+	return user
+}
+
+func (a *auth) renderTOTP(w http.ResponseWriter, r *http.Request, status int, page totpPage) {
+	user := a.storedUser(r)
+	switch {
+	case user == nil:
+		page.Unavailable = true
+	case user.HasTOTP():
+		page.Enabled = true
+		page.RecoveryCodes = len(user.RecoveryCodes)
+	case page.Secret == "":
+		page.Secret = totp.NewSecret()
+		fallthrough
+	default:
+		page.QRCode = qrCode(totp.URI(a.h.Config().Title, user.Name, page.Secret))
+	}
+	w.WriteHeader(status)
+	a.h.RenderWithLayout(w, r, "admin/app", "admin/totp", page)
+}
+
+func (a *auth) totpHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		a.renderTOTP(w, r, http.StatusOK, totpPage{})
+	}
+}
+
+// totpEnableHandler turns on two-factor authentication once the user has
+// shown that their authenticator has the secret
+func (a *auth) totpEnableHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := a.storedUser(r)
+		secret := r.PostFormValue("secret")
+		if user == nil || user.HasTOTP() {
+			a.renderTOTP(w, r, http.StatusBadRequest, totpPage{})
+			return
+		}
+		step, ok := totp.Validate(secret, r.PostFormValue("code"), time.Now())
+		if !ok {
+			a.renderTOTP(w, r, http.StatusBadRequest, totpPage{Secret: secret, Error: "Wrong code, check the time on your device"})
+			return
+		}
+		a.useStep(user.Name, step)
+		codes := totp.NewRecoveryCodes(recoveryCodeCount)
+		store, err := userStore(a.h)
+		if err == nil {
+			err = store.EnableTOTP(user.Name, secret, codes)
+		}
+		if err == nil {
+			user, err = store.Get(user.Name)
+		}
+		if err != nil {
+			msg := "Error enabling two-factor authentication"
+			slog.Error(msg, "user", user.Name, "error", err)
+			a.h.ErrorHandler(w, r, http.StatusInternalServerError, &msg)
+			return
+		}
+		slog.Info("Two-factor authentication enabled", "user", user.Name)
+		// Other sessions of the user end, this one continues with a new CSRF token
+		s := a.startSession(w, session{User: user.Name, Password: a.fingerprint(user)})
+		a.renderTOTP(w, server.WithCSRFToken(r, a.csrfToken(&s)), http.StatusOK, totpPage{NewRecoveryCodes: codes})
+	}
+}
+
+// totpDisableHandler turns off two-factor authentication, with a current code
+func (a *auth) totpDisableHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := a.storedUser(r)
+		if user == nil || !user.HasTOTP() {
+			a.renderTOTP(w, r, http.StatusBadRequest, totpPage{})
+			return
+		}
+		if !a.checkCode(user, r.PostFormValue("code")) {
+			a.renderTOTP(w, r, http.StatusBadRequest, totpPage{Error: "Wrong code"})
+			return
+		}
+		store, err := userStore(a.h)
+		if err == nil {
+			err = store.DisableTOTP(user.Name)
+		}
+		if err == nil {
+			user, err = store.Get(user.Name)
+		}
+		if err != nil {
+			msg := "Error disabling two-factor authentication"
+			slog.Error(msg, "user", user.Name, "error", err)
+			a.h.ErrorHandler(w, r, http.StatusInternalServerError, &msg)
+			return
+		}
+		slog.Info("Two-factor authentication disabled", "user", user.Name)
+		a.startSession(w, session{User: user.Name, Password: a.fingerprint(user)})
+		http.Redirect(w, r, a.h.Config().RootPath+"admin/account/totp", http.StatusSeeOther)
+	}
+}
+
+func resetTOTP(r *http.Request, store *users.Store) error {
+	return store.DisableTOTP(r.FormValue("name"))
+}
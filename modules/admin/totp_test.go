@@ -0,0 +1,158 @@
+package admin
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sokkalf/hubro/server"
+	"github.com/sokkalf/hubro/totp"
+	"github.com/sokkalf/hubro/users"
+)
+
+// testTOTP returns a site where the author writer has two-factor
+// authentication, with its secret and recovery codes
+func testTOTP(t *testing.T) (*server.Hubro, string, []string) {
+	h := testAdmin(t)
+	store, err := userStore(h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Add(users.User{Name: "writer", Role: users.RoleAuthor}, userPassword); err != nil {
+		t.Fatal(err)
+	}
+	secret, codes := totp.NewSecret(), totp.NewRecoveryCodes(2)
+	if err := store.EnableTOTP("writer", secret, codes); err != nil {
+		t.Fatal(err)
+	}
+	return h, secret, codes
+}
+
+// pendingCookie logs in writer with the password, and returns the cookie
+// that carries the login on to the code
+func pendingCookie(t *testing.T, h *server.Hubro) *http.Cookie {
+	t.Helper()
+	w := postLogin(h, "writer", userPassword)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected to be asked for the code, got %d: %s", w.Code, w.Body.String())
+	}
+	for _, c := range w.Result().Cookies() {
+		if c.Name == sessionCookie {
+			t.Fatal("expected no session before the code")
+		}
+		if c.Name == totpCookie {
+			return c
+		}
+	}
+	t.Fatal("expected a cookie for the pending login")
+	return nil
+}
+
+func postCode(h *server.Hubro, cookie *http.Cookie, code string) *httptest.ResponseRecorder {
+	form := url.Values{"code": {code}}
+	r := httptest.NewRequest(http.MethodPost, "/admin/login/totp", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return serve(h, r, cookie)
+}
+
+func currentCode(t *testing.T, secret string) string {
+	code, err := totp.Code(secret, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return code
+}
+
+// TestTOTPLogin checks that a code is only accepted once, and that each
+// recovery code works once.
+func TestTOTPLogin(t *testing.T) {
+	h, secret, codes := testTOTP(t)
+	code := currentCode(t, secret)
+	if w := postCode(h, pendingCookie(t, h), code); w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/admin/" {
+		t.Fatalf("expected to be logged in with the code, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := postCode(h, pendingCookie(t, h), code); w.Code != http.StatusUnauthorized || w.Body.String() != "Wrong code" {
+		t.Errorf("expected the code to be refused the second time, got %d: %s", w.Code, w.Body.String())
+	}
+
+	if w := postCode(h, pendingCookie(t, h), codes[0]); w.Code != http.StatusSeeOther {
+		t.Fatalf("expected to be logged in with a recovery code, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := postCode(h, pendingCookie(t, h), codes[0]); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected the recovery code to be used up, got %d", w.Code)
+	}
+	if user := lookupUser(h, "writer"); len(user.RecoveryCodes) != 1 {
+		t.Errorf("expected one recovery code left, got %d", len(user.RecoveryCodes))
+	}
+	if w := postCode(h, pendingCookie(t, h), codes[1]); w.Code != http.StatusSeeOther {
+		t.Errorf("expected the other recovery code to work, got %d", w.Code)
+	}
+}
+
+// TestTOTPPendingLogin checks that the code has to follow the password
+// within the timeout, and before the password or secret changes.
+func TestTOTPPendingLogin(t *testing.T) {
+	h, secret, _ := testTOTP(t)
+	a := newAuth(h)
+	user := lookupUser(h, "writer")
+	w := httptest.NewRecorder()
+	a.setCookie(w, totpCookie, "admin/login", pendingLogin{User: "writer", Password: a.fingerprint(user), Next: "/admin/",
+		Expires: time.Now().Add(-time.Second).Unix()}, time.Now().Add(time.Minute))
+	expired := w.Result().Cookies()[0]
+	if w := postCode(h, expired, currentCode(t, secret)); w.Code != http.StatusUnauthorized || w.Body.String() != "The login took too long, try again" {
+		t.Errorf("expected an expired login to be refused, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := postCode(h, nil, currentCode(t, secret)); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected a code without a login to be refused, got %d", w.Code)
+	}
+
+	store, err := userStore(h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cookie := pendingCookie(t, h)
+	if err := store.SetPassword("writer", "another long password"); err != nil {
+		t.Fatal(err)
+	}
+	if w := postCode(h, cookie, currentCode(t, secret)); w.Code != http.StatusUnauthorized || w.Body.String() != "Log in again" {
+		t.Errorf("expected changing the password to end the login, got %d: %s", w.Code, w.Body.String())
+	}
+	if err := store.SetPassword("writer", userPassword); err != nil {
+		t.Fatal(err)
+	}
+
+	cookie = pendingCookie(t, h)
+	secret = totp.NewSecret()
+	if err := store.EnableTOTP("writer", secret, nil); err != nil {
+		t.Fatal(err)
+	}
+	if w := postCode(h, cookie, currentCode(t, secret)); w.Code != http.StatusUnauthorized || w.Body.String() != "Log in again" {
+		t.Errorf("expected changing the secret to end the login, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+// TestTOTPThrottling checks that wrong codes count as failed logins, so the
+// right code is refused after too many.
+func TestTOTPThrottling(t *testing.T) {
+	h, secret, _ := testTOTP(t)
+	cookie := pendingCookie(t, h)
+	wrong := "000000"
+	if _, ok := totp.Validate(secret, wrong, time.Now()); ok {
+		wrong = "111111"
+	}
+	for i := range maxLoginFailures {
+		if w := postCode(h, cookie, wrong); w.Code != http.StatusUnauthorized {
+			t.Fatalf("attempt %d: expected 401, got %d", i+1, w.Code)
+		}
+	}
+	w := postCode(h, cookie, currentCode(t, secret))
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Fatalf("expected 429 with Retry-After, got %d", w.Code)
+	}
+	if w := postLogin(h, "writer", userPassword); w.Code != http.StatusTooManyRequests {
+		t.Errorf("expected the password login to be throttled too, got %d", w.Code)
+	}
+}
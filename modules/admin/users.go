@@ -0,0 +1,227 @@
+package admin
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sync"
+
+	"github.com/sokkalf/hubro/index"
+	"github.com/sokkalf/hubro/server"
+	"github.com/sokkalf/hubro/users"
+)
+
+type userKey struct{}
+
+// The user stores by file, shared by sites using the same file
+var stores sync.Map
+
+func userStore(h *server.Hubro) (*users.Store, error) {
+	path := h.Config().UsersFile
+	if store, ok := stores.Load(path); ok {
+		return store.(*users.Store), nil
+	}
+	store, err := users.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	actual, _ := stores.LoadOrStore(path, store)
+	return actual.(*users.Store), nil
+}
+
+// authenticate checks a user name and password against the users file. Until
+// the first user is added, admin can log in with the configured admin password.
+func authenticate(h *server.Hubro, name string, password string) (*users.User, bool) {
+	store, err := userStore(h)
+	if err != nil {
+		slog.Error("Error reading users", "error", err)
+		return nil, false
+	}
+	if store.Empty() {
+		adminPassword := h.Config().AdminPassword
+		if adminPassword == "" || name != "admin" ||
+			subtle.ConstantTimeCompare([]byte(password), []byte(adminPassword)) != 1 {
+			return nil, false
+		}
+		return legacyAdmin(h), true
+	}
+	return store.Authenticate(name, password)
+}
+
+// legacyAdmin is the user logging in with the admin password. Its password
+// hash is only used to end its sessions when the admin password changes.
+func legacyAdmin(h *server.Hubro) *users.User {
+	sum := sha256.Sum256([]byte(h.Config().AdminPassword))
+	return &users.User{
+		Name:         "admin",
+		DisplayName:  h.Config().AuthorName,
+		Role:         users.RoleAdmin,
+		PasswordHash: hex.EncodeToString(sum[:]),
+	}
+}
+
+// lookupUser returns the user of a session, or nil if it no longer exists
+func lookupUser(h *server.Hubro, name string) *users.User {
+	store, err := userStore(h)
+	if err != nil {
+		slog.Error("Error reading users", "error", err)
+		return nil
+	}
+	if store.Empty() {
+		if name != "admin" || h.Config().AdminPassword == "" {
+			return nil
+		}
+		return legacyAdmin(h)
+	}
+	user, err := store.Get(name)
+	if err != nil {
+		return nil
+	}
+	return user
+}
+
+func withUser(ctx context.Context, user *users.User) context.Context {
+	return context.WithValue(ctx, userKey{}, user)
+}
+
+// currentUser returns the logged in user, for handlers wrapped in auth.require
+func currentUser(r *http.Request) *users.User {
+	return r.Context().Value(userKey{}).(*users.User)
+}
+
+// adminOnly lets only users with the admin role through
+func adminOnly(h *server.Hubro, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !currentUser(r).CanManageUsers() {
+			msg := "Only admins can do this"
+			h.ErrorHandler(w, r, http.StatusForbidden, &msg)
+			return
+		}
+		next(w, r)
+	}
+}
+
+var (
+	errNotAuthor     = errors.New("You can only edit your own entries")
+	errChangeAuthor  = errors.New("You can't change the author of an entry")
+	errPublishDraft  = errors.New("Only editors can publish drafts")
+	errFirstAdmin    = errors.New("The first user must be an admin")
+	errDeleteCurrent = errors.New("You can't delete yourself")
+)
+
+// checkChange returns an error if user may not replace entry with data, or
+// create an entry with data if entry is nil
+func checkChange(user *users.User, entry *index.IndexEntry, data []byte) error {
+	if entry != nil && !user.CanEdit(entry.Author) {
+		return errNotAuthor
+	}
+	if user.CanPublish() {
+		return nil
+	}
+	_, metaData, err := renderMarkdown(data, nil)
+	if err != nil {
+		return err
+	}
+	author, _ := metaData["author"].(string)
+	if !user.IsAuthorOf(author) {
+		return errChangeAuthor
+	}
+	// New entries start as drafts
+	if draft, _ := metaData["draft"].(bool); (entry == nil || entry.Draft) && !draft {
+		return errPublishDraft
+	}
+	return nil
+}
+
+// entryByFileName returns the entry of idx read from fileName, or nil
+func entryByFileName(idx *index.Index, fileName string) *index.IndexEntry {
+	for _, entry := range idx.GetEntries() {
+		if entry.FileName == fileName {
+			return &entry
+		}
+	}
+	return nil
+}
+
+func renderUsers(h *server.Hubro, w http.ResponseWriter, r *http.Request, status int, userErr error) {
+	store, err := userStore(h)
+	var list []users.User
+	if err == nil {
+		list, err = store.List()
+	}
+	if err != nil {
+		msg := "Error reading users"
+		slog.Error(msg, "error", err)
+		h.ErrorHandler(w, r, http.StatusInternalServerError, &msg)
+		return
+	}
+	data := struct {
+		Users   []users.User
+		Roles   []users.Role
+		Current string
+		Error   error
+	}{
+		Users:   list,
+		Roles:   users.Roles,
+		Current: currentUser(r).Name,
+		Error:   userErr,
+	}
+	w.WriteHeader(status)
+	h.RenderWithLayout(w, r, "admin/app", "admin/users", data)
+}
+
+func adminUsersHandler(h *server.Hubro) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		renderUsers(h, w, r, http.StatusOK, nil)
+	}
+}
+
+// usersAction wraps a change to the users, which goes back to the list of
+// users if it succeeds, and shows the error with the list if not
+func usersAction(h *server.Hubro, action string, f func(r *http.Request, store *users.Store) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		store, err := userStore(h)
+		if err == nil {
+			err = f(r, store)
+		}
+		if err != nil {
+			slog.Warn("Error changing users", "action", action, "user", r.FormValue("name"), "error", err)
+			renderUsers(h, w, r, http.StatusBadRequest, err)
+			return
+		}
+		slog.Info("Users changed", "action", action, "user", r.FormValue("name"), "by", currentUser(r).Name)
+		http.Redirect(w, r, h.Config().RootPath+"admin/users", http.StatusSeeOther)
+	}
+}
+
+func addUser(r *http.Request, store *users.Store) error {
+	role := users.Role(r.FormValue("role"))
+	if store.Empty() && role != users.RoleAdmin {
+		return errFirstAdmin
+	}
+	return store.Add(users.User{
+		Name:        r.FormValue("name"),
+		DisplayName: r.FormValue("display_name"),
+		Email:       r.FormValue("email"),
+		Role:        role,
+	}, r.FormValue("password"))
+}
+
+func setRole(r *http.Request, store *users.Store) error {
+	return store.SetRole(r.FormValue("name"), users.Role(r.FormValue("role")))
+}
+
+func setPassword(r *http.Request, store *users.Store) error {
+	return store.SetPassword(r.FormValue("name"), r.FormValue("password"))
+}
+
+func deleteUser(r *http.Request, store *users.Store) error {
+	if r.FormValue("name") == currentUser(r).Name {
+		return errDeleteCurrent
+	}
+	return store.Delete(r.FormValue("name"))
+}
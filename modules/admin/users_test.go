@@ -0,0 +1,63 @@
+package admin
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/sokkalf/hubro/index"
+	"github.com/sokkalf/hubro/users"
+)
+
+// TestNewEntryData checks that new entries are drafts by their creator,
+// whatever the title.
+func TestNewEntryData(t *testing.T) {
+	author := &users.User{Name: "writer", DisplayName: "Writer", Role: users.RoleAuthor}
+	for _, title := range []string{"Hello", "Colons: and 'quotes'", "---", "# Not a comment", "draft: false"} {
+		data, err := newEntryData(author, title, "2024-05-01")
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", title, err)
+			continue
+		}
+		_, metaData, err := renderMarkdown(data, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if metaData["title"] != title || metaData["author"] != "Writer" || metaData["draft"] != true {
+			t.Errorf("%q: unexpected front matter %v", title, metaData)
+		}
+	}
+	for _, title := range []string{"", "  ", "x\n---\ndraft: false", "x\r---", "tab\tbed"} {
+		if _, err := newEntryData(author, title, "2024-05-01"); !errors.Is(err, errTitle) {
+			t.Errorf("%q: expected errTitle, got %v", title, err)
+		}
+	}
+}
+
+// TestCheckChange checks what authors may write, also for new entries.
+func TestCheckChange(t *testing.T) {
+	author := &users.User{Name: "writer", DisplayName: "Writer", Role: users.RoleAuthor}
+	editor := &users.User{Name: "boss", Role: users.RoleEditor}
+	draft := []byte("---\ntitle: x\nauthor: Writer\ndraft: true\n---\n")
+	published := []byte("---\ntitle: x\nauthor: Writer\n---\n")
+	someoneElse := []byte("---\ntitle: x\nauthor: Someone\ndraft: true\n---\n")
+	own := &index.IndexEntry{Author: "Writer", Draft: true}
+	for _, c := range []struct {
+		user  *users.User
+		entry *index.IndexEntry
+		data  []byte
+		want  error
+	}{
+		{author, nil, draft, nil},
+		{author, nil, published, errPublishDraft},
+		{author, nil, someoneElse, errChangeAuthor},
+		{author, own, draft, nil},
+		{author, own, published, errPublishDraft},
+		{author, &index.IndexEntry{Author: "Someone"}, draft, errNotAuthor},
+		{editor, nil, published, nil},
+		{editor, &index.IndexEntry{Author: "Someone"}, published, nil},
+	} {
+		if err := checkChange(c.user, c.entry, c.data); !errors.Is(err, c.want) {
+			t.Errorf("%s changing %+v to %q: expected %v, got %v", c.user.Name, c.entry, c.data, c.want, err)
+		}
+	}
+}
@@ -1,28 +1,62 @@
 package feeds
 
 import (
+	"fmt"
 	"log/slog"
 	"net/http"
+	"net/url"
+	"slices"
 	"strings"
 	"sync"
 	"time"
 
 	gorillafeeds "github.com/gorilla/feeds"
-	"github.com/sokkalf/hubro/config"
 	"github.com/sokkalf/hubro/index"
 	"github.com/sokkalf/hubro/server"
+	"github.com/sokkalf/hubro/utils"
 )
 
+type feedKind int
+
+const (
+	allEntries feedKind = iota
+	byTag
+	byAuthor
+)
+
+// feedKey identifies a cached feed, value is the slug of the tag or author
+// name, so that every spelling of a name shares one feed
+type feedKey struct {
+	kind  feedKind
+	value string
+}
+
+var mainFeed = feedKey{kind: allEntries}
+
+// feed holds one feed in the formats supported by gorilla/feeds, and as JSON Feed
+type feed struct {
+	*gorillafeeds.Feed
+	json *JSONFeed
+}
+
 type Feeds struct {
-	feedCache      map[*index.Index]*gorillafeeds.Feed
+	hubro          *server.Hubro
+	index          *index.Index
+	prefix         string
+	feedCache      map[feedKey]*feed
 	feedCacheMutex sync.RWMutex
+	// Counts resets, so feeds built from the index before one are not kept
+	feedCacheGeneration uint64
 }
 
-func InitFeeds(i *index.Index) *Feeds {
+func InitFeeds(h *server.Hubro, i *index.Index, prefix string) *Feeds {
 	f := &Feeds{
-		feedCache: make(map[*index.Index]*gorillafeeds.Feed),
+		hubro:     h,
+		index:     i,
+		prefix:    prefix,
+		feedCache: make(map[feedKey]*feed),
 	}
-	f.feedCache[i] = getFeedFromIndex(i)
+	f.feedCache[mainFeed] = f.getFeedFromIndex(mainFeed)
 
 	go func() {
 		msgChan := i.MsgBroker.Subscribe()
@@ -30,9 +64,7 @@ func InitFeeds(i *index.Index) *Feeds {
 			switch <-msgChan {
 			case index.Updated:
 				slog.Debug("Resetting feed cache")
-				f.feedCacheMutex.Lock()
-				f.feedCache[i] = getFeedFromIndex(i)
-				f.feedCacheMutex.Unlock()
+				f.resetFeedCache()
 			default: // Ignore other messages
 			}
 		}
@@ -40,64 +72,186 @@ func InitFeeds(i *index.Index) *Feeds {
 	return f
 }
 
-func getFeedFromIndex(index *index.Index) *gorillafeeds.Feed {
-	config := config.Config
+// getFeed returns the cached feed for key, generating it on first use.
+// It returns nil if no entries match the key.
+func (f *Feeds) getFeed(key feedKey) *feed {
+	f.feedCacheMutex.RLock()
+	cached, ok := f.feedCache[key]
+	generation := f.feedCacheGeneration
+	f.feedCacheMutex.RUnlock()
+	if ok {
+		return cached
+	}
+
+	cached = f.getFeedFromIndex(key)
+	if len(cached.Items) == 0 {
+		return nil
+	}
+	f.cacheFeed(key, cached, generation)
+	return cached
+}
+
+// cacheFeed stores a feed built while the cache was at generation, unless
+// the cache has been reset since
+func (f *Feeds) cacheFeed(key feedKey, cached *feed, generation uint64) {
+	f.feedCacheMutex.Lock()
+	defer f.feedCacheMutex.Unlock()
+	if generation == f.feedCacheGeneration {
+		f.feedCache[key] = cached
+	}
+}
+
+// resetFeedCache drops all cached feeds and rebuilds the main feed
+func (f *Feeds) resetFeedCache() {
+	f.feedCacheMutex.Lock()
+	defer f.feedCacheMutex.Unlock()
+	f.feedCacheGeneration++
+	f.feedCache = make(map[feedKey]*feed)
+	f.feedCache[mainFeed] = f.getFeedFromIndex(mainFeed)
+}
+
+// feedPath returns the path of the feed for key, without the format suffix
+func (f *Feeds) feedPath(key feedKey) string {
+	switch key.kind {
+	case byTag:
+		return f.prefix + "/tag/" + url.PathEscape(key.value)
+	case byAuthor:
+		return f.prefix + "/author/" + url.PathEscape(key.value)
+	default:
+		return f.prefix
+	}
+}
+
+func (k feedKey) matches(entry index.IndexEntry) bool {
+	switch k.kind {
+	case byTag:
+		return slices.ContainsFunc(entry.Tags, func(tag string) bool { return utils.Slugify(tag) == k.value })
+	case byAuthor:
+		return entry.Author != "" && utils.Slugify(entry.Author) == k.value
+	default:
+		return true
+	}
+}
+
+func (f *Feeds) getFeedFromIndex(key feedKey) *feed {
+	config := f.hubro.Config()
 	var author *gorillafeeds.Author
 	if config.DisplayAuthorInFeed {
 		author = &gorillafeeds.Author{Name: config.AuthorName, Email: config.AuthorEmail}
 	} else {
 		author = nil
 	}
-	feed := &gorillafeeds.Feed{
-		Title:       "Hubro",
+	title := "Hubro"
+	baseURL := strings.TrimSuffix(config.BaseURL, "/")
+	gf := &gorillafeeds.Feed{
+		Title:       title,
 		Link:        &gorillafeeds.Link{Href: config.BaseURL},
 		Description: config.Description,
 		Author:      author,
-		Created:     index.GetEntries()[0].Date,
 	}
-
 	feedItems := []*gorillafeeds.Item{}
-	for i := range index.GetEntries() {
+	jsonItems := []JSONItem{}
+	for _, entry := range f.index.GetEntries() {
+		// Drafts and scheduled entries are not published yet
+		if entry.Draft || entry.Scheduled || !key.matches(entry) {
+			continue
+		}
+		if gf.Created.IsZero() {
+			gf.Created = entry.Date
+		}
+		// Use the names as written in the front matter rather than the slug from the URL
+		if key.kind == byTag && len(feedItems) == 0 {
+			tag := entry.Tags[slices.IndexFunc(entry.Tags, func(tag string) bool { return utils.Slugify(tag) == key.value })]
+			gf.Title = fmt.Sprintf("%s: %s", title, tag)
+			gf.Link = &gorillafeeds.Link{Href: config.BaseURL + "?" + url.Values{"tag": {tag}}.Encode()}
+		}
+		if key.kind == byAuthor && len(feedItems) == 0 {
+			gf.Title = fmt.Sprintf("%s: posts by %s", title, entry.Author)
+			gf.Author = &gorillafeeds.Author{Name: entry.Author}
+		}
 		var summary string
-		if index.GetEntries()[i].Summary != nil {
-			summary = string(*index.GetEntries()[i].Summary)
+		if entry.Summary != nil {
+			summary = string(*entry.Summary)
 		} else {
 			summary = "Description not available"
 		}
-		baseURL := strings.TrimSuffix(config.BaseURL, "/")
 
 		feedItems = append(feedItems, &gorillafeeds.Item{
-			Title:       index.GetEntries()[i].Title,
-			Link:        &gorillafeeds.Link{Href: baseURL + index.GetEntries()[i].Path},
-			Description: index.GetEntries()[i].Description,
-			Created:     index.GetEntries()[i].Date,
+			Title:       entry.Title,
+			Link:        &gorillafeeds.Link{Href: baseURL + entry.Path},
+			Description: entry.Description,
+			Created:     entry.Date,
 			Content:     summary,
 		})
+		jsonItems = append(jsonItems, jsonItemFromEntry(entry, baseURL))
+	}
+	gf.Items = feedItems
+	return &feed{
+		Feed: gf,
+		json: newJSONFeed(gf, baseURL+f.feedPath(key)+"/json", jsonItems),
+	}
+}
+
+func writeFeed(w http.ResponseWriter, feed *feed, format string) error {
+	switch format {
+	case "rss":
+		w.Header().Set("Content-Type", "application/rss+xml")
+		return feed.WriteRss(w)
+	case "atom":
+		w.Header().Set("Content-Type", "application/atom+xml")
+		return feed.WriteAtom(w)
+	case "json":
+		w.Header().Set("Content-Type", "application/feed+json")
+		return feed.json.Write(w)
+	default:
+		return fmt.Errorf("unknown feed format %q", format)
+	}
+}
+
+// handler serves a feed, the format is either fixed or taken from the {format} path value
+func (f *Feeds) handler(h *server.Hubro, kind feedKind, param string, fixedFormat string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := mainFeed
+		if kind != allEntries {
+			key = feedKey{kind: kind, value: utils.Slugify(r.PathValue(param))}
+		}
+		format := fixedFormat
+		if format == "" {
+			format = r.PathValue("format")
+		}
+		var feed *feed
+		if (format == "rss" || format == "atom" || format == "json") && (kind == allEntries || key.value != "") {
+			feed = f.getFeed(key)
+		}
+		if feed == nil {
+			msg := "Feed not found"
+			h.ErrorHandler(w, r, http.StatusNotFound, &msg)
+			return
+		}
+		if h.NotModified(w, r, time.Time{}) {
+			return
+		}
+		if err := writeFeed(w, feed, format); err != nil {
+			slog.Error("Error writing feed", "format", format, "error", err)
+		}
 	}
-	feed.Items = feedItems
-	return feed
 }
 
 func Register(prefix string, h *server.Hubro, mux *http.ServeMux, options any) {
 	start := time.Now()
 	index := options.(*index.Index)
-	feeds := InitFeeds(index)
+	feeds := InitFeeds(h, index, prefix)
 	if feeds == nil {
 		slog.Error("Failed to initialize feeds")
 		return
 	}
 
-	mux.HandleFunc("/rss", func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "application/rss+xml")
-		feeds.feedCacheMutex.RLock()
-		feeds.feedCache[index].WriteRss(w)
-		feeds.feedCacheMutex.RUnlock()
-	})
-	mux.HandleFunc("/atom", func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "application/atom+xml")
-		feeds.feedCacheMutex.RLock()
-		feeds.feedCache[index].WriteAtom(w)
-		feeds.feedCacheMutex.RUnlock()
-	})
-	slog.Info("Registered feeds", "atomUrl", prefix+"/atom", "rssUrl", prefix+"/rss", "duration", time.Since(start))
+	mux.HandleFunc("/rss", feeds.handler(h, allEntries, "", "rss"))
+	mux.HandleFunc("/atom", feeds.handler(h, allEntries, "", "atom"))
+	mux.HandleFunc("/json", feeds.handler(h, allEntries, "", "json"))
+	mux.HandleFunc("GET /tag/{tag}/{format}", feeds.handler(h, byTag, "tag", ""))
+	mux.HandleFunc("GET /author/{name}/{format}", feeds.handler(h, byAuthor, "name", ""))
+	slog.Info("Registered feeds", "atomUrl", prefix+"/atom", "rssUrl", prefix+"/rss", "jsonUrl", prefix+"/json",
+		"tagUrl", prefix+"/tag/{tag}/{rss,atom,json}", "authorUrl", prefix+"/author/{name}/{rss,atom,json}",
+		"duration", time.Since(start))
 }
@@ -0,0 +1,148 @@
+package feeds
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/sokkalf/hubro/config"
+	"github.com/sokkalf/hubro/index"
+	"github.com/sokkalf/hubro/server"
+)
+
+func testFeeds(t *testing.T) (*Feeds, http.Handler) {
+	t.Setenv("HUBRO_CONFIG_FILE", "")
+	t.Setenv("HUBRO_BASE_URL", "https://example.org/")
+	if err := config.Init(); err != nil {
+		t.Fatal(err)
+	}
+	views := fstest.MapFS{
+		"app.gohtml":            {Data: []byte(`{{yield}}`)},
+		"errors/layout.gohtml":  {Data: []byte(`{{yield}}`)},
+		"errors/default.gohtml": {Data: []byte(`Error {{.Status}}`)},
+	}
+	h := server.NewHubro(server.Config{LayoutDir: views, TemplateDir: views, StaticDir: fstest.MapFS{}, VendorDir: fstest.MapFS{}})
+
+	body := template.HTML("<p>Body</p>")
+	idx := h.Indices().NewIndex("blog", "/blog")
+	for _, e := range []index.IndexEntry{
+		{Id: "go", Path: "/go", Title: "Go", Author: "Jane Doe", Tags: []string{"Go Lang"}, Body: &body,
+			Date: time.Date(2024, 5, 2, 0, 0, 0, 0, time.UTC)},
+		{Id: "rust", Path: "/rust", Title: "Rust", Author: "John", Tags: []string{"rust"}, Body: &body,
+			Date: time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)},
+		{Id: "draft", Path: "/draft", Title: "Draft", Author: "Jane Doe", Tags: []string{"Go Lang"}, Draft: true},
+	} {
+		if err := idx.AddEntry(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	f := InitFeeds(h, idx, "/feeds")
+	mux := http.NewServeMux()
+	mux.HandleFunc("/json", f.handler(h, allEntries, "", "json"))
+	mux.HandleFunc("GET /tag/{tag}/{format}", f.handler(h, byTag, "tag", ""))
+	mux.HandleFunc("GET /author/{name}/{format}", f.handler(h, byAuthor, "name", ""))
+	return f, mux
+}
+
+func get(handler http.Handler, url string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, url, nil))
+	return rec
+}
+
+// TestFeedKeysAreSlugs checks that every spelling of a tag or author name
+// is served from one cached feed, and unknown names are not cached.
+func TestFeedKeysAreSlugs(t *testing.T) {
+	f, handler := testFeeds(t)
+	for _, url := range []string{"/author/Jane%20Doe/rss", "/author/JANE-DOE/atom", "/author/jAnE%20dOE/json",
+		"/tag/go-lang/rss", "/tag/GO%20LANG/json"} {
+		if rec := get(handler, url); rec.Code != http.StatusOK {
+			t.Errorf("%s: expected 200, got %d", url, rec.Code)
+		}
+	}
+	for _, url := range []string{"/author/nobody/rss", "/tag/Nothing/rss", "/tag/%21%21/rss"} {
+		if rec := get(handler, url); rec.Code != http.StatusNotFound {
+			t.Errorf("%s: expected 404, got %d", url, rec.Code)
+		}
+	}
+	f.feedCacheMutex.RLock()
+	defer f.feedCacheMutex.RUnlock()
+	if len(f.feedCache) != 3 {
+		t.Errorf("expected the main, one author and one tag feed to be cached, got %v", f.feedCache)
+	}
+	if feed := f.feedCache[feedKey{byTag, "go-lang"}]; feed == nil || feed.Title != "Hubro: Go Lang" {
+		t.Errorf("expected the tag as written in the front matter in the title, got %+v", feed)
+	}
+}
+
+// TestFeedBuiltBeforeReset checks that a feed built from the index before
+// the cache was reset is not cached.
+func TestFeedBuiltBeforeReset(t *testing.T) {
+	f, _ := testFeeds(t)
+	key := feedKey{byAuthor, "john"}
+	f.feedCacheMutex.RLock()
+	generation := f.feedCacheGeneration
+	f.feedCacheMutex.RUnlock()
+	stale := f.getFeedFromIndex(key)
+	f.resetFeedCache()
+	f.cacheFeed(key, stale, generation)
+	f.feedCacheMutex.RLock()
+	_, ok := f.feedCache[key]
+	f.feedCacheMutex.RUnlock()
+	if ok {
+		t.Fatal("expected the feed built before the reset not to be cached")
+	}
+	if f.getFeed(key) == nil {
+		t.Fatal("expected a feed for john")
+	}
+	f.feedCacheMutex.RLock()
+	defer f.feedCacheMutex.RUnlock()
+	if f.feedCache[key] == nil {
+		t.Error("expected the feed built after the reset to be cached")
+	}
+}
+
+// TestJSONFeed checks the JSON Feed 1.1 document, and that the tag and author
+// filters apply to it.
+func TestJSONFeed(t *testing.T) {
+	_, handler := testFeeds(t)
+	read := func(url string) JSONFeed {
+		rec := get(handler, url)
+		if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/feed+json" {
+			t.Fatalf("%s: expected a JSON feed, got %d %s", url, rec.Code, rec.Header().Get("Content-Type"))
+		}
+		var jf JSONFeed
+		if err := json.Unmarshal(rec.Body.Bytes(), &jf); err != nil {
+			t.Fatalf("%s: invalid JSON: %v", url, err)
+		}
+		return jf
+	}
+
+	jf := read("/json")
+	if jf.Version != "https://jsonfeed.org/version/1.1" || jf.FeedURL != "https://example.org/feeds/json" ||
+		jf.HomePageURL != "https://example.org/" {
+		t.Errorf("unexpected feed: %+v", jf)
+	}
+	if len(jf.Items) != 2 {
+		t.Fatalf("expected the published entries, got %+v", jf.Items)
+	}
+	item := jf.Items[0]
+	if item.ID != "https://example.org/blog/go" || item.URL != item.ID || item.ContentHTML != "<p>Body</p>" ||
+		item.DatePublished != "2024-05-02T00:00:00Z" || item.Authors[0].Name != "Jane Doe" || item.Tags[0] != "Go Lang" {
+		t.Errorf("unexpected item: %+v", item)
+	}
+
+	jf = read("/tag/go-lang/json")
+	if len(jf.Items) != 1 || jf.Items[0].Title != "Go" || jf.FeedURL != "https://example.org/feeds/tag/go-lang/json" ||
+		jf.HomePageURL != "https://example.org/?tag=Go+Lang" {
+		t.Errorf("unexpected tag feed: %+v", jf)
+	}
+	jf = read("/author/john/json")
+	if len(jf.Items) != 1 || jf.Items[0].Title != "Rust" || jf.Title != "Hubro: posts by John" || jf.Authors[0].Name != "John" {
+		t.Errorf("unexpected author feed: %+v", jf)
+	}
+}
@@ -0,0 +1,115 @@
+package feeds
+
+import (
+	"encoding/json"
+	"io"
+	"net/url"
+	"time"
+
+	gorillafeeds "github.com/gorilla/feeds"
+	"github.com/sokkalf/hubro/index"
+)
+
+const jsonFeedVersion = "https://jsonfeed.org/version/1.1"
+
+// JSONFeed is a JSON Feed 1.1 document, see https://www.jsonfeed.org/version/1.1/
+type JSONFeed struct {
+	Version     string       `json:"version"`
+	Title       string       `json:"title"`
+	HomePageURL string       `json:"home_page_url,omitempty"`
+	FeedURL     string       `json:"feed_url,omitempty"`
+	Description string       `json:"description,omitempty"`
+	Authors     []JSONAuthor `json:"authors,omitempty"`
+	Items       []JSONItem   `json:"items"`
+}
+
+type JSONAuthor struct {
+	Name string `json:"name,omitempty"`
+	URL  string `json:"url,omitempty"`
+}
+
+type JSONItem struct {
+	ID            string       `json:"id"`
+	URL           string       `json:"url,omitempty"`
+	Title         string       `json:"title,omitempty"`
+	ContentHTML   string       `json:"content_html"`
+	Summary       string       `json:"summary,omitempty"`
+	Image         string       `json:"image,omitempty"`
+	DatePublished string       `json:"date_published,omitempty"`
+	DateModified  string       `json:"date_modified,omitempty"`
+	Authors       []JSONAuthor `json:"authors,omitempty"`
+	Tags          []string     `json:"tags,omitempty"`
+}
+
+func newJSONFeed(f *gorillafeeds.Feed, feedURL string, items []JSONItem) *JSONFeed {
+	jf := &JSONFeed{
+		Version:     jsonFeedVersion,
+		Title:       f.Title,
+		FeedURL:     feedURL,
+		Description: f.Description,
+		Items:       items,
+	}
+	if f.Link != nil {
+		jf.HomePageURL = f.Link.Href
+	}
+	if f.Author != nil {
+		author := JSONAuthor{Name: f.Author.Name}
+		if f.Author.Email != "" {
+			author.URL = "mailto:" + f.Author.Email
+		}
+		jf.Authors = []JSONAuthor{author}
+	}
+	return jf
+}
+
+func formatJSONDate(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
+
+func jsonItemFromEntry(entry index.IndexEntry, baseURL string) JSONItem {
+	link := baseURL + entry.Path
+	item := JSONItem{
+		ID:            link,
+		URL:           link,
+		Title:         entry.Title,
+		Summary:       entry.Description,
+		DatePublished: formatJSONDate(entry.Date),
+		Tags:          entry.Tags,
+	}
+	if entry.Body != nil {
+		item.ContentHTML = string(*entry.Body)
+	}
+	if entry.ModTime.After(entry.Date) {
+		item.DateModified = formatJSONDate(entry.ModTime)
+	}
+	if entry.Author != "" && !entry.HideAuthor {
+		item.Authors = []JSONAuthor{{Name: entry.Author}}
+	}
+	if image, ok := entry.Metadata["image"].(string); ok && image != "" {
+		item.Image = absoluteURL(baseURL+"/", image)
+	}
+	return item
+}
+
+// absoluteURL resolves ref relative to base, JSON Feed requires absolute URLs
+func absoluteURL(base string, ref string) string {
+	b, err := url.Parse(base)
+	if err != nil {
+		return ref
+	}
+	r, err := url.Parse(ref)
+	if err != nil {
+		return ref
+	}
+	return b.ResolveReference(r).String()
+}
+
+func (jf *JSONFeed) Write(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	return enc.Encode(jf)
+}
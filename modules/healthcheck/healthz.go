@@ -1,6 +1,7 @@
 package healthcheck
 
 import (
+	"encoding/json"
 	"net/http"
 
 	"github.com/sokkalf/hubro/server"
@@ -21,4 +22,8 @@ func Register(prefix string, h *server.Hubro, mux *http.ServeMux, opts any) {
 		w.WriteHeader(http.StatusOK)
 		w.Write([]byte("OK"))
 	})
+	mux.HandleFunc("GET /cache", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(h.PageCacheStats())
+	})
 }
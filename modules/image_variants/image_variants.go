@@ -0,0 +1,49 @@
+package imagevariants
+
+import (
+	"errors"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/sokkalf/hubro/images"
+	"github.com/sokkalf/hubro/server"
+)
+
+// Register serves the variants of the images in the userfiles directory, at
+// /<width>/<format>/<name>, making them when they are first requested
+func Register(prefix string, h *server.Hubro, mux *http.ServeMux, options any) {
+	pipeline := options.(*images.Pipeline)
+
+	slog.Info("Registering image variants", "prefix", prefix)
+	mux.HandleFunc("GET /{width}/{format}/{name...}", func(w http.ResponseWriter, r *http.Request) {
+		width, err := strconv.Atoi(r.PathValue("width"))
+		if err != nil {
+			msg := "Image not found"
+			h.ErrorHandler(w, r, http.StatusNotFound, &msg)
+			return
+		}
+		name := r.PathValue("name")
+		file, err := pipeline.Variant(name, width, r.PathValue("format"))
+		if errors.Is(err, images.ErrNoVariant) || errors.Is(err, fs.ErrNotExist) {
+			msg := "Image not found"
+			h.ErrorHandler(w, r, http.StatusNotFound, &msg)
+			return
+		}
+		if err != nil {
+			slog.Error("Error generating image variant", "path", r.URL.Path, "error", err)
+			msg := "Error generating image"
+			h.ErrorHandler(w, r, http.StatusInternalServerError, &msg)
+			return
+		}
+		// Links to variants carry the version of the image, and can be cached
+		// until it changes. Others are checked by their modification time.
+		if pipeline.Current(name, r.URL.Query().Get("v")) {
+			w.Header().Set("Cache-Control", "public, max-age=31536000")
+		} else {
+			w.Header().Set("Cache-Control", "no-cache")
+		}
+		http.ServeFile(w, r, file)
+	})
+}
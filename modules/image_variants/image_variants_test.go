@@ -0,0 +1,68 @@
+package imagevariants
+
+import (
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/sokkalf/hubro/config"
+	"github.com/sokkalf/hubro/images"
+	"github.com/sokkalf/hubro/server"
+)
+
+// TestCacheControl checks that variants are only cached for good when they
+// are requested by the version of the image linked from pages.
+func TestCacheControl(t *testing.T) {
+	t.Setenv("HUBRO_CONFIG_FILE", "")
+	t.Setenv("HUBRO_BASE_URL", "http://localhost:8080/")
+	if err := config.Init(); err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	f, err := os.Create(filepath.Join(dir, "a.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	png.Encode(f, image.NewNRGBA(image.Rect(0, 0, 100, 50)))
+	f.Close()
+	pipeline := images.New(images.Options{Dir: dir, OriginalPath: "/userfiles/", CacheDir: t.TempDir(),
+		VariantPath: "/images/", Widths: []int{40}, Formats: []string{images.Original}, Quality: 80})
+
+	views := fstest.MapFS{
+		"app.gohtml":            {Data: []byte(`{{yield}}`)},
+		"errors/layout.gohtml":  {Data: []byte(`{{yield}}`)},
+		"errors/default.gohtml": {Data: []byte(`Error {{.Status}}`)},
+	}
+	h := server.NewHubro(server.Config{LayoutDir: views, TemplateDir: views, StaticDir: fstest.MapFS{}, VendorDir: fstest.MapFS{}})
+	h.AddModule("/images", Register, pipeline)
+
+	info, err := pipeline.Info("a.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	linked := strings.Fields(pipeline.Srcset("a.png", info, images.PNG))[0]
+	for _, c := range []struct {
+		url          string
+		status       int
+		cacheControl string
+	}{
+		{linked, http.StatusOK, "public, max-age=31536000"},
+		{"/images/40/png/a.png", http.StatusOK, "no-cache"},
+		{"/images/40/png/a.png?v=old", http.StatusOK, "no-cache"},
+		{"/images/80/png/a.png", http.StatusNotFound, ""},
+		{"/images/40/png/missing.png", http.StatusNotFound, ""},
+	} {
+		w := httptest.NewRecorder()
+		h.Mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, c.url, nil))
+		if w.Code != c.status || w.Header().Get("Cache-Control") != c.cacheControl {
+			t.Errorf("%s: expected %d with Cache-Control %q, got %d with %q", c.url, c.status, c.cacheControl,
+				w.Code, w.Header().Get("Cache-Control"))
+		}
+	}
+}
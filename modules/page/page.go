@@ -5,7 +5,6 @@ import (
 	"context"
 	"fmt"
 	"html/template"
-	"io/fs"
 	"log/slog"
 	"net/http"
 	"strings"
@@ -21,6 +20,8 @@ import (
 	"go.opentelemetry.io/otel/trace"
 
 	"github.com/sokkalf/hubro/config"
+	"github.com/sokkalf/hubro/content"
+	"github.com/sokkalf/hubro/images"
 	"github.com/sokkalf/hubro/index"
 	"github.com/sokkalf/hubro/server"
 	"github.com/sokkalf/hubro/utils"
@@ -30,10 +31,15 @@ import (
 type PageOptions struct {
 	Index *index.Index
 	Ctx   context.Context
+	// Images links images to their variants, if set
+	Images *images.Pipeline
 }
 type indexedPage struct {
 	path    string
 	modTime time.Time
+	// When the file was last committed, which changes without the file if
+	// the scan ran before the commit
+	committed time.Time
 }
 
 var md goldmark.Markdown
@@ -54,7 +60,7 @@ func getOrDefault[T any](m map[string]any, key string, defaultVal T) T {
 	return val
 }
 
-func parse(prefix string, md goldmark.Markdown, path string, opts PageOptions, isUpdate bool) error {
+func parse(prefix string, md goldmark.Markdown, path string, modTime time.Time, opts PageOptions, isUpdate bool) error {
 	var tags []string
 	var summary *template.HTML
 	var body *template.HTML
@@ -70,13 +76,14 @@ func parse(prefix string, md goldmark.Markdown, path string, opts PageOptions, i
 
 	start := time.Now()
 	name := strings.TrimSuffix(path, ".md")
-	content, err := fs.ReadFile(opts.Index.FilesDir, path)
+	source, err := opts.Index.Store.Read(path)
 	if err != nil {
 		slog.Error("Error reading page file", "page", path, "error", err)
 		return err
 	}
 	context := parser.NewContext()
-	if err := md.Convert(content, &buf, parser.WithContext(context)); err != nil {
+	images.SetPipeline(context, opts.Images)
+	if err := md.Convert(source, &buf, parser.WithContext(context)); err != nil {
 		slog.Error("Error converting markdown", "page", path, "error", err)
 		return err
 	}
@@ -110,6 +117,9 @@ func parse(prefix string, md goldmark.Markdown, path string, opts PageOptions, i
 		date = time.Time{}
 	}
 
+	// Entries dated in the future are kept hidden until the scheduler publishes them
+	scheduled := date.After(time.Now())
+
 	b := template.HTML(buf.String())
 	body = &b
 
@@ -123,27 +133,39 @@ func parse(prefix string, md goldmark.Markdown, path string, opts PageOptions, i
 	}
 	summary = &sum
 
+	// Without history, the front matter date is the best guess at when the entry was created
+	created, lastModified := date, modTime
+	if store, ok := opts.Index.Store.(content.VersionedStore); ok {
+		if c, m := store.Times(path); !c.IsZero() {
+			created, lastModified = c, m
+		}
+	}
+
 	slug := utils.Slugify(title)
 	handlerPath := "/" + slug
 	err = indexFunc(index.IndexEntry{
-		Id:          path,
-		Slug:        slug,
-		Title:       title,
-		ShortTitle:  shortTitle,
-		Description: description,
-		Author:      author,
-		Visible:     visible,
-		Metadata:    metaData,
-		Path:        handlerPath,
-		SortOrder:   sortOrder,
-		HideAuthor:  hideAuthor,
-		HideTitle:   hideTitle,
-		Tags:        tags,
-		Date:        date,
-		Summary:     summary,
-		Body:        body,
-		FileName:    path,
-		Draft:       draft,
+		Id:           path,
+		Slug:         slug,
+		Title:        title,
+		ShortTitle:   shortTitle,
+		Description:  description,
+		Author:       author,
+		Visible:      visible,
+		Metadata:     metaData,
+		Path:         handlerPath,
+		SortOrder:    sortOrder,
+		HideAuthor:   hideAuthor,
+		HideTitle:    hideTitle,
+		Tags:         tags,
+		Date:         date,
+		ModTime:      modTime,
+		Created:      created,
+		LastModified: lastModified,
+		Summary:      summary,
+		Body:         body,
+		FileName:     path,
+		Draft:        draft,
+		Scheduled:    scheduled,
 	})
 	if err != nil {
 		slog.Warn("Error adding page to index", "page", name, "error", err, "index", opts.Index.GetName())
@@ -157,7 +179,15 @@ func handler(h *server.Hubro, index *index.Index) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		slug := strings.TrimPrefix(r.URL.Path, "/")
 		entry := index.GetEntryBySlug(slug)
-		if entry != nil && !entry.Draft {
+		if entry != nil && !entry.Draft && !entry.Scheduled {
+			// The page also shows the navigation, tags and templates, which change with any update
+			lastModified := entry.ModTime
+			if h.LastUpdated().After(lastModified) {
+				lastModified = h.LastUpdated()
+			}
+			if h.NotModified(w, r, lastModified) {
+				return
+			}
 			h.Render(w, r, "page", entry)
 			return
 		} else {
@@ -168,38 +198,49 @@ func handler(h *server.Hubro, index *index.Index) http.HandlerFunc {
 	}
 }
 
+// lastCommitted returns when a file was last committed, if the store keeps history
+func lastCommitted(store content.ContentStore, path string) time.Time {
+	if vs, ok := store.(content.VersionedStore); ok {
+		_, modified := vs.Times(path)
+		return modified
+	}
+	return time.Time{}
+}
+
 func scanMarkdownFiles(ctx context.Context, prefix string, opts PageOptions) (filesScanned, numNew, numUpdated, numDeleted int) {
-	tr := config.Config.Tracer
+	tr := config.Get().Tracer
 	spanCtx, span := tr.Start(ctx, "Scanning markdown files")
 	defer span.End()
 	filesScannedList := make([]string, 0)
-	fs.WalkDir(opts.Index.FilesDir, ".", func(path string, d fs.DirEntry, err error) error {
+	files, err := opts.Index.Store.List()
+	if err != nil {
+		// Keep the pages indexed so far, rather than removing them all
+		slog.ErrorContext(spanCtx, "Error listing content files", "index", opts.Index.GetName(), "error", err)
+		return 0, 0, 0, 0
+	}
+	for _, file := range files {
+		path := file.Name
 		spanCtx, span := tr.Start(spanCtx, "Scanning file")
-		if !d.IsDir() && strings.HasSuffix(path, ".md") {
-			fi, err := d.Info()
-			if err != nil {
-				slog.ErrorContext(spanCtx, "Error getting file info", "file", path, "error", err)
-				return err
-			}
+		if strings.HasSuffix(path, ".md") {
 			indexedPagesMutex.Lock()
 			if indexedPages[opts.Index] == nil {
 				slog.DebugContext(spanCtx, "Initializing indexed pages", "index", opts.Index.GetName())
 				indexedPages[opts.Index] = make([]indexedPage, 0)
 			}
-			modTime := fi.ModTime()
+			modTime := file.ModTime
+			idxVal := indexedPage{path: path, modTime: modTime, committed: lastCommitted(opts.Index.Store, path)}
 			var alreadyIndexed bool = false
 			var isUpdate bool = false
 			for _, p := range indexedPages[opts.Index] {
-				if p.path == path && p.modTime == modTime {
+				if p == idxVal {
 					alreadyIndexed = true
-				} else if p.path == path && p.modTime != modTime {
+				} else if p.path == path {
 					isUpdate = true
 				}
 			}
-			idxVal := indexedPage{path: path, modTime: modTime}
 			indexedPagesMutex.Unlock()
 			if !alreadyIndexed {
-				err := parse(prefix, GetMarkdownParser(), path, opts, isUpdate)
+				err := parse(prefix, GetMarkdownParser(), path, modTime, opts, isUpdate)
 				if err != nil {
 					slog.ErrorContext(spanCtx, "Error parsing page", "page", path, "error", err)
 				} else {
@@ -229,8 +270,7 @@ func scanMarkdownFiles(ctx context.Context, prefix string, opts PageOptions) (fi
 			filesScannedList = append(filesScannedList, path)
 		}
 		span.End()
-		return nil
-	})
+	}
 	deletedFiles := make([]string, 0)
 	indexedPagesMutex.Lock()
 	for _, p := range indexedPages[opts.Index] {
@@ -265,7 +305,7 @@ func GetMarkdownParser() goldmark.Markdown {
 	mdMutex.Lock()
 	if md == nil {
 		md = goldmark.New(
-			goldmark.WithExtensions(extension.GFM, meta.Meta),
+			goldmark.WithExtensions(extension.GFM, meta.Meta, images.Markdown),
 			goldmark.WithParserOptions(parser.WithAutoHeadingID()),
 			goldmark.WithRendererOptions(html.WithUnsafe()),
 		)
@@ -287,6 +327,8 @@ func Register(prefix string, h *server.Hubro, mux *http.ServeMux, options any) {
 	mux.HandleFunc("/", handler(h, opts.Index))
 	slog.InfoContext(ctx, "Registered pages", "duration", time.Since(start))
 
+	go schedule(opts.Index)
+
 	go func() {
 		msgChan := opts.Index.MsgBroker.Subscribe()
 		for {
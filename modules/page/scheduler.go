@@ -0,0 +1,34 @@
+package page
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/sokkalf/hubro/index"
+)
+
+// schedule publishes entries dated in the future once their date has passed.
+// The next wake-up is recalculated whenever the index is updated, since entries
+// may have been added, rescheduled or removed.
+func schedule(idx *index.Index) {
+	msgChan := idx.MsgBroker.Subscribe()
+	timer := time.NewTimer(time.Hour)
+	timer.Stop()
+	for {
+		var timerChan <-chan time.Time
+		if next, ok := idx.NextScheduled(); ok {
+			slog.Debug("Next scheduled entry", "index", idx.GetName(), "date", next)
+			timer.Reset(time.Until(next))
+			timerChan = timer.C
+		}
+		select {
+		case <-timerChan:
+			if n := idx.PublishScheduled(time.Now()); n > 0 {
+				slog.Info("Published scheduled entries", "index", idx.GetName(), "count", n)
+				idx.MsgBroker.Publish(index.Updated)
+			}
+		case <-msgChan:
+		}
+		timer.Stop()
+	}
+}
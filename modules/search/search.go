@@ -0,0 +1,54 @@
+package search
+
+import (
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/sokkalf/hubro/fulltext"
+	"github.com/sokkalf/hubro/index"
+	"github.com/sokkalf/hubro/server"
+	"github.com/sokkalf/hubro/utils"
+)
+
+func handler(h *server.Hubro, engine *fulltext.Engine) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			msg := "Page not found"
+			h.ErrorHandler(w, r, http.StatusNotFound, &msg)
+			return
+		}
+		query := r.URL.Query().Get("q")
+		page := 1
+		if p := r.URL.Query().Get("p"); p != "" {
+			if parsedPage, err := strconv.Atoi(p); err == nil {
+				page = parsedPage
+			}
+		}
+
+		entries := utils.Map(func(res fulltext.Result) index.IndexEntry {
+			return res.Entry
+		}, engine.Search(fulltext.ParseQuery(query)))
+
+		h.Render(w, r, "search", struct {
+			Title       string
+			Description string
+			Query       string
+			Entries     []index.IndexEntry
+			Page        int
+		}{
+			Title:       "Search",
+			Description: "Search results for " + query,
+			Query:       query,
+			Entries:     entries,
+			Page:        page,
+		})
+	}
+}
+
+func Register(prefix string, h *server.Hubro, mux *http.ServeMux, options any) {
+	engine := options.(*fulltext.Engine)
+
+	mux.HandleFunc("GET /", handler(h, engine))
+	slog.Info("Registered search", "prefix", prefix, "documents", engine.Len())
+}
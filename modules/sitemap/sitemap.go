@@ -0,0 +1,176 @@
+package sitemap
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sokkalf/hubro/config"
+	"github.com/sokkalf/hubro/index"
+	"github.com/sokkalf/hubro/server"
+)
+
+// The sitemap protocol allows at most 50 000 URLs per file
+const maxURLsPerSitemap = 50000
+
+const xmlns = "http://www.sitemaps.org/schemas/sitemap/0.9"
+
+type urlEntry struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod,omitempty"`
+}
+
+type urlSet struct {
+	XMLName xml.Name   `xml:"urlset"`
+	Xmlns   string     `xml:"xmlns,attr"`
+	URLs    []urlEntry `xml:"url"`
+}
+
+type sitemapEntry struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod,omitempty"`
+}
+
+type sitemapIndex struct {
+	XMLName  xml.Name       `xml:"sitemapindex"`
+	Xmlns    string         `xml:"xmlns,attr"`
+	Sitemaps []sitemapEntry `xml:"sitemap"`
+}
+
+type Sitemap struct {
+	mtx   sync.RWMutex
+	root  []byte
+	parts [][]byte
+}
+
+func formatDate(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format("2006-01-02")
+}
+
+// lastModified prefers whichever is newer of the front matter date and the file modification time
+func lastModified(entry index.IndexEntry) time.Time {
+	if entry.ModTime.After(entry.Date) {
+		return entry.ModTime
+	}
+	return entry.Date
+}
+
+func origin(baseURL string) string {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return strings.TrimSuffix(baseURL, "/")
+	}
+	return u.Scheme + "://" + u.Host
+}
+
+func marshal(v any) []byte {
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	enc := xml.NewEncoder(&buf)
+	enc.Indent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		slog.Error("Error encoding sitemap", "error", err)
+	}
+	return buf.Bytes()
+}
+
+// generate rebuilds the sitemap from all indices of the site. If there are more URLs than
+// fit in one sitemap, sitemap.xml becomes a sitemap index pointing at the parts.
+func (s *Sitemap) generate(config *config.HubroConfig, indices index.Indices) {
+	start := time.Now()
+	base := origin(config.BaseURL)
+	rootPath := strings.TrimSuffix(config.RootPath, "/")
+
+	var newest time.Time
+	urls := []urlEntry{}
+	for _, name := range slices.Sorted(maps.Keys(indices)) {
+		for _, entry := range indices[name].GetEntries() {
+			if entry.Draft || entry.Scheduled || !entry.Visible {
+				continue
+			}
+			lastMod := lastModified(entry)
+			if lastMod.After(newest) {
+				newest = lastMod
+			}
+			urls = append(urls, urlEntry{Loc: base + entry.Path, LastMod: formatDate(lastMod)})
+		}
+	}
+	urls = append([]urlEntry{{Loc: base + rootPath + "/", LastMod: formatDate(newest)}}, urls...)
+
+	var root []byte
+	parts := [][]byte{}
+	if len(urls) <= maxURLsPerSitemap {
+		root = marshal(urlSet{Xmlns: xmlns, URLs: urls})
+	} else {
+		sitemaps := []sitemapEntry{}
+		for i := 0; i < len(urls); i += maxURLsPerSitemap {
+			parts = append(parts, marshal(urlSet{Xmlns: xmlns, URLs: urls[i:min(i+maxURLsPerSitemap, len(urls))]}))
+			sitemaps = append(sitemaps, sitemapEntry{
+				Loc:     fmt.Sprintf("%s%s/sitemaps/%d.xml", base, rootPath, len(parts)),
+				LastMod: formatDate(newest),
+			})
+		}
+		root = marshal(sitemapIndex{Xmlns: xmlns, Sitemaps: sitemaps})
+	}
+
+	s.mtx.Lock()
+	s.root = root
+	s.parts = parts
+	s.mtx.Unlock()
+	slog.Debug("Generated sitemap", "urls", len(urls), "parts", len(parts), "duration", time.Since(start))
+}
+
+func writeXML(w http.ResponseWriter, data []byte) {
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.Write(data)
+}
+
+func Register(prefix string, h *server.Hubro, mux *http.ServeMux, options any) {
+	start := time.Now()
+	indices := h.Indices()
+	s := &Sitemap{}
+	s.generate(h.Config(), indices)
+
+	for _, idx := range indices {
+		go func() {
+			msgChan := idx.MsgBroker.Subscribe()
+			for {
+				switch <-msgChan {
+				case index.Updated:
+					s.generate(h.Config(), indices)
+				default: // Ignore other messages
+				}
+			}
+		}()
+	}
+
+	mux.HandleFunc("GET /sitemap.xml", func(w http.ResponseWriter, r *http.Request) {
+		s.mtx.RLock()
+		defer s.mtx.RUnlock()
+		writeXML(w, s.root)
+	})
+	mux.HandleFunc("GET /sitemaps/{file}", func(w http.ResponseWriter, r *http.Request) {
+		n, err := strconv.Atoi(strings.TrimSuffix(r.PathValue("file"), ".xml"))
+		s.mtx.RLock()
+		defer s.mtx.RUnlock()
+		if err != nil || n < 1 || n > len(s.parts) {
+			msg := "Page not found"
+			h.ErrorHandler(w, r, http.StatusNotFound, &msg)
+			return
+		}
+		writeXML(w, s.parts[n-1])
+	})
+	slog.Info("Registered sitemap", "url", prefix+"/sitemap.xml", "duration", time.Since(start))
+}
@@ -0,0 +1,47 @@
+package sitemap
+
+import (
+	"encoding/xml"
+	"testing"
+	"time"
+
+	"github.com/sokkalf/hubro/config"
+	"github.com/sokkalf/hubro/index"
+)
+
+// TestSitemapListsPublishedEntries checks that drafts, scheduled and hidden
+// entries are left out, and that URLs are absolute on the base URL.
+func TestSitemapListsPublishedEntries(t *testing.T) {
+	date := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	indices := make(index.Indices)
+	blog := indices.NewIndex("blog", "/blog/blog")
+	for _, e := range []index.IndexEntry{
+		{Id: "published", Path: "/published", Date: date, Visible: true},
+		{Id: "draft", Path: "/draft", Date: date, Visible: true, Draft: true},
+		{Id: "scheduled", Path: "/scheduled", Date: date, Visible: true, Scheduled: true},
+		{Id: "hidden", Path: "/hidden", Date: date},
+	} {
+		if err := blog.AddEntry(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s := &Sitemap{}
+	s.generate(&config.HubroConfig{BaseURL: "https://example.org/blog/", RootPath: "/blog/"}, indices)
+	var set urlSet
+	if err := xml.Unmarshal(s.root, &set); err != nil {
+		t.Fatalf("invalid sitemap: %v", err)
+	}
+	want := []urlEntry{
+		{Loc: "https://example.org/blog/", LastMod: "2024-05-01"},
+		{Loc: "https://example.org/blog/blog/published", LastMod: "2024-05-01"},
+	}
+	if len(set.URLs) != len(want) {
+		t.Fatalf("expected %v, got %v", want, set.URLs)
+	}
+	for i := range want {
+		if set.URLs[i] != want[i] {
+			t.Errorf("expected %v, got %v", want[i], set.URLs[i])
+		}
+	}
+}
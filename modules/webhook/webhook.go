@@ -0,0 +1,233 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sokkalf/hubro/content"
+	"github.com/sokkalf/hubro/index"
+	"github.com/sokkalf/hubro/server"
+)
+
+// Push payloads can be large when many commits are pushed at once
+const maxPayloadSize = 5 << 20
+
+// The number of results kept for the admin interface
+const historySize = 20
+
+// Result describes one delivery of the webhook
+type Result struct {
+	Time       time.Time
+	Delivery   string
+	Event      string
+	Repository string
+	From       string
+	To         string
+	Error      string
+	Duration   time.Duration
+}
+
+// Updated reports whether the delivery brought in new commits
+func (r Result) Updated() bool {
+	return r.Error == "" && r.From != r.To
+}
+
+// repository is a git repository holding the content of one or more indices
+type repository struct {
+	store   *content.GitStore
+	indices []*index.Index
+	// Deliveries are handled one at a time, a pull is already in progress otherwise
+	mtx sync.Mutex
+}
+
+type webhook struct {
+	h            *server.Hubro
+	repositories []*repository
+	results      []Result
+	resultsMutex sync.RWMutex
+}
+
+var (
+	hooks      = map[*server.Hubro]*webhook{}
+	hooksMutex sync.RWMutex
+)
+
+// Enabled reports whether the webhook of a site is registered. The module is
+// added whenever a secret is set, but only registers if content is in git.
+func Enabled(h *server.Hubro) bool {
+	hooksMutex.RLock()
+	defer hooksMutex.RUnlock()
+	_, ok := hooks[h]
+	return ok
+}
+
+// Results returns the latest deliveries of the webhook of a site, newest
+// first, or nil if the webhook is not enabled
+func Results(h *server.Hubro) []Result {
+	hooksMutex.RLock()
+	wh, ok := hooks[h]
+	hooksMutex.RUnlock()
+	if !ok {
+		return nil
+	}
+	wh.resultsMutex.RLock()
+	defer wh.resultsMutex.RUnlock()
+	return slices.Clone(wh.results)
+}
+
+func (wh *webhook) record(result Result) {
+	wh.resultsMutex.Lock()
+	defer wh.resultsMutex.Unlock()
+	wh.results = append([]Result{result}, wh.results...)
+	if len(wh.results) > historySize {
+		wh.results = wh.results[:historySize]
+	}
+}
+
+// validSignature checks the HMAC-SHA256 signature of the payload, sent as
+// X-Hub-Signature-256 by GitHub, and as X-Gitea-Signature by Gitea and Forgejo
+func validSignature(r *http.Request, payload []byte, secret string) bool {
+	signature, ok := strings.CutPrefix(r.Header.Get("X-Hub-Signature-256"), "sha256=")
+	if !ok {
+		signature = r.Header.Get("X-Gitea-Signature")
+	}
+	got, err := hex.DecodeString(signature)
+	if err != nil || len(got) == 0 {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return hmac.Equal(got, mac.Sum(nil))
+}
+
+// header returns the first of the GitHub or Gitea variant of a header
+func header(r *http.Request, name string) string {
+	if value := r.Header.Get("X-GitHub-" + name); value != "" {
+		return value
+	}
+	return r.Header.Get("X-Gitea-" + name)
+}
+
+func (wh *webhook) handler(w http.ResponseWriter, r *http.Request) {
+	secret := wh.h.Config().WebhookSecret
+	if secret == "" {
+		http.Error(w, "Webhook disabled", http.StatusForbidden)
+		return
+	}
+	payload, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxPayloadSize))
+	if err != nil {
+		http.Error(w, "Payload too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	if !validSignature(r, payload, secret) {
+		slog.Warn("Webhook delivery with invalid signature", "remoteAddr", r.RemoteAddr)
+		http.Error(w, "Invalid signature", http.StatusUnauthorized)
+		return
+	}
+
+	event := header(r, "Event")
+	switch event {
+	case "ping":
+		w.Write([]byte("pong"))
+		return
+	case "push":
+	default:
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	var push struct {
+		Ref string `json:"ref"`
+	}
+	if err := json.Unmarshal(payload, &push); err != nil {
+		http.Error(w, "Invalid payload", http.StatusBadRequest)
+		return
+	}
+
+	delivery := header(r, "Delivery")
+	pulling := 0
+	for _, repo := range wh.repositories {
+		// Pushes to other branches don't change the content
+		if branch, err := repo.store.Branch(); err == nil && push.Ref != "" && push.Ref != branch {
+			slog.Debug("Ignoring push to another branch", "ref", push.Ref, "branch", branch)
+			continue
+		}
+		pulling++
+		go wh.pull(repo, event, delivery)
+	}
+	if pulling == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	// Pulling may take longer than the sender waits for a response
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// pull fast-forwards a repository, and rescans its indices if there were new commits
+func (wh *webhook) pull(repo *repository, event string, delivery string) {
+	repo.mtx.Lock()
+	defer repo.mtx.Unlock()
+	start := time.Now()
+	from, to, err := repo.store.Pull()
+	result := Result{
+		Time:       start,
+		Delivery:   delivery,
+		Event:      event,
+		Repository: repo.store.Root(),
+		From:       from,
+		To:         to,
+		Duration:   time.Since(start),
+	}
+	if err != nil {
+		result.Error = err.Error()
+		slog.Error("Error pulling content repository", "repository", result.Repository, "delivery", delivery, "error", err)
+	} else if result.Updated() {
+		slog.Info("Pulled content repository", "repository", result.Repository, "delivery", delivery,
+			"from", from, "to", to, "duration", result.Duration)
+		for _, idx := range repo.indices {
+			idx.MsgBroker.Publish(index.Scanned)
+		}
+	} else {
+		slog.Info("Content repository already up to date", "repository", result.Repository, "delivery", delivery)
+	}
+	wh.record(result)
+}
+
+// Register handles pushes at prefix + "/content". options is the indices of
+// the site, those kept in git are updated.
+func Register(prefix string, h *server.Hubro, mux *http.ServeMux, options any) {
+	indices := options.([]*index.Index)
+	wh := &webhook{h: h}
+	byRoot := map[string]*repository{}
+	for _, idx := range indices {
+		store, ok := idx.Store.(*content.GitStore)
+		if !ok {
+			continue
+		}
+		repo, ok := byRoot[store.Root()]
+		if !ok {
+			repo = &repository{store: store}
+			byRoot[store.Root()] = repo
+			wh.repositories = append(wh.repositories, repo)
+		}
+		repo.indices = append(repo.indices, idx)
+	}
+	if len(wh.repositories) == 0 {
+		slog.Warn("No content is kept in git, not registering the content webhook")
+		return
+	}
+	hooksMutex.Lock()
+	hooks[h] = wh
+	hooksMutex.Unlock()
+
+	mux.HandleFunc("POST /content", wh.handler)
+	slog.Info("Registered content webhook", "url", prefix+"/content", "repositories", len(wh.repositories))
+}
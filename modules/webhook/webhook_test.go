@@ -0,0 +1,157 @@
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/sokkalf/hubro/config"
+	"github.com/sokkalf/hubro/content"
+	"github.com/sokkalf/hubro/index"
+	"github.com/sokkalf/hubro/server"
+)
+
+const secret = "s3cret"
+
+// testWebhook returns a site with the webhook registered for a clone of a
+// repository, and the store of the repository it pulls from
+func testWebhook(t *testing.T) (*server.Hubro, *content.GitStore) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	t.Setenv("HUBRO_CONFIG_FILE", "")
+	t.Setenv("HUBRO_BASE_URL", "http://localhost:8080/")
+	if err := config.Init(); err != nil {
+		t.Fatal(err)
+	}
+	config.Update(func(c *config.HubroConfig) { c.WebhookSecret = secret })
+
+	author := content.Author{Name: "Hubro", Email: "hubro@example.org"}
+	upstream := t.TempDir()
+	if out, err := exec.Command("git", "init", "--quiet", upstream).CombinedOutput(); err != nil {
+		t.Fatalf("git init: %v: %s", err, out)
+	}
+	os.Mkdir(filepath.Join(upstream, "blog"), 0755)
+	upstreamStore, err := content.NewGitStore(filepath.Join(upstream, "blog"), author)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := upstreamStore.Write("post.md", []byte("first\n")); err != nil {
+		t.Fatal(err)
+	}
+	clone := filepath.Join(t.TempDir(), "clone")
+	if out, err := exec.Command("git", "clone", "--quiet", upstream, clone).CombinedOutput(); err != nil {
+		t.Fatalf("git clone: %v: %s", err, out)
+	}
+	store, err := content.NewGitStore(filepath.Join(clone, "blog"), author)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	views := fstest.MapFS{
+		"app.gohtml":            {Data: []byte(`{{yield}}`)},
+		"errors/layout.gohtml":  {Data: []byte(`{{yield}}`)},
+		"errors/default.gohtml": {Data: []byte(`Error {{.Status}}`)},
+	}
+	h := server.NewHubro(server.Config{LayoutDir: views, TemplateDir: views, StaticDir: fstest.MapFS{}, VendorDir: fstest.MapFS{}})
+	idx := h.Indices().NewIndex("blog", "/blog")
+	idx.Store = store
+	h.AddModule("/hooks", Register, []*index.Index{idx})
+	return h, upstreamStore
+}
+
+func sign(payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func deliver(h *server.Hubro, payload []byte, headers map[string]string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, "/hooks/content", bytes.NewReader(payload))
+	for k, v := range headers {
+		r.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	h.Mux.ServeHTTP(w, r)
+	return w
+}
+
+// TestWebhookSignatures checks that deliveries are only accepted with a valid
+// GitHub or Gitea signature.
+func TestWebhookSignatures(t *testing.T) {
+	h, _ := testWebhook(t)
+	if !Enabled(h) {
+		t.Fatal("expected the webhook to be registered")
+	}
+	payload := []byte(`{"zen": "Keep it logically awesome."}`)
+	for _, c := range []struct {
+		headers map[string]string
+		status  int
+	}{
+		{map[string]string{"X-Hub-Signature-256": "sha256=" + sign(payload), "X-GitHub-Event": "ping"}, http.StatusOK},
+		{map[string]string{"X-Hub-Signature-256": "sha256=" + sign([]byte("other")), "X-GitHub-Event": "ping"}, http.StatusUnauthorized},
+		{map[string]string{"X-Hub-Signature-256": sign(payload), "X-GitHub-Event": "ping"}, http.StatusUnauthorized},
+		{map[string]string{"X-Gitea-Signature": sign(payload), "X-Gitea-Event": "ping"}, http.StatusOK},
+		{map[string]string{"X-Gitea-Signature": "not hex", "X-Gitea-Event": "ping"}, http.StatusUnauthorized},
+		{map[string]string{"X-Gitea-Signature": sign([]byte("other")), "X-Gitea-Event": "ping"}, http.StatusUnauthorized},
+		{map[string]string{"X-GitHub-Event": "ping"}, http.StatusUnauthorized},
+	} {
+		w := deliver(h, payload, c.headers)
+		if w.Code != c.status {
+			t.Errorf("%v: expected %d, got %d", c.headers, c.status, w.Code)
+		}
+		if w.Code == http.StatusOK && w.Body.String() != "pong" {
+			t.Errorf("expected pong for a ping, got %q", w.Body.String())
+		}
+	}
+
+	large := bytes.Repeat([]byte("x"), maxPayloadSize+1)
+	if w := deliver(h, large, map[string]string{"X-Hub-Signature-256": "sha256=" + sign(large)}); w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected 413 for a payload over 5 MB, got %d", w.Code)
+	}
+}
+
+// TestWebhookPush checks that a push to the checked out branch is pulled, and
+// a push to another branch is not.
+func TestWebhookPush(t *testing.T) {
+	h, upstream := testWebhook(t)
+	if err := upstream.Write("post.md", []byte("second\n")); err != nil {
+		t.Fatal(err)
+	}
+	push := func(ref string) *httptest.ResponseRecorder {
+		payload := []byte(`{"ref": "` + ref + `"}`)
+		return deliver(h, payload, map[string]string{"X-Hub-Signature-256": "sha256=" + sign(payload),
+			"X-GitHub-Event": "push", "X-GitHub-Delivery": ref})
+	}
+
+	if w := push("refs/heads/some-feature"); w.Code != http.StatusNoContent {
+		t.Errorf("expected 204 for a push to another branch, got %d", w.Code)
+	}
+	if results := Results(h); len(results) != 0 {
+		t.Errorf("expected no pull for a push to another branch, got %+v", results)
+	}
+	branch, err := upstream.Branch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w := push(branch); w.Code != http.StatusAccepted {
+		t.Fatalf("expected 202 for a push to %s, got %d", branch, w.Code)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for len(Results(h)) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	results := Results(h)
+	if len(results) != 1 || results[0].Delivery != branch || !results[0].Updated() {
+		t.Fatalf("expected one pull of %s, got %+v", branch, results)
+	}
+}
@@ -0,0 +1,121 @@
+package server
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sokkalf/hubro/index"
+)
+
+// validators are used to answer conditional requests without rendering anything.
+// The generation is bumped whenever an index publishes index.Updated, which
+// invalidates every ETag handed out before, and empties the page cache.
+type validators struct {
+	watchOnce   sync.Once
+	startTime   time.Time
+	generation  atomic.Uint64
+	lastUpdated atomic.Int64
+}
+
+func (v *validators) init() {
+	v.startTime = time.Now()
+	v.lastUpdated.Store(v.startTime.Unix())
+}
+
+// watchIndices subscribes to all indices of the site, and invalidates
+// validators and caches when any of them is updated
+func (h *Hubro) watchIndices() {
+	h.validators.watchOnce.Do(func() {
+		for _, idx := range h.indices {
+			go func() {
+				msgChan := idx.MsgBroker.Subscribe()
+				for {
+					switch <-msgChan {
+					case index.Updated:
+						slog.Debug("Invalidating validators", "index", idx.GetName())
+						h.invalidate()
+					default: // Ignore other messages
+					}
+				}
+			}()
+		}
+	})
+}
+
+func (h *Hubro) invalidate() {
+	h.validators.generation.Add(1)
+	h.validators.lastUpdated.Store(time.Now().Unix())
+	if h.pageCache != nil {
+		slog.Debug("Resetting page cache", "stats", h.pageCache.stats())
+		h.pageCache.reset()
+	}
+}
+
+// LastUpdated returns the time any index was last updated, or the start time
+func (h *Hubro) LastUpdated() time.Time {
+	return time.Unix(h.validators.lastUpdated.Load(), 0)
+}
+
+func (h *Hubro) etag(r *http.Request, lastModified time.Time) string {
+	boosted := ""
+	if r.Header.Get("HX-Boosted") == "true" {
+		boosted = "-b"
+	}
+	return fmt.Sprintf(`W/"%x-%x-%x%s"`, h.validators.startTime.UnixNano(),
+		h.validators.generation.Load(), lastModified.Unix(), boosted)
+}
+
+// NotModified sets the ETag and Last-Modified headers for a response, and
+// answers with 304 Not Modified if the client already has the current version.
+// lastModified is the modification time of the content being served, if it is
+// zero the time of the last index update is used. Handlers should return
+// without writing anything if NotModified returns true.
+func (h *Hubro) NotModified(w http.ResponseWriter, r *http.Request, lastModified time.Time) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	if lastModified.IsZero() {
+		lastModified = h.LastUpdated()
+	}
+	lastModified = lastModified.Truncate(time.Second)
+	etag := h.etag(r, lastModified)
+
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+	// Boosted requests get the page without the layout
+	w.Header().Add("Vary", "HX-Boosted")
+	// Always revalidate, the content changes whenever the files do
+	w.Header().Set("Cache-Control", "no-cache")
+
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		if !etagMatches(inm, etag) {
+			return false
+		}
+	} else if ims := r.Header.Get("If-Modified-Since"); ims != "" {
+		t, err := http.ParseTime(ims)
+		if err != nil || lastModified.After(t) {
+			return false
+		}
+	} else {
+		return false
+	}
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// etagMatches does a weak comparison of etag against an If-None-Match header
+func etagMatches(header string, etag string) bool {
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
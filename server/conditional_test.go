@@ -0,0 +1,90 @@
+package server
+
+import (
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	hc "github.com/sokkalf/hubro/config"
+)
+
+var testViews = fstest.MapFS{
+	"app.gohtml":            {Data: []byte(`{{yield}}`)},
+	"errors/layout.gohtml":  {Data: []byte(`{{yield}}`)},
+	"errors/default.gohtml": {Data: []byte(`Error {{.Status}}`)},
+}
+
+func testHubro(t *testing.T, views fs.FS, watchDirs ...string) *Hubro {
+	t.Setenv("HUBRO_CONFIG_FILE", "")
+	t.Setenv("HUBRO_BASE_URL", "http://localhost:8080/")
+	if err := hc.Init(); err != nil {
+		t.Fatal(err)
+	}
+	static := fstest.MapFS{"app.css": {}, "app.js": {}}
+	h := NewHubro(Config{LayoutDir: views, TemplateDir: views, StaticDir: static, VendorDir: fstest.MapFS{},
+		WatchDirs: watchDirs})
+	t.Cleanup(h.cancel)
+	return h
+}
+
+// conditionalGet calls NotModified for a request with the given headers
+func conditionalGet(h *Hubro, lastModified time.Time, headers map[string]string) (*httptest.ResponseRecorder, bool) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	for k, v := range headers {
+		r.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	return w, h.NotModified(w, r, lastModified)
+}
+
+// TestNotModified checks the validators set on responses, and which
+// conditional requests are answered with 304 Not Modified.
+func TestNotModified(t *testing.T) {
+	h := testHubro(t, testViews)
+	modTime := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	w, notModified := conditionalGet(h, modTime, nil)
+	etag := w.Header().Get("ETag")
+	if notModified || !strings.HasPrefix(etag, `W/"`) || w.Header().Get("Last-Modified") != "Wed, 01 May 2024 12:00:00 GMT" ||
+		w.Header().Get("Vary") != "HX-Boosted" {
+		t.Fatalf("unexpected headers for an unconditional request: %v", w.Header())
+	}
+	w, _ = conditionalGet(h, modTime, map[string]string{"HX-Boosted": "true"})
+	boostedETag := w.Header().Get("ETag")
+	if boostedETag == etag {
+		t.Errorf("expected boosted and unboosted responses to have different ETags, got %s", etag)
+	}
+
+	for _, c := range []struct {
+		headers map[string]string
+		want    bool
+	}{
+		{map[string]string{"If-None-Match": etag}, true},
+		{map[string]string{"If-None-Match": strings.TrimPrefix(etag, "W/")}, true},
+		{map[string]string{"If-None-Match": `"other", ` + etag}, true},
+		{map[string]string{"If-None-Match": `W/"other",W/"another"`}, false},
+		{map[string]string{"If-None-Match": "*"}, true},
+		{map[string]string{"If-None-Match": etag, "HX-Boosted": "true"}, false},
+		{map[string]string{"If-None-Match": boostedETag, "HX-Boosted": "true"}, true},
+		{map[string]string{"If-None-Match": boostedETag}, false},
+		{map[string]string{"If-Modified-Since": "Wed, 01 May 2024 12:00:00 GMT"}, true},
+		{map[string]string{"If-Modified-Since": "Thu, 02 May 2024 12:00:00 GMT"}, true},
+		{map[string]string{"If-Modified-Since": "Wed, 01 May 2024 11:59:59 GMT"}, false},
+		{map[string]string{"If-Modified-Since": "yesterday"}, false},
+		// If-None-Match takes precedence
+		{map[string]string{"If-None-Match": `"other"`, "If-Modified-Since": "Thu, 02 May 2024 12:00:00 GMT"}, false},
+	} {
+		w, notModified := conditionalGet(h, modTime, c.headers)
+		if notModified != c.want || (w.Code == http.StatusNotModified) != c.want {
+			t.Errorf("%v: expected not modified %v, got %v with status %d", c.headers, c.want, notModified, w.Code)
+		}
+	}
+
+	h.invalidate()
+	if _, notModified := conditionalGet(h, modTime, map[string]string{"If-None-Match": etag}); notModified {
+		t.Errorf("expected an update to invalidate the ETag")
+	}
+}
@@ -0,0 +1,20 @@
+package server
+
+import (
+	"context"
+	"net/http"
+)
+
+type csrfKey struct{}
+
+// WithCSRFToken returns r carrying the token that forms rendered for it must
+// post back, available to templates as csrfToken
+func WithCSRFToken(r *http.Request, token string) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), csrfKey{}, token))
+}
+
+// CSRFToken returns the token added by WithCSRFToken, or ""
+func CSRFToken(r *http.Request) string {
+	token, _ := r.Context().Value(csrfKey{}).(string)
+	return token
+}
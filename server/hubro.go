@@ -2,31 +2,43 @@ package server
 
 import (
 	"bytes"
+	"context"
 	"fmt"
+	"hash/fnv"
 	"html/template"
 	"io/fs"
 	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
 	"slices"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	hc "github.com/sokkalf/hubro/config"
 	"github.com/sokkalf/hubro/helpers"
 	"github.com/sokkalf/hubro/index"
 	"github.com/sokkalf/hubro/utils"
+	"github.com/sokkalf/hubro/utils/watchfs"
 )
 
 type Config struct {
+	// Site is the name of the site served, empty for the main configuration
+	Site        string
 	RootPath    string
 	Port        int
 	VendorDir   fs.FS
 	TemplateDir fs.FS
 	LayoutDir   fs.FS
 	PublicDir   fs.FS
+	StaticDir   fs.FS
+	// WatchDirs are the directories on disk backing TemplateDir and
+	// LayoutDir. They are watched for changes, and the templates reloaded.
+	WatchDirs []string
 }
 
 type Middleware func(*Hubro) func(http.Handler) http.Handler
@@ -34,10 +46,33 @@ type Middleware func(*Hubro) func(http.Handler) http.Handler
 type Hubro struct {
 	Mux         *http.ServeMux
 	Server      *http.Server
-	Templates   *template.Template
-	config      hc.HubroConfig
+	templates   atomic.Pointer[template.Template]
+	funcMap     template.FuncMap
+	layoutDir   fs.FS
+	templateDir fs.FS
 	middlewares []Middleware
 	publicDir   fs.FS
+	validators  validators
+	pageCache   *pageCache
+
+	site    string
+	indices index.Indices
+	modules sync.Map // prefixes of the registered modules
+
+	// The virtual hosts served by this instance, by host name, and the
+	// instance serving this one as a virtual host
+	virtualHosts map[string]*Hubro
+	primary      *Hubro
+
+	redirectServer *http.Server
+
+	ctx              context.Context
+	cancel           context.CancelFunc
+	connections      sync.WaitGroup
+	connectionsMutex sync.Mutex
+	closing          bool // no connections are tracked once shutdown has started
+	shutdownHooks    []func()
+	shutdownMutex    sync.Mutex
 }
 
 type HubroModule func(string, *Hubro, *http.ServeMux, any)
@@ -59,7 +94,8 @@ var VendorLibs map[string]string = map[string]string{
 	"highlight.js": "/vendor/highlight/highlight.min.js",
 }
 
-var addTemplateMutex sync.Mutex
+// Templates that must exist for the server to be able to render anything
+var requiredTemplates = []string{rootLayout, errorLayout, defaultErrorTemplate}
 
 func (h *Hubro) Use(m Middleware) {
 	h.middlewares = append(h.middlewares, m)
@@ -69,6 +105,12 @@ func (h *Hubro) AddModule(prefix string, module HubroModule, options any) {
 	h.createSubMux(prefix, module, options)
 }
 
+// HasModule reports whether a module has been registered at prefix
+func (h *Hubro) HasModule(prefix string) bool {
+	_, ok := h.modules.Load(prefix)
+	return ok
+}
+
 func (h *Hubro) handlerWithMiddlewares(handler http.Handler) http.Handler {
 	for _, m := range h.middlewares {
 		handler = m(h)(handler)
@@ -78,7 +120,12 @@ func (h *Hubro) handlerWithMiddlewares(handler http.Handler) http.Handler {
 
 // GetHandler returns the http.Handler for the Hubro instance with all middlewares applied.
 func (h *Hubro) GetHandler() http.Handler {
-	return h.handlerWithMiddlewares(h.Mux)
+	h.watchIndices()
+	handler := h.handlerWithMiddlewares(h.withPageCacheGeneration(h.Mux))
+	if len(h.virtualHosts) == 0 {
+		return handler
+	}
+	return h.virtualHostHandler(handler)
 }
 
 func (h *Hubro) createSubMux(prefix string, module HubroModule, options any) *http.ServeMux {
@@ -90,35 +137,49 @@ func (h *Hubro) createSubMux(prefix string, module HubroModule, options any) *ht
 		mux = http.NewServeMux()
 		h.Mux.Handle(prefix+"/", http.StripPrefix(prefix, mux))
 	}
+	h.modules.Store(prefix, true)
 	module(prefix, h, mux, options)
 	return mux
 }
 
-func (h *Hubro) initTemplates(layoutDir fs.FS, templateDir fs.FS, modTimeCSS int64, modTimeJS int64) {
-	defaultFuncMap := template.FuncMap{
+// assetVersion returns a hash of the contents of a static file, used for cache busting.
+// Embedded files have no modification time, so it can't be used instead.
+func assetVersion(staticDir fs.FS, name string) string {
+	content, err := fs.ReadFile(staticDir, name)
+	if err != nil {
+		slog.Warn("Static asset not found", "filename", name, "error", err)
+		return ""
+	}
+	hash := fnv.New32a()
+	hash.Write(content)
+	return strconv.FormatUint(uint64(hash.Sum32()), 36)
+}
+
+func (h *Hubro) initTemplates(layoutDir fs.FS, templateDir fs.FS, versionCSS string, versionJS string) {
+	h.funcMap = template.FuncMap{
 		"appTitle": func() string {
-			return h.config.Title
+			return h.Config().Title
 		},
 		"rootPath": func() string {
-			return strings.TrimSuffix(h.config.RootPath, "/")
+			return strings.TrimSuffix(h.Config().RootPath, "/")
 		},
 		"baseURL": func() string {
-			return h.config.BaseURL
+			return h.Config().BaseURL
 		},
 		"staticPath": func(path string) string {
-			return strings.TrimSuffix(h.config.RootPath, "/") + "/static/" + path
+			return strings.TrimSuffix(h.Config().RootPath, "/") + "/static/" + path
 		},
 		"vendorPath": func(path string) string {
-			return strings.TrimSuffix(h.config.RootPath, "/") + "/vendor/" + path
+			return strings.TrimSuffix(h.Config().RootPath, "/") + "/vendor/" + path
 		},
 		"appCSS": func() string {
-			return fmt.Sprintf("%s/static/app.css?v=%d", strings.TrimSuffix(h.config.RootPath, "/"), modTimeCSS)
+			return fmt.Sprintf("%s/static/app.css?v=%s", strings.TrimSuffix(h.Config().RootPath, "/"), versionCSS)
 		},
 		"appJS": func() string {
-			return fmt.Sprintf("%s/static/app.js?v=%d", strings.TrimSuffix(h.config.RootPath, "/"), modTimeJS)
+			return fmt.Sprintf("%s/static/app.js?v=%s", strings.TrimSuffix(h.Config().RootPath, "/"), versionJS)
 		},
 		"vendor": func(path string) string {
-			return strings.TrimSuffix(h.config.RootPath, "/") + VendorLibs[path]
+			return strings.TrimSuffix(h.Config().RootPath, "/") + VendorLibs[path]
 		},
 		"yield": func() (string, error) {
 			// overwritten when rendering with layout
@@ -128,29 +189,26 @@ func (h *Hubro) initTemplates(layoutDir fs.FS, templateDir fs.FS, modTimeCSS int
 		"boosted": func() bool {
 			return false
 		},
+		"csrfToken": func() string {
+			// overwritten when rendering with layout
+			return ""
+		},
 		"format_date": func(date time.Time) string {
 			return date.Format("2006-01-02")
 		},
 		"listPages": func(i string, filterTag string) []index.IndexEntry {
-			idx := index.GetIndex(i)
+			idx := h.indices.GetIndex(i)
 			if idx == nil {
 				return []index.IndexEntry{}
 			} else {
-				if filterTag == "" {
-					return idx.GetEntries()
-				} else {
-					var filteredEntries []index.IndexEntry
-
-					filteredEntries = utils.Filter(func(entry index.IndexEntry) bool {
-						return slices.Contains(entry.Tags, filterTag)
-					}, idx.GetEntries())
-
-					return filteredEntries
-				}
+				// Scheduled entries are not listed until they are published
+				return utils.Filter(func(entry index.IndexEntry) bool {
+					return !entry.Scheduled && (filterTag == "" || slices.Contains(entry.Tags, filterTag))
+				}, idx.GetEntries())
 			}
 		},
 		"paginate": func(page int, entries []index.IndexEntry) []index.IndexEntry {
-			perPage := hc.Config.PostsPerPage
+			perPage := h.Config().PostsPerPage
 			start := (page - 1) * perPage
 			end := start + perPage
 			if start > len(entries) {
@@ -166,11 +224,13 @@ func (h *Hubro) initTemplates(layoutDir fs.FS, templateDir fs.FS, modTimeCSS int
 			return template.HTML("")
 		},
 		"getConfig": func() hc.HubroConfig {
-			return h.config
+			return *h.Config()
 		},
 		"tagCloud": func(i string) template.HTML {
-			entries := index.GetIndex(i)
-			return helpers.GenerateTagCloud(entries)
+			return helpers.GenerateTagCloud(h.indices.GetIndex(i), h.Config().RootPath)
+		},
+		"feedsEnabled": func() bool {
+			return h.HasModule("/feeds")
 		},
 		"add": func(a, b int) int {
 			return a + b
@@ -182,50 +242,74 @@ func (h *Hubro) initTemplates(layoutDir fs.FS, templateDir fs.FS, modTimeCSS int
 			return *t1 == *t2
 		},
 		"logoImage": func() template.HTML {
-			return helpers.GetLogoImage()
+			return helpers.GetLogoImage(h.Config())
 		},
 	}
 
-	h.Templates = template.New("root")
-	fs.WalkDir(layoutDir, ".", func(path string, d fs.DirEntry, err error) error {
-		if !d.IsDir() && strings.HasSuffix(path, ".gohtml") {
-			go func() {
-				start := time.Now()
-				name := strings.TrimPrefix(path, "layouts/")
-				name = strings.TrimSuffix(name, ".gohtml")
-				content, err := fs.ReadFile(layoutDir, path)
-				if err != nil {
-					slog.Error("Error reading layout file", "layout", path, "error", err)
-					panic(err)
-				}
-				addTemplateMutex.Lock()
-				h.Templates = template.Must(h.Templates.New(name).Funcs(defaultFuncMap).Parse(string(content)))
-				slog.Debug("Parsed layout", "layout", h.Templates.Name(), "duration", time.Since(start))
-				addTemplateMutex.Unlock()
-			}()
-		}
-		return nil
-	})
+	h.layoutDir = layoutDir
+	h.templateDir = templateDir
+	templates, err := parseTemplates(layoutDir, templateDir, h.funcMap)
+	if err != nil {
+		slog.Error("Error parsing templates", "error", err)
+		panic(err)
+	}
+	h.templates.Store(templates)
+}
 
-	fs.WalkDir(templateDir, ".", func(path string, d fs.DirEntry, err error) error {
-		if !d.IsDir() && strings.HasSuffix(path, ".gohtml") {
-			go func() {
-				start := time.Now()
-				name := strings.TrimPrefix(path, "templates/")
-				name = strings.TrimSuffix(name, ".gohtml")
-				content, err := fs.ReadFile(templateDir, path)
-				if err != nil {
-					slog.Error("Error reading template file", "template", path, "error", err)
-					panic(err)
-				}
-				addTemplateMutex.Lock()
-				h.Templates = template.Must(h.Templates.New(name).Funcs(defaultFuncMap).Parse(string(content)))
-				slog.Debug("Parsed template", "template", h.Templates.Name(), "duration", time.Since(start))
-				addTemplateMutex.Unlock()
-			}()
+// parseTemplates parses all layouts and templates into a new template set,
+// and checks that the templates needed for rendering pages and errors exist.
+func parseTemplates(layoutDir fs.FS, templateDir fs.FS, funcMap template.FuncMap) (*template.Template, error) {
+	start := time.Now()
+	templates := template.New("root").Funcs(funcMap)
+	parseDir := func(dir fs.FS) error {
+		return fs.WalkDir(dir, ".", func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() || !strings.HasSuffix(path, ".gohtml") {
+				return nil
+			}
+			content, err := fs.ReadFile(dir, path)
+			if err != nil {
+				return fmt.Errorf("reading %s: %w", path, err)
+			}
+			if _, err := templates.New(strings.TrimSuffix(path, ".gohtml")).Parse(string(content)); err != nil {
+				return err
+			}
+			return nil
+		})
+	}
+	if err := parseDir(layoutDir); err != nil {
+		return nil, err
+	}
+	if err := parseDir(templateDir); err != nil {
+		return nil, err
+	}
+	for _, name := range requiredTemplates {
+		if templates.Lookup(name) == nil {
+			return nil, fmt.Errorf("required template %q not found", name)
 		}
-		return nil
-	})
+	}
+	slog.Debug("Parsed templates", "count", len(templates.Templates()), "duration", time.Since(start))
+	return templates, nil
+}
+
+// reloadTemplates parses the templates again and swaps them in. If parsing
+// fails, the error is logged and the current templates are kept.
+func (h *Hubro) reloadTemplates() {
+	templates, err := parseTemplates(h.layoutDir, h.templateDir, h.funcMap)
+	if err != nil {
+		slog.Error("Error reloading templates, keeping the current ones", "error", err)
+		return
+	}
+	h.templates.Store(templates)
+	h.invalidate()
+	slog.Info("Reloaded templates")
+}
+
+// Templates returns the current template set
+func (h *Hubro) Templates() *template.Template {
+	return h.templates.Load()
 }
 
 func (hu *Hubro) FileServerWithDirectoryListingDisabled(h http.Handler) http.Handler {
@@ -240,8 +324,8 @@ func (hu *Hubro) FileServerWithDirectoryListingDisabled(h http.Handler) http.Han
 	})
 }
 
-func (h *Hubro) initStaticFiles() {
-	fs := http.FileServer(http.Dir("./view/static"))
+func (h *Hubro) initStaticFiles(staticDir fs.FS) {
+	fs := http.FileServer(http.FS(staticDir))
 	h.Mux.Handle("GET /static/", http.StripPrefix("/static/", h.FileServerWithDirectoryListingDisabled(fs)))
 }
 
@@ -256,6 +340,10 @@ func (h *Hubro) indexHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if h.NotModified(w, r, time.Time{}) {
+		return
+	}
+
 	tag, page := parseQueryParams(r)
 
 	h.Render(w, r, "blogindex", struct {
@@ -265,8 +353,8 @@ func (h *Hubro) indexHandler(w http.ResponseWriter, r *http.Request) {
 		Page        int
 	}{
 		FilterByTag: tag,
-		Title:       h.config.Description,
-		Description: h.config.Description,
+		Title:       h.Config().Description,
+		Description: h.Config().Description,
 		Page:        page,
 	})
 }
@@ -321,7 +409,7 @@ func (h *Hubro) ErrorHandler(w http.ResponseWriter, r *http.Request, status int,
 		Status:  status,
 		Message: message,
 	}
-	if h.Templates.Lookup(errorTemplate) == nil {
+	if h.Templates().Lookup(errorTemplate) == nil {
 		slog.Warn("Error template for error status not found", "status", status, "template", errorTemplate)
 		errorTemplate = defaultErrorTemplate
 	}
@@ -339,7 +427,7 @@ func (h *Hubro) RenderWithLayout(
 	if data == nil {
 		data = map[string]any{}
 	}
-	clone, err := h.Templates.Clone()
+	clone, err := h.Templates().Clone()
 	if err != nil {
 		slog.Error("can't clone templates", "error", err)
 		http.Error(w, "Failed to render layout", http.StatusInternalServerError)
@@ -355,8 +443,11 @@ func (h *Hubro) RenderWithLayout(
 		"boosted": func() bool {
 			return r.Header.Get("HX-Boosted") == "true"
 		},
+		"csrfToken": func() string {
+			return CSRFToken(r)
+		},
 		"paginator": func(page int, entries []index.IndexEntry) template.HTML {
-			totalPages := (len(entries) + hc.Config.PostsPerPage - 1) / hc.Config.PostsPerPage
+			totalPages := (len(entries) + h.Config().PostsPerPage - 1) / h.Config().PostsPerPage
 			return helpers.Paginator(r.URL, page, totalPages, entries)
 		},
 		"openGraphType": func() string {
@@ -379,7 +470,7 @@ func (h *Hubro) RenderWithoutLayout(w http.ResponseWriter, r *http.Request, temp
 	if data == nil {
 		data = map[string]any{}
 	}
-	clone, err := h.Templates.Clone()
+	clone, err := h.Templates().Clone()
 	if err != nil {
 		slog.Error("can't clone templates", "error", err)
 		http.Error(w, "Failed to render template", http.StatusInternalServerError)
@@ -393,38 +484,40 @@ func (h *Hubro) RenderWithoutLayout(w http.ResponseWriter, r *http.Request, temp
 }
 
 func (h *Hubro) Render(w http.ResponseWriter, r *http.Request, templateName string, data any) {
+	if h.pageCache != nil && r.Method == http.MethodGet {
+		h.renderCached(w, r, templateName, data)
+		return
+	}
 	h.RenderWithLayout(w, r, rootLayout, templateName, data)
 }
 
 func NewHubro(config Config) *Hubro {
 	h := &Hubro{
-		config: *hc.Config,
-		Mux:    http.NewServeMux(),
+		Mux: http.NewServeMux(),
 		Server: &http.Server{
 			Addr: fmt.Sprintf(":%d", config.Port),
 		},
 		publicDir: config.PublicDir,
+		site:      config.Site,
+		indices:   make(index.Indices),
 	}
-	cssFileName := "view/static/app.css"
-	cssAssetModificationTime, err := os.Stat(cssFileName)
-	if err != nil {
-		slog.Error("Error getting file info, CSS file not found", "filename", cssFileName)
-		panic(err)
+	h.ctx, h.cancel = context.WithCancel(context.Background())
+	h.validators.init()
+	if h.Config().PageCacheEnabled {
+		h.pageCache = newPageCache(h.Config().PageCacheMaxEntries, h.Config().PageCacheMaxBytes)
+		slog.Info("Page cache enabled", "maxEntries", h.Config().PageCacheMaxEntries, "maxBytes", h.Config().PageCacheMaxBytes)
 	}
-	jsFileName := "view/static/app.js"
-	jsAssetModificationTime, err := os.Stat(jsFileName)
-	if err != nil {
-		slog.Error("Error getting file info, JS file not found", "filename", jsFileName)
-		panic(err)
+	h.initTemplates(config.LayoutDir,
+		config.TemplateDir,
+		assetVersion(config.StaticDir, "app.css"),
+		assetVersion(config.StaticDir, "app.js"))
+	for _, dir := range config.WatchDirs {
+		if err := watchfs.Watch(h.ctx, dir, h.reloadTemplates); err != nil {
+			slog.Error("Error watching template directory", "directory", dir, "error", err)
+		}
 	}
 	go func() {
-		h.initTemplates(config.LayoutDir,
-			config.TemplateDir,
-			cssAssetModificationTime.ModTime().Unix(),
-			jsAssetModificationTime.ModTime().Unix())
-	}()
-	go func() {
-		h.initStaticFiles()
+		h.initStaticFiles(config.StaticDir)
 	}()
 	go func() {
 		h.initVendorDir(config.VendorDir)
@@ -434,9 +527,54 @@ func NewHubro(config Config) *Hubro {
 	return h
 }
 
-func (h *Hubro) Start(startTime time.Time) error {
+// Start serves requests until ctx is done or the process receives SIGINT or
+// SIGTERM, and then shuts the server down gracefully. SIGHUP reloads the configuration.
+func (h *Hubro) Start(ctx context.Context, startTime time.Time) error {
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	go func() {
+		for {
+			select {
+			case <-hup:
+				h.ReloadConfig()
+			case <-h.ctx.Done():
+				return
+			}
+		}
+	}()
+
 	h.Server.Handler = h.GetHandler()
-	port := h.config.Port
-	slog.Info("Server started", "port", port, "rootPath", h.config.RootPath, "duration", time.Since(startTime))
-	return http.ListenAndServe(fmt.Sprintf(":%d", port), h.Server.Handler)
+	serverErr := make(chan error, 1)
+	if h.tlsEnabled() {
+		redirect, err := h.configureTLS()
+		if err != nil {
+			return err
+		}
+		if h.Config().HTTPRedirectPort != 0 {
+			h.startRedirectListener(redirect)
+		}
+		go func() {
+			// The certificates are already in the TLS config
+			serverErr <- h.Server.ListenAndServeTLS("", "")
+		}()
+	} else {
+		go func() {
+			serverErr <- h.Server.ListenAndServe()
+		}()
+	}
+	slog.Info("Server started", "port", h.Config().Port, "tls", h.tlsEnabled(), "rootPath", h.Config().RootPath,
+		"duration", time.Since(startTime))
+
+	select {
+	case err := <-serverErr:
+		h.Shutdown()
+		return err
+	case <-ctx.Done():
+		stop()
+		slog.Info("Shutting down", "timeout", h.Config().ShutdownTimeout)
+		return h.Shutdown()
+	}
 }
@@ -0,0 +1,54 @@
+package server
+
+import (
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestTemplatesReload checks that an edited template is picked up from disk,
+// and that a broken one keeps the current templates.
+func TestTemplatesReload(t *testing.T) {
+	dir := t.TempDir()
+	for name, file := range testViews {
+		if err := os.MkdirAll(filepath.Join(dir, filepath.Dir(name)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), file.Data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeTemplate := func(content string) {
+		if err := os.WriteFile(filepath.Join(dir, "greeting.gohtml"), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeTemplate("Hello")
+	views := os.DirFS(dir)
+	h := testHubro(t, views, dir)
+	render := func() string {
+		w := httptest.NewRecorder()
+		h.RenderWithLayout(w, httptest.NewRequest("GET", "/", nil), "app", "greeting", nil)
+		return w.Body.String()
+	}
+	if got := render(); got != "Hello" {
+		t.Fatalf("expected Hello, got %q", got)
+	}
+
+	writeTemplate("Goodbye")
+	deadline := time.Now().Add(5 * time.Second)
+	for render() != "Goodbye" {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the edited template to be picked up, got %q", render())
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	writeTemplate("{{ if }}")
+	h.reloadTemplates()
+	if got := render(); got != "Goodbye" {
+		t.Errorf("expected the current templates to be kept, got %q", got)
+	}
+}
@@ -0,0 +1,192 @@
+package server
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"net/http"
+	"sync"
+	"sync/atomic"
+)
+
+// pageCache is an LRU cache of fully rendered pages, bounded both by the
+// number of pages and their total size. It is reset whenever an index is
+// updated, so it never serves stale content.
+type pageCache struct {
+	mtx        sync.Mutex
+	maxEntries int
+	maxBytes   int64
+	size       int64
+	lru        *list.List
+	items      map[string]*list.Element
+	// Counts resets, so pages rendered from data read before one are not kept
+	generation uint64
+	hits       atomic.Uint64
+	misses     atomic.Uint64
+}
+
+type cachedPage struct {
+	key  string
+	body []byte
+}
+
+type PageCacheStats struct {
+	Enabled    bool    `json:"enabled"`
+	Entries    int     `json:"entries"`
+	Bytes      int64   `json:"bytes"`
+	MaxEntries int     `json:"maxEntries"`
+	MaxBytes   int64   `json:"maxBytes"`
+	Hits       uint64  `json:"hits"`
+	Misses     uint64  `json:"misses"`
+	HitRatio   float64 `json:"hitRatio"`
+}
+
+func newPageCache(maxEntries int, maxBytes int64) *pageCache {
+	return &pageCache{
+		maxEntries: maxEntries,
+		maxBytes:   maxBytes,
+		lru:        list.New(),
+		items:      make(map[string]*list.Element),
+	}
+}
+
+func pageCacheKey(r *http.Request) string {
+	// RequestURI is used rather than URL, since modules see the URL with their prefix stripped
+	return r.RequestURI + "|" + r.Header.Get("HX-Boosted")
+}
+
+func (c *pageCache) get(key string) ([]byte, bool) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	el, ok := c.items[key]
+	if !ok {
+		c.misses.Add(1)
+		return nil, false
+	}
+	c.hits.Add(1)
+	c.lru.MoveToFront(el)
+	return el.Value.(*cachedPage).body, true
+}
+
+// set caches a page rendered for a request that started in generation. It is
+// left out if the cache has been reset since, as it may show stale data.
+func (c *pageCache) set(key string, body []byte, generation uint64) {
+	size := int64(len(body))
+	if size > c.maxBytes {
+		return
+	}
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if generation != c.generation {
+		return
+	}
+	if el, ok := c.items[key]; ok {
+		c.removeElement(el)
+	}
+	for c.lru.Len() > 0 && (c.lru.Len() >= c.maxEntries || c.size+size > c.maxBytes) {
+		c.removeElement(c.lru.Back())
+	}
+	c.items[key] = c.lru.PushFront(&cachedPage{key: key, body: body})
+	c.size += size
+}
+
+// removeElement must be called with the lock held
+func (c *pageCache) removeElement(el *list.Element) {
+	page := c.lru.Remove(el).(*cachedPage)
+	delete(c.items, page.key)
+	c.size -= int64(len(page.body))
+}
+
+func (c *pageCache) reset() {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.lru.Init()
+	c.items = make(map[string]*list.Element)
+	c.size = 0
+	c.generation++
+}
+
+func (c *pageCache) currentGeneration() uint64 {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.generation
+}
+
+type pageCacheGenerationKey struct{}
+
+// withPageCacheGeneration notes the generation of the page cache when a
+// request comes in, before the handler reads the data it renders
+func (h *Hubro) withPageCacheGeneration(next http.Handler) http.Handler {
+	if h.pageCache == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), pageCacheGenerationKey{}, h.pageCache.currentGeneration())
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func (c *pageCache) stats() PageCacheStats {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	s := PageCacheStats{
+		Enabled:    true,
+		Entries:    c.lru.Len(),
+		Bytes:      c.size,
+		MaxEntries: c.maxEntries,
+		MaxBytes:   c.maxBytes,
+		Hits:       c.hits.Load(),
+		Misses:     c.misses.Load(),
+	}
+	if total := s.Hits + s.Misses; total > 0 {
+		s.HitRatio = float64(s.Hits) / float64(total)
+	}
+	return s
+}
+
+// PageCacheStats returns statistics for the rendered page cache
+func (h *Hubro) PageCacheStats() PageCacheStats {
+	if h.pageCache == nil {
+		return PageCacheStats{}
+	}
+	return h.pageCache.stats()
+}
+
+// bufferedResponseWriter collects a response, so it can be cached before it is sent
+type bufferedResponseWriter struct {
+	header http.Header
+	status int
+	buf    bytes.Buffer
+}
+
+func (b *bufferedResponseWriter) Header() http.Header {
+	return b.header
+}
+
+func (b *bufferedResponseWriter) Write(p []byte) (int, error) {
+	return b.buf.Write(p)
+}
+
+func (b *bufferedResponseWriter) WriteHeader(status int) {
+	b.status = status
+}
+
+// renderCached renders a page with the root layout, serving it from the page cache if possible
+func (h *Hubro) renderCached(w http.ResponseWriter, r *http.Request, templateName string, data any) {
+	key := pageCacheKey(r)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if body, ok := h.pageCache.get(key); ok {
+		w.Write(body)
+		return
+	}
+
+	bw := &bufferedResponseWriter{header: w.Header(), status: http.StatusOK}
+	h.RenderWithLayout(bw, r, rootLayout, templateName, data)
+	// Requests that didn't pass through the handler can't tell when their data was read
+	generation, ok := r.Context().Value(pageCacheGenerationKey{}).(uint64)
+	if bw.status == http.StatusOK && ok {
+		h.pageCache.set(key, bytes.Clone(bw.buf.Bytes()), generation)
+	}
+	w.WriteHeader(bw.status)
+	w.Write(bw.buf.Bytes())
+}
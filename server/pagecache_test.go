@@ -0,0 +1,76 @@
+package server
+
+import (
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+)
+
+// TestPageCacheEviction checks that the least recently used pages are evicted
+// when either the entry or the size limit is reached.
+func TestPageCacheEviction(t *testing.T) {
+	c := newPageCache(2, 10)
+	c.set("a", []byte("aaa"), 0)
+	c.set("b", []byte("bbb"), 0)
+	c.get("a")
+	c.set("c", []byte("ccc"), 0)
+	if _, ok := c.get("b"); ok {
+		t.Errorf("expected b to be evicted")
+	}
+	if _, ok := c.get("a"); !ok {
+		t.Errorf("expected a to be cached")
+	}
+
+	c.set("d", []byte("dddddddd"), 0)
+	if s := c.stats(); s.Entries != 1 || s.Bytes != 8 {
+		t.Errorf("expected only d to be cached, got %d entries of %d bytes", s.Entries, s.Bytes)
+	}
+	c.set("e", []byte("too large to cache"), 0)
+	if _, ok := c.get("e"); ok {
+		t.Errorf("expected e to be too large")
+	}
+
+	c.reset()
+	if s := c.stats(); s.Entries != 0 || s.Bytes != 0 || s.Hits != 2 || s.Misses != 2 {
+		t.Errorf("unexpected stats after reset: %+v", s)
+	}
+}
+
+// TestPageCacheInvalidatedDuringRender checks that a page rendered from data
+// read before an update is not cached.
+func TestPageCacheInvalidatedDuringRender(t *testing.T) {
+	t.Setenv("HUBRO_PAGE_CACHE_ENABLED", "true")
+	views := fstest.MapFS{"page.gohtml": {Data: []byte(`{{.}}`)}}
+	maps.Copy(views, testViews)
+	h := testHubro(t, views)
+	version := "old"
+	h.Mux.HandleFunc("GET /page", func(w http.ResponseWriter, r *http.Request) {
+		data := version
+		if data == "old" {
+			// The update lands while the old data is being rendered
+			version = "new"
+			h.invalidate()
+		}
+		h.Render(w, r, "page", data)
+	})
+	handler := h.GetHandler()
+	get := func() string {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/page", nil))
+		return w.Body.String()
+	}
+	if got := get(); got != "old" {
+		t.Fatalf("expected the old page to be rendered, got %q", got)
+	}
+	if s := h.PageCacheStats(); s.Entries != 0 {
+		t.Errorf("expected the stale page not to be cached, got %d entries", s.Entries)
+	}
+	if got := get(); got != "new" {
+		t.Errorf("expected the new page, got %q", got)
+	}
+	if got := get(); got != "new" || h.PageCacheStats().Hits != 1 {
+		t.Errorf("expected the new page from the cache, got %q with %+v", got, h.PageCacheStats())
+	}
+}
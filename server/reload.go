@@ -0,0 +1,45 @@
+package server
+
+import (
+	"log/slog"
+
+	hc "github.com/sokkalf/hubro/config"
+	"github.com/sokkalf/hubro/index"
+)
+
+// Config returns the current configuration of the site
+func (h *Hubro) Config() *hc.HubroConfig {
+	return hc.Get().Site(h.site)
+}
+
+// Indices returns the indices of the site
+func (h *Hubro) Indices() index.Indices {
+	return h.indices
+}
+
+// ReloadConfig reloads the configuration, and returns the keys of changed
+// settings that need a restart to take effect. If the new configuration is
+// invalid, the current one is kept and the error returned.
+// The configuration is shared by all sites, so they are all refreshed.
+func (h *Hubro) ReloadConfig() ([]string, error) {
+	if h.primary != nil {
+		return h.primary.ReloadConfig()
+	}
+	needsRestart, err := hc.Reload()
+	if err != nil {
+		slog.Error("Error reloading configuration, keeping the current one", "error", err)
+		return nil, err
+	}
+	if len(needsRestart) > 0 {
+		slog.Warn("Changed settings require a restart to take effect", "settings", needsRestart)
+	}
+	// Feeds, the sitemap and cached pages are generated from the configuration as well as the indices
+	for _, site := range h.sites() {
+		for _, idx := range site.indices {
+			idx.MsgBroker.Publish(index.Updated)
+		}
+		site.invalidate()
+	}
+	slog.Info("Reloaded configuration")
+	return needsRestart, nil
+}
@@ -0,0 +1,97 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// Context returns a context that is cancelled when the server shuts down.
+// Background work and long-lived connections should stop when it is done.
+func (h *Hubro) Context() context.Context {
+	return h.ctx
+}
+
+// TrackConnection registers a long-lived connection, such as a websocket,
+// which the server waits for when shutting down. The returned context is
+// cancelled on shutdown, and done must be called when the connection is closed.
+// Once shutdown has started, ok is false and the connection should be closed.
+func (h *Hubro) TrackConnection() (ctx context.Context, done func(), ok bool) {
+	h.connectionsMutex.Lock()
+	defer h.connectionsMutex.Unlock()
+	if h.closing {
+		return h.ctx, func() {}, false
+	}
+	h.connections.Add(1)
+	return h.ctx, h.connections.Done, true
+}
+
+// closeConnections stops new connections from being tracked, so that the
+// ones open can be waited for
+func (h *Hubro) closeConnections() {
+	h.connectionsMutex.Lock()
+	defer h.connectionsMutex.Unlock()
+	h.closing = true
+}
+
+// OnShutdown registers a function to run after the server has stopped.
+// Functions run in the reverse order of registration.
+func (h *Hubro) OnShutdown(f func()) {
+	h.shutdownMutex.Lock()
+	defer h.shutdownMutex.Unlock()
+	h.shutdownHooks = append(h.shutdownHooks, f)
+}
+
+// Shutdown stops accepting requests, waits for in-flight requests and tracked
+// connections until the shutdown timeout, stops the index brokers and runs
+// the shutdown hooks. Virtual hosts are shut down along with h.
+func (h *Hubro) Shutdown() error {
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), h.Config().ShutdownTimeout)
+	defer cancel()
+
+	// Hijacked connections are not tracked by http.Server, they are told to close through the server context
+	for _, site := range h.sites() {
+		site.closeConnections()
+		site.cancel()
+	}
+	err := h.Server.Shutdown(ctx)
+	if err != nil {
+		slog.Warn("Timed out waiting for requests to finish", "error", err)
+	}
+	if h.redirectServer != nil {
+		h.redirectServer.Shutdown(ctx)
+	}
+	connectionsClosed := make(chan struct{})
+	go func() {
+		for _, site := range h.sites() {
+			site.connections.Wait()
+		}
+		close(connectionsClosed)
+	}()
+	select {
+	case <-connectionsClosed:
+	case <-ctx.Done():
+		slog.Warn("Timed out waiting for connections to close")
+	}
+
+	for _, site := range h.sites() {
+		site.stop()
+	}
+	slog.Info("Server stopped", "duration", time.Since(start))
+	return err
+}
+
+// stop stops the index brokers of the site and runs its shutdown hooks
+func (h *Hubro) stop() {
+	for _, idx := range h.indices {
+		idx.MsgBroker.Stop()
+	}
+	h.shutdownMutex.Lock()
+	hooks := h.shutdownHooks
+	h.shutdownHooks = nil
+	h.shutdownMutex.Unlock()
+	for i := len(hooks) - 1; i >= 0; i-- {
+		hooks[i]()
+	}
+}
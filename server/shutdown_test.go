@@ -0,0 +1,56 @@
+package server
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestShutdownWaitsForConnections checks that shutdown waits for tracked
+// connections to close, and that no new ones are tracked once it has started.
+func TestShutdownWaitsForConnections(t *testing.T) {
+	t.Setenv("HUBRO_SHUTDOWN_TIMEOUT", "5s")
+	h := testHubro(t, testViews)
+	ctx, done, ok := h.TrackConnection()
+	if !ok {
+		t.Fatal("expected the connection to be tracked")
+	}
+	stopped := make(chan struct{})
+	go func() {
+		h.Shutdown()
+		close(stopped)
+	}()
+
+	<-ctx.Done()
+	if _, _, ok := h.TrackConnection(); ok {
+		t.Errorf("expected no connections to be tracked during shutdown")
+	}
+	select {
+	case <-stopped:
+		t.Fatal("expected shutdown to wait for the connection")
+	case <-time.After(50 * time.Millisecond):
+	}
+	done()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("expected shutdown to finish when the connection closed")
+	}
+}
+
+// TestShutdownWhileConnecting checks that connections opened while the
+// server shuts down are either waited for or turned away.
+func TestShutdownWhileConnecting(t *testing.T) {
+	h := testHubro(t, testViews)
+	var wg sync.WaitGroup
+	for range 50 {
+		wg.Go(func() {
+			if _, done, ok := h.TrackConnection(); ok {
+				time.Sleep(time.Millisecond)
+				done()
+			}
+		})
+	}
+	h.Shutdown()
+	wg.Wait()
+}
@@ -0,0 +1,173 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+func (h *Hubro) tlsEnabled() bool {
+	return h.Config().ACMEEnabled || h.Config().TLSCertFile != ""
+}
+
+// configureTLS sets up the server for either static certificates or ACME, and
+// returns the handler to use for plain HTTP requests on the redirect listener.
+func (h *Hubro) configureTLS() (http.Handler, error) {
+	cfg := h.Config()
+	redirect := http.HandlerFunc(h.redirectToHTTPS)
+	if !cfg.ACMEEnabled {
+		if cfg.TLSKeyFile == "" {
+			return nil, fmt.Errorf("a TLS key file is required along with the certificate file")
+		}
+		// Load the certificate up front, so a bad one is reported before we start listening
+		certs := &certificateFiles{certFile: cfg.TLSCertFile, keyFile: cfg.TLSKeyFile}
+		if err := certs.load(); err != nil {
+			return nil, err
+		}
+		h.Server.TLSConfig = &tls.Config{GetCertificate: certs.getCertificate}
+		return redirect, nil
+	}
+
+	if len(cfg.ACMEHosts) == 0 {
+		return nil, fmt.Errorf("no hosts configured for ACME")
+	}
+	m := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		Cache:      autocert.DirCache(cfg.ACMECacheDir),
+		HostPolicy: autocert.HostWhitelist(cfg.ACMEHosts...),
+		Email:      cfg.ACMEEmail,
+	}
+	if cfg.ACMEDirectoryURL != "" {
+		client := &acme.Client{DirectoryURL: cfg.ACMEDirectoryURL}
+		if cfg.ACMECAFile != "" {
+			// Test servers such as Pebble serve the directory with a certificate from their own CA
+			httpClient, err := httpClientWithCA(cfg.ACMECAFile)
+			if err != nil {
+				return nil, err
+			}
+			client.HTTPClient = httpClient
+		}
+		m.Client = client
+	}
+	h.Server.TLSConfig = m.TLSConfig()
+	slog.Info("ACME enabled", "hosts", cfg.ACMEHosts, "directoryURL", cfg.ACMEDirectoryURL)
+	// The redirect listener also answers HTTP-01 challenges
+	return m.HTTPHandler(redirect), nil
+}
+
+// certificateFiles serves a static certificate, and loads it again when the
+// files change, so that renewed certificates are picked up without a restart
+type certificateFiles struct {
+	certFile, keyFile string
+
+	mtx      sync.Mutex
+	cert     *tls.Certificate
+	modTimes [2]time.Time
+}
+
+func (c *certificateFiles) stat() ([2]time.Time, error) {
+	var modTimes [2]time.Time
+	for i, name := range []string{c.certFile, c.keyFile} {
+		info, err := os.Stat(name)
+		if err != nil {
+			return modTimes, err
+		}
+		modTimes[i] = info.ModTime()
+	}
+	return modTimes, nil
+}
+
+// load reads the certificate and key if they changed since they were last read
+func (c *certificateFiles) load() error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	modTimes, err := c.stat()
+	if err != nil {
+		return fmt.Errorf("loading TLS certificate: %w", err)
+	}
+	if c.cert != nil && modTimes == c.modTimes {
+		return nil
+	}
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		// Try again when the files next change
+		c.modTimes = modTimes
+		return fmt.Errorf("loading TLS certificate: %w", err)
+	}
+	c.cert, c.modTimes = &cert, modTimes
+	return nil
+}
+
+// getCertificate keeps serving the certificate it has if the files can't be
+// read, as they may be halfway through being replaced
+func (c *certificateFiles) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	if err := c.load(); err != nil {
+		slog.Error("Error reloading TLS certificate", "error", err)
+	}
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.cert, nil
+}
+
+func httpClientWithCA(caFile string) (*http.Client, error) {
+	pem, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("reading ACME CA file: %w", err)
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in ACME CA file %s", caFile)
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	return &http.Client{Transport: transport, Timeout: 30 * time.Second}, nil
+}
+
+func (h *Hubro) redirectToHTTPS(w http.ResponseWriter, r *http.Request) {
+	cfg := h.Config()
+	host := r.Host
+	if hostname, _, err := net.SplitHostPort(r.Host); err == nil {
+		host = hostname
+	}
+	if cfg.Port != 443 {
+		host = net.JoinHostPort(host, strconv.Itoa(cfg.Port))
+	}
+	target := "https://" + host + r.URL.RequestURI()
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Redirect(w, r, target, http.StatusPermanentRedirect)
+		return
+	}
+	http.Redirect(w, r, target, http.StatusMovedPermanently)
+}
+
+// startRedirectListener serves plain HTTP on the redirect port, in the background
+func (h *Hubro) startRedirectListener(handler http.Handler) {
+	cfg := h.Config()
+	h.redirectServer = &http.Server{
+		Addr:              ":" + strconv.Itoa(cfg.HTTPRedirectPort),
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		err := h.redirectServer.ListenAndServe()
+		if err != nil && err != http.ErrServerClosed {
+			slog.Error("Error starting HTTP redirect listener", "error", err)
+		}
+	}()
+	slog.Info("HTTP redirect listener started", "port", cfg.HTTPRedirectPort,
+		"redirectsTo", strings.TrimSuffix(cfg.BaseURL, "/"))
+}
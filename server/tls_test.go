@@ -0,0 +1,134 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	hc "github.com/sokkalf/hubro/config"
+)
+
+// writeCertificate writes a self-signed certificate for example.org and its
+// key to dir
+func writeCertificate(t *testing.T, dir string) (certFile string, keyFile string) {
+	return writeCertificateFor(t, dir, "example.org")
+}
+
+func writeCertificateFor(t *testing.T, dir string, name string) (certFile string, keyFile string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+// TestConfigureTLS checks the TLS configuration for static certificates and
+// for ACME, and that bad certificates are reported before listening.
+func TestConfigureTLS(t *testing.T) {
+	h := testHubro(t, testViews)
+	dir := t.TempDir()
+	certFile, keyFile := writeCertificate(t, dir)
+
+	hc.Update(func(c *hc.HubroConfig) { c.TLSCertFile, c.TLSKeyFile = certFile, keyFile })
+	if !h.tlsEnabled() {
+		t.Fatal("expected TLS to be enabled with a certificate")
+	}
+	if _, err := h.configureTLS(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cert, err := h.Server.TLSConfig.GetCertificate(nil); err != nil || cert.Leaf.Subject.CommonName != "example.org" {
+		t.Errorf("expected the certificate to be loaded, got %+v, %v", cert, err)
+	}
+
+	for _, files := range [][2]string{{certFile, ""}, {certFile, certFile}, {filepath.Join(dir, "missing.pem"), keyFile}} {
+		hc.Update(func(c *hc.HubroConfig) { c.TLSCertFile, c.TLSKeyFile = files[0], files[1] })
+		if _, err := h.configureTLS(); err == nil {
+			t.Errorf("expected an error for certificate %q and key %q", files[0], files[1])
+		}
+	}
+
+	hc.Update(func(c *hc.HubroConfig) {
+		c.TLSCertFile, c.TLSKeyFile = "", ""
+		c.ACMEEnabled, c.ACMEHosts, c.ACMECacheDir = true, []string{"example.org"}, filepath.Join(dir, "acme")
+	})
+	redirect, err := h.configureTLS()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if h.Server.TLSConfig.GetCertificate == nil || !slices.Contains(h.Server.TLSConfig.NextProtos, "acme-tls/1") {
+		t.Errorf("expected certificates from ACME, got %+v", h.Server.TLSConfig)
+	}
+	w := httptest.NewRecorder()
+	redirect.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://example.org/blog/?p=2", nil))
+	if w.Code != http.StatusMovedPermanently || w.Header().Get("Location") != "https://example.org:8080/blog/?p=2" {
+		t.Errorf("expected a redirect to HTTPS, got %d to %q", w.Code, w.Header().Get("Location"))
+	}
+
+	hc.Update(func(c *hc.HubroConfig) { c.ACMEHosts = nil })
+	if _, err := h.configureTLS(); err == nil {
+		t.Errorf("expected an error for ACME without hosts")
+	}
+}
+
+// TestStaticCertificateReload checks that a replaced certificate is served
+// without a restart, and that the old one is kept while the files are bad.
+func TestStaticCertificateReload(t *testing.T) {
+	h := testHubro(t, testViews)
+	dir := t.TempDir()
+	certFile, keyFile := writeCertificate(t, dir)
+	hc.Update(func(c *hc.HubroConfig) { c.TLSCertFile, c.TLSKeyFile = certFile, keyFile })
+	if _, err := h.configureTLS(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	served := func() string {
+		cert, err := h.Server.TLSConfig.GetCertificate(nil)
+		if err != nil || cert == nil {
+			t.Fatalf("expected a certificate, got %v", err)
+		}
+		return cert.Leaf.Subject.CommonName
+	}
+
+	os.WriteFile(certFile, []byte("not a certificate"), 0644)
+	// Modification times can be too coarse to tell two quick writes apart
+	os.Chtimes(certFile, time.Now(), time.Now().Add(time.Minute))
+	if name := served(); name != "example.org" {
+		t.Errorf("expected the old certificate while the new one is bad, got %s", name)
+	}
+	writeCertificateFor(t, dir, "renewed.example.org")
+	if name := served(); name != "renewed.example.org" {
+		t.Errorf("expected the renewed certificate, got %s", name)
+	}
+}
@@ -0,0 +1,61 @@
+package server
+
+import (
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// AddVirtualHost serves site for requests to the host of its base URL. The
+// site shares the listener of h, and requests for any host that isn't a
+// virtual host are served by h itself.
+func (h *Hubro) AddVirtualHost(site *Hubro) {
+	u, err := url.Parse(site.Config().BaseURL)
+	if err != nil || u.Hostname() == "" {
+		slog.Error("Can't add virtual host without a host name", "site", site.site, "baseURL", site.Config().BaseURL)
+		return
+	}
+	if h.virtualHosts == nil {
+		h.virtualHosts = make(map[string]*Hubro)
+	}
+	host := strings.ToLower(u.Hostname())
+	h.virtualHosts[host] = site
+	site.primary = h
+	slog.Info("Added virtual host", "site", site.site, "host", host)
+}
+
+// sites returns the virtual hosts served by h, followed by h itself
+func (h *Hubro) sites() []*Hubro {
+	sites := make([]*Hubro, 0, len(h.virtualHosts)+1)
+	for _, site := range h.virtualHosts {
+		sites = append(sites, site)
+	}
+	return append(sites, h)
+}
+
+// requestHost returns the host name a request is for, without the port
+func requestHost(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.Host)
+	if err != nil {
+		host = r.Host
+	}
+	return strings.ToLower(strings.TrimSuffix(host, "."))
+}
+
+// virtualHostHandler dispatches requests to the virtual host matching the
+// Host header, or to fallback
+func (h *Hubro) virtualHostHandler(fallback http.Handler) http.Handler {
+	handlers := make(map[string]http.Handler, len(h.virtualHosts))
+	for host, site := range h.virtualHosts {
+		handlers[host] = site.GetHandler()
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if handler, ok := handlers[requestHost(r)]; ok {
+			handler.ServeHTTP(w, r)
+			return
+		}
+		fallback.ServeHTTP(w, r)
+	})
+}
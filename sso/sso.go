@@ -0,0 +1,177 @@
+// Package sso logs users in through an OpenID Connect provider, with the
+// authorization code flow
+package sso
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+var (
+	ErrState            = errors.New("login state doesn't match, start the login again")
+	ErrNonce            = errors.New("ID token nonce doesn't match")
+	ErrNoIDToken        = errors.New("no ID token in the token response")
+	ErrNoEmail          = errors.New("the provider didn't give an email address")
+	ErrEmailNotVerified = errors.New("the email address is not verified by the provider")
+)
+
+type Config struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	// Where the provider sends the user back to, as registered with it
+	RedirectURL string
+	// The ID token claim listing the groups of the user, "groups" if empty
+	GroupsClaim string
+}
+
+// Identity is who the provider says the user is
+type Identity struct {
+	Subject string
+	Email   string
+	// Whether the provider has verified that the user owns Email
+	EmailVerified bool
+	Name          string
+	Groups        []string
+}
+
+// Flow is the state of one login, kept by the user agent until the provider
+// sends it back
+type Flow struct {
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+}
+
+// Client discovers providers the first time they are used, so that Hubro
+// starts even if the provider is down, and picks up a changed issuer
+type Client struct {
+	mtx       sync.Mutex
+	providers map[string]*oidc.Provider
+}
+
+func NewClient() *Client {
+	return &Client{providers: map[string]*oidc.Provider{}}
+}
+
+func (c *Client) provider(ctx context.Context, issuer string) (*oidc.Provider, error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if p, ok := c.providers[issuer]; ok {
+		return p, nil
+	}
+	p, err := oidc.NewProvider(ctx, issuer)
+	if err != nil {
+		return nil, fmt.Errorf("discovering OpenID provider %s: %w", issuer, err)
+	}
+	c.providers[issuer] = p
+	return p, nil
+}
+
+func oauth2Config(p *oidc.Provider, cfg Config) *oauth2.Config {
+	return &oauth2.Config{
+		ClientID:     cfg.ClientID,
+		ClientSecret: cfg.ClientSecret,
+		RedirectURL:  cfg.RedirectURL,
+		Endpoint:     p.Endpoint(),
+		Scopes:       []string{oidc.ScopeOpenID, "email", "profile"},
+	}
+}
+
+func random() string {
+	b := make([]byte, 24)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// Start returns the URL to send the user to, and the flow to keep until the
+// provider sends them back
+func (c *Client) Start(ctx context.Context, cfg Config) (string, Flow, error) {
+	p, err := c.provider(ctx, cfg.Issuer)
+	if err != nil {
+		return "", Flow{}, err
+	}
+	flow := Flow{State: random(), Nonce: random(), Verifier: oauth2.GenerateVerifier()}
+	url := oauth2Config(p, cfg).AuthCodeURL(flow.State, oidc.Nonce(flow.Nonce), oauth2.S256ChallengeOption(flow.Verifier))
+	return url, flow, nil
+}
+
+// Finish exchanges the code the provider sent the user back with for their
+// verified identity. state is the one sent back, which must match the flow.
+func (c *Client) Finish(ctx context.Context, cfg Config, flow Flow, state string, code string) (*Identity, error) {
+	if flow.State == "" || subtle.ConstantTimeCompare([]byte(state), []byte(flow.State)) != 1 {
+		return nil, ErrState
+	}
+	p, err := c.provider(ctx, cfg.Issuer)
+	if err != nil {
+		return nil, err
+	}
+	token, err := oauth2Config(p, cfg).Exchange(ctx, code, oauth2.VerifierOption(flow.Verifier))
+	if err != nil {
+		return nil, fmt.Errorf("exchanging code: %w", err)
+	}
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return nil, ErrNoIDToken
+	}
+	idToken, err := p.Verifier(&oidc.Config{ClientID: cfg.ClientID}).Verify(ctx, rawIDToken)
+	if err != nil {
+		return nil, fmt.Errorf("verifying ID token: %w", err)
+	}
+	if subtle.ConstantTimeCompare([]byte(idToken.Nonce), []byte(flow.Nonce)) != 1 {
+		return nil, ErrNonce
+	}
+	var claims map[string]any
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, err
+	}
+	return identity(idToken.Subject, claims, cfg.GroupsClaim)
+}
+
+func identity(subject string, claims map[string]any, groupsClaim string) (*Identity, error) {
+	id := &Identity{Subject: subject}
+	id.Email, _ = claims["email"].(string)
+	if id.Email == "" {
+		return nil, ErrNoEmail
+	}
+	// Users are matched by their address, so it is only trusted once the
+	// provider says it has verified it
+	if verified, _ := claims["email_verified"].(bool); !verified {
+		return nil, ErrEmailNotVerified
+	}
+	id.EmailVerified = true
+	id.Name, _ = claims["name"].(string)
+	if groupsClaim == "" {
+		groupsClaim = "groups"
+	}
+	switch groups := claims[groupsClaim].(type) {
+	case string:
+		id.Groups = []string{groups}
+	case []any:
+		for _, group := range groups {
+			if s, ok := group.(string); ok {
+				id.Groups = append(id.Groups, s)
+			}
+		}
+	}
+	return id, nil
+}
+
+// Allowed reports whether the email address of id is one of emails, compared
+// without case, or id is a member of one of groups
+func (id *Identity) Allowed(emails []string, groups []string) bool {
+	if slices.ContainsFunc(emails, func(email string) bool { return strings.EqualFold(email, id.Email) }) {
+		return true
+	}
+	return slices.ContainsFunc(id.Groups, func(group string) bool { return slices.Contains(groups, group) })
+}
@@ -0,0 +1,206 @@
+package sso
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+)
+
+// mockProvider is an OpenID provider that issues an ID token with the given
+// claims for every code, checking the PKCE verifier
+type mockProvider struct {
+	*httptest.Server
+	key    *rsa.PrivateKey
+	claims map[string]any
+	// Issue ID tokens for this client instead of the one asking, if set
+	audience string
+
+	mtx        sync.Mutex
+	challenges map[string]string // code → PKCE challenge
+	nonces     map[string]string // code → nonce
+}
+
+func newMockProvider(t *testing.T, claims map[string]any) *mockProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &mockProvider{key: key, claims: claims, challenges: map[string]string{}, nonces: map[string]string{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{
+			"issuer":                                m.URL,
+			"authorization_endpoint":                m.URL + "/authorize",
+			"token_endpoint":                        m.URL + "/token",
+			"jwks_uri":                              m.URL + "/jwks",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("GET /jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{
+			{Key: &key.PublicKey, KeyID: "test", Algorithm: "RS256", Use: "sig"},
+		}})
+	})
+	mux.HandleFunc("POST /token", func(w http.ResponseWriter, r *http.Request) {
+		code := r.FormValue("code")
+		m.mtx.Lock()
+		challenge, nonce := m.challenges[code], m.nonces[code]
+		m.mtx.Unlock()
+		sum := sha256.Sum256([]byte(r.FormValue("code_verifier")))
+		if challenge == "" || base64.RawURLEncoding.EncodeToString(sum[:]) != challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		clientID, _, ok := r.BasicAuth()
+		if !ok {
+			clientID = r.FormValue("client_id")
+		}
+		if m.audience != "" {
+			clientID = m.audience
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"access_token": "access",
+			"token_type":   "Bearer",
+			"expires_in":   3600,
+			"id_token":     m.idToken(t, clientID, nonce),
+		})
+	})
+	m.Server = httptest.NewServer(mux)
+	t.Cleanup(m.Close)
+	return m
+}
+
+func (m *mockProvider) idToken(t *testing.T, audience string, nonce string) string {
+	claims := map[string]any{
+		"iss":   m.URL,
+		"sub":   "user-1",
+		"aud":   audience,
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"iat":   time.Now().Unix(),
+		"nonce": nonce,
+	}
+	for k, v := range m.claims {
+		claims[k] = v
+	}
+	payload, _ := json.Marshal(claims)
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.RS256, Key: m.key},
+		(&jose.SignerOptions{}).WithType("JWT").WithHeader("kid", "test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed, err := signer.Sign(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := signed.CompactSerialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+// authorize plays the user logging in at the provider, and returns the code
+// it would send them back with
+func (m *mockProvider) authorize(t *testing.T, authURL string) string {
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+	if q.Get("code_challenge_method") != "S256" || q.Get("response_type") != "code" {
+		t.Fatalf("unexpected authorization request %s", authURL)
+	}
+	code := "code-" + q.Get("state")
+	m.mtx.Lock()
+	m.challenges[code] = q.Get("code_challenge")
+	m.nonces[code] = q.Get("nonce")
+	m.mtx.Unlock()
+	return code
+}
+
+func TestLogin(t *testing.T) {
+	m := newMockProvider(t, map[string]any{
+		"email":          "Jane@Example.org",
+		"email_verified": true,
+		"name":           "Jane Doe",
+		"groups":         []string{"staff", "blog-admins"},
+	})
+	cfg := Config{Issuer: m.URL, ClientID: "hubro", ClientSecret: "secret", RedirectURL: "http://hubro.test/admin/oidc/callback"}
+	c := NewClient()
+	ctx := context.Background()
+
+	authURL, flow, err := c.Start(ctx, cfg)
+	if err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	code := m.authorize(t, authURL)
+
+	if _, err := c.Finish(ctx, cfg, flow, "forged", code); !errors.Is(err, ErrState) {
+		t.Errorf("expected ErrState, got %v", err)
+	}
+	if _, err := c.Finish(ctx, cfg, Flow{State: flow.State, Nonce: flow.Nonce, Verifier: "wrong"}, flow.State, code); err == nil {
+		t.Error("expected a wrong PKCE verifier to fail")
+	}
+	if _, err := c.Finish(ctx, cfg, Flow{State: flow.State, Nonce: "other", Verifier: flow.Verifier}, flow.State, code); !errors.Is(err, ErrNonce) {
+		t.Errorf("expected ErrNonce, got %v", err)
+	}
+
+	id, err := c.Finish(ctx, cfg, flow, flow.State, code)
+	if err != nil {
+		t.Fatalf("finish: %v", err)
+	}
+	if id.Subject != "user-1" || id.Email != "Jane@Example.org" || id.Name != "Jane Doe" || len(id.Groups) != 2 {
+		t.Errorf("unexpected identity %+v", id)
+	}
+
+	if !id.Allowed([]string{"jane@example.org"}, nil) {
+		t.Error("expected email to be allowed regardless of case")
+	}
+	if !id.Allowed(nil, []string{"blog-admins"}) {
+		t.Error("expected group to be allowed")
+	}
+	if id.Allowed([]string{"john@example.org"}, []string{"admins"}) {
+		t.Error("expected identity not to be allowed")
+	}
+
+	// An ID token for another client is refused
+	m.audience = "other"
+	authURL, flow, _ = c.Start(ctx, cfg)
+	code = m.authorize(t, authURL)
+	if _, err := c.Finish(ctx, cfg, flow, flow.State, code); err == nil {
+		t.Error("expected an ID token for another audience to fail")
+	}
+}
+
+func TestIdentityClaims(t *testing.T) {
+	if _, err := identity("s", map[string]any{"email": "a@example.org", "email_verified": false}, ""); !errors.Is(err, ErrEmailNotVerified) {
+		t.Errorf("expected ErrEmailNotVerified, got %v", err)
+	}
+	if _, err := identity("s", map[string]any{"email": "a@example.org", "email_verified": "true"}, ""); !errors.Is(err, ErrEmailNotVerified) {
+		t.Errorf("expected ErrEmailNotVerified for a string claim, got %v", err)
+	}
+	if _, err := identity("s", map[string]any{"email": "a@example.org"}, ""); !errors.Is(err, ErrEmailNotVerified) {
+		t.Errorf("expected ErrEmailNotVerified without the claim, got %v", err)
+	}
+	if _, err := identity("s", map[string]any{}, ""); !errors.Is(err, ErrNoEmail) {
+		t.Errorf("expected ErrNoEmail, got %v", err)
+	}
+	id, err := identity("s", map[string]any{"email": "a@example.org", "email_verified": true, "roles": "editors"}, "roles")
+	if err != nil || !id.EmailVerified || len(id.Groups) != 1 || id.Groups[0] != "editors" {
+		t.Errorf("expected a single group from a custom claim, got %+v, %v", id, err)
+	}
+}
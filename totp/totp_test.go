@@ -0,0 +1,68 @@
+package totp
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The SHA-1 test vectors of RFC 6238, truncated to 6 digits
+func TestCode(t *testing.T) {
+	secret := base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, test := range tests {
+		got, err := Code(secret, time.Unix(test.unix, 0))
+		if err != nil || got != test.code {
+			t.Errorf("code at %d: got %q, %v, want %q", test.unix, got, err, test.code)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	secret := NewSecret()
+	now := time.Unix(1700000000, 0)
+	code, _ := Code(secret, now)
+
+	s, ok := Validate(secret, code, now)
+	if !ok || s != step(now) {
+		t.Errorf("expected current code to validate, got %d, %v", s, ok)
+	}
+	if _, ok := Validate(secret, code[:3]+" "+code[3:], now.Add(Period)); !ok {
+		t.Error("expected code from the previous step, with a space, to validate")
+	}
+	if _, ok := Validate(secret, code, now.Add(3*Period)); ok {
+		t.Error("expected old code to fail")
+	}
+	if _, ok := Validate(secret, "12345", now); ok {
+		t.Error("expected short code to fail")
+	}
+	if _, ok := Validate(strings.ToLower(secret), code, now); !ok {
+		t.Error("expected lower case secret to work")
+	}
+}
+
+func TestURIAndRecoveryCodes(t *testing.T) {
+	uri := URI("My Blog", "jane", "ABC")
+	if !strings.HasPrefix(uri, "otpauth://totp/My%20Blog:jane?") || !strings.Contains(uri, "secret=ABC") {
+		t.Errorf("unexpected URI %s", uri)
+	}
+	codes := NewRecoveryCodes(10)
+	seen := map[string]bool{}
+	for _, code := range codes {
+		if len(code) != 14 || seen[code] {
+			t.Errorf("bad or repeated recovery code %q", code)
+		}
+		seen[code] = true
+	}
+}
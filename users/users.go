@@ -0,0 +1,409 @@
+// Package users keeps the accounts that can log in to the admin interface
+package users
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sokkalf/hubro/utils"
+	"golang.org/x/crypto/bcrypt"
+	"gopkg.in/yaml.v2"
+)
+
+type Role string
+
+const (
+	// Authors can edit their own entries, but not publish them
+	RoleAuthor Role = "author"
+	// Editors can edit and publish all entries
+	RoleEditor Role = "editor"
+	// Admins can also manage users and reload the configuration
+	RoleAdmin Role = "admin"
+)
+
+var Roles = []Role{RoleAuthor, RoleEditor, RoleAdmin}
+
+const MinPasswordLength = 8
+
+var (
+	ErrNotFound     = errors.New("user not found")
+	ErrExists       = errors.New("user already exists")
+	ErrInvalidName  = errors.New("user names may only contain lower case letters, digits, '.', '-' and '_'")
+	ErrInvalidRole  = fmt.Errorf("role must be one of %v", Roles)
+	ErrWeakPassword = fmt.Errorf("password must be at least %d characters", MinPasswordLength)
+	ErrLastAdmin    = errors.New("there must be at least one admin")
+)
+
+var namePattern = regexp.MustCompile(`^[a-z0-9._-]{1,64}$`)
+
+type User struct {
+	Name         string `yaml:"name"`
+	DisplayName  string `yaml:"display_name,omitempty"`
+	Email        string `yaml:"email,omitempty"`
+	Role         Role   `yaml:"role"`
+	PasswordHash string `yaml:"password_hash"`
+	// The secret of the authenticator app, if two-factor authentication is enabled
+	TOTPSecret string `yaml:"totp_secret,omitempty"`
+	// SHA-256 hashes of the unused recovery codes
+	RecoveryCodes []string `yaml:"recovery_codes,omitempty"`
+}
+
+// Author returns the name the user writes as, matched against the author
+// front matter of entries
+func (u *User) Author() string {
+	if u.DisplayName != "" {
+		return u.DisplayName
+	}
+	return u.Name
+}
+
+// IsAuthorOf reports whether author, from the front matter of an entry, is the user
+func (u *User) IsAuthorOf(author string) bool {
+	return author != "" && (utils.Slugify(author) == utils.Slugify(u.Author()) || utils.Slugify(author) == utils.Slugify(u.Name))
+}
+
+// HasTOTP reports whether the user has two-factor authentication enabled
+func (u *User) HasTOTP() bool {
+	return u.TOTPSecret != ""
+}
+
+// CanEdit reports whether the user may edit an entry by author
+func (u *User) CanEdit(author string) bool {
+	return u.Role == RoleEditor || u.Role == RoleAdmin || u.IsAuthorOf(author)
+}
+
+// CanPublish reports whether the user may publish drafts
+func (u *User) CanPublish() bool {
+	return u.Role == RoleEditor || u.Role == RoleAdmin
+}
+
+// CanManageUsers reports whether the user may add, change and remove users
+func (u *User) CanManageUsers() bool {
+	return u.Role == RoleAdmin
+}
+
+func ValidRole(role Role) bool {
+	return slices.Contains(Roles, role)
+}
+
+// Store keeps users in a YAML file. Changes made to the file by another
+// process, such as the users command, are picked up on the next lookup.
+type Store struct {
+	path    string
+	mtx     sync.Mutex
+	users   []User
+	modTime time.Time
+	size    int64
+}
+
+type file struct {
+	Users []User `yaml:"users"`
+}
+
+// Open reads the users from path. The file doesn't have to exist, it is
+// created when the first user is added.
+func Open(path string) (*Store, error) {
+	s := &Store{path: path}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// load reads the file again if it changed
+func (s *Store) load() error {
+	fi, err := os.Stat(s.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		s.users = nil
+		s.modTime, s.size = time.Time{}, 0
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if fi.ModTime().Equal(s.modTime) && fi.Size() == s.size && s.users != nil {
+		return nil
+	}
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return err
+	}
+	var f file
+	if err := yaml.UnmarshalStrict(data, &f); err != nil {
+		return fmt.Errorf("parsing users file %s: %w", s.path, err)
+	}
+	s.users = f.Users
+	if s.users == nil {
+		s.users = []User{}
+	}
+	s.modTime, s.size = fi.ModTime(), fi.Size()
+	return nil
+}
+
+// save writes the users through a temporary file, readable only by the owner
+func (s *Store) save() error {
+	data, err := yaml.Marshal(file{Users: s.users})
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), ".users-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return err
+	}
+	if fi, err := os.Stat(s.path); err == nil {
+		s.modTime, s.size = fi.ModTime(), fi.Size()
+	}
+	return nil
+}
+
+// update loads the users, calls f to change them, and saves them if f succeeds
+func (s *Store) update(f func() error) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if err := s.load(); err != nil {
+		return err
+	}
+	if err := f(); err != nil {
+		return err
+	}
+	return s.save()
+}
+
+func (s *Store) find(name string) int {
+	return slices.IndexFunc(s.users, func(u User) bool { return u.Name == name })
+}
+
+// List returns all users, sorted by name
+func (s *Store) List() ([]User, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	users := slices.Clone(s.users)
+	slices.SortFunc(users, func(a, b User) int { return strings.Compare(a.Name, b.Name) })
+	return users, nil
+}
+
+// Empty reports whether there are no users yet
+func (s *Store) Empty() bool {
+	users, err := s.List()
+	return err == nil && len(users) == 0
+}
+
+func (s *Store) Get(name string) (*User, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	i := s.find(name)
+	if i < 0 {
+		return nil, ErrNotFound
+	}
+	u := s.users[i]
+	return &u, nil
+}
+
+// GetByEmail returns the user with an email address, compared without case
+func (s *Store) GetByEmail(email string) (*User, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	i := slices.IndexFunc(s.users, func(u User) bool { return u.Email != "" && strings.EqualFold(u.Email, email) })
+	if i < 0 {
+		return nil, ErrNotFound
+	}
+	u := s.users[i]
+	return &u, nil
+}
+
+func hashPassword(password string) (string, error) {
+	if len(password) < MinPasswordLength {
+		return "", ErrWeakPassword
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	return string(hash), err
+}
+
+// Add creates a user with a password
+func (s *Store) Add(user User, password string) error {
+	if !namePattern.MatchString(user.Name) {
+		return ErrInvalidName
+	}
+	if !ValidRole(user.Role) {
+		return ErrInvalidRole
+	}
+	hash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+	user.PasswordHash = hash
+	return s.update(func() error {
+		if s.find(user.Name) >= 0 {
+			return ErrExists
+		}
+		s.users = append(s.users, user)
+		return nil
+	})
+}
+
+func (s *Store) SetPassword(name string, password string) error {
+	hash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+	return s.update(func() error {
+		i := s.find(name)
+		if i < 0 {
+			return ErrNotFound
+		}
+		s.users[i].PasswordHash = hash
+		return nil
+	})
+}
+
+func hashRecoveryCode(code string) string {
+	sum := sha256.Sum256([]byte(strings.ToLower(strings.TrimSpace(code))))
+	return hex.EncodeToString(sum[:])
+}
+
+// EnableTOTP turns on two-factor authentication for a user, replacing any
+// earlier secret and recovery codes
+func (s *Store) EnableTOTP(name string, secret string, recoveryCodes []string) error {
+	hashes := make([]string, len(recoveryCodes))
+	for i, code := range recoveryCodes {
+		hashes[i] = hashRecoveryCode(code)
+	}
+	return s.update(func() error {
+		i := s.find(name)
+		if i < 0 {
+			return ErrNotFound
+		}
+		s.users[i].TOTPSecret = secret
+		s.users[i].RecoveryCodes = hashes
+		return nil
+	})
+}
+
+func (s *Store) DisableTOTP(name string) error {
+	return s.update(func() error {
+		i := s.find(name)
+		if i < 0 {
+			return ErrNotFound
+		}
+		s.users[i].TOTPSecret = ""
+		s.users[i].RecoveryCodes = nil
+		return nil
+	})
+}
+
+// UseRecoveryCode reports whether code is one of the unused recovery codes
+// of a user, and if so, removes it
+func (s *Store) UseRecoveryCode(name string, code string) bool {
+	hash := hashRecoveryCode(code)
+	used := false
+	err := s.update(func() error {
+		i := s.find(name)
+		if i < 0 {
+			return ErrNotFound
+		}
+		n := slices.IndexFunc(s.users[i].RecoveryCodes, func(h string) bool {
+			return subtle.ConstantTimeCompare([]byte(h), []byte(hash)) == 1
+		})
+		if n < 0 {
+			return ErrNotFound
+		}
+		s.users[i].RecoveryCodes = slices.Delete(s.users[i].RecoveryCodes, n, n+1)
+		used = true
+		return nil
+	})
+	return err == nil && used
+}
+
+// adminsWithout counts the admins, not counting the user at index skip
+func (s *Store) adminsWithout(skip int) int {
+	n := 0
+	for i, u := range s.users {
+		if i != skip && u.Role == RoleAdmin {
+			n++
+		}
+	}
+	return n
+}
+
+func (s *Store) SetRole(name string, role Role) error {
+	if !ValidRole(role) {
+		return ErrInvalidRole
+	}
+	return s.update(func() error {
+		i := s.find(name)
+		if i < 0 {
+			return ErrNotFound
+		}
+		if s.users[i].Role == RoleAdmin && role != RoleAdmin && s.adminsWithout(i) == 0 {
+			return ErrLastAdmin
+		}
+		s.users[i].Role = role
+		return nil
+	})
+}
+
+func (s *Store) Delete(name string) error {
+	return s.update(func() error {
+		i := s.find(name)
+		if i < 0 {
+			return ErrNotFound
+		}
+		if s.users[i].Role == RoleAdmin && s.adminsWithout(i) == 0 {
+			return ErrLastAdmin
+		}
+		s.users = slices.Delete(s.users, i, i+1)
+		return nil
+	})
+}
+
+// A hash compared against when the user doesn't exist, so that unknown users
+// take as long to reject as wrong passwords
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("not a password"), bcrypt.DefaultCost)
+
+// Authenticate returns the user if the password is correct
+func (s *Store) Authenticate(name string, password string) (*User, bool) {
+	user, err := s.Get(name)
+	if err != nil {
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return nil, false
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)) != nil {
+		return nil, false
+	}
+	return user, true
+}
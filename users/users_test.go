@@ -0,0 +1,154 @@
+package users
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users.yaml")
+	s, err := Open(path)
+	if err != nil {
+		t.Fatalf("open missing file: %v", err)
+	}
+	if !s.Empty() {
+		t.Fatal("expected no users")
+	}
+
+	if err := s.Add(User{Name: "alice", Role: RoleAdmin}, "correct horse"); err != nil {
+		t.Fatalf("add: %v", err)
+	}
+	if err := s.Add(User{Name: "alice", Role: RoleAdmin}, "correct horse"); !errors.Is(err, ErrExists) {
+		t.Errorf("expected ErrExists, got %v", err)
+	}
+	if err := s.Add(User{Name: "Bob Smith", Role: RoleAuthor}, "correct horse"); !errors.Is(err, ErrInvalidName) {
+		t.Errorf("expected ErrInvalidName, got %v", err)
+	}
+	if err := s.Add(User{Name: "bob", Role: "owner"}, "correct horse"); !errors.Is(err, ErrInvalidRole) {
+		t.Errorf("expected ErrInvalidRole, got %v", err)
+	}
+	if err := s.Add(User{Name: "bob", Role: RoleAuthor}, "short"); !errors.Is(err, ErrWeakPassword) {
+		t.Errorf("expected ErrWeakPassword, got %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "correct horse") {
+		t.Error("password stored in plain text")
+	}
+	if fi, _ := os.Stat(path); fi.Mode().Perm() != 0600 {
+		t.Errorf("expected mode 0600, got %v", fi.Mode().Perm())
+	}
+
+	if _, ok := s.Authenticate("alice", "correct horse"); !ok {
+		t.Error("expected correct password to authenticate")
+	}
+	if _, ok := s.Authenticate("alice", "wrong horse"); ok {
+		t.Error("expected wrong password to fail")
+	}
+	if _, ok := s.Authenticate("nobody", "correct horse"); ok {
+		t.Error("expected unknown user to fail")
+	}
+
+	// Changes made by another process are picked up
+	other, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := other.Add(User{Name: "bob", Role: RoleAuthor}, "battery staple"); err != nil {
+		t.Fatalf("add from other store: %v", err)
+	}
+	if err := other.SetPassword("alice", "new password"); err != nil {
+		t.Fatalf("set password: %v", err)
+	}
+	if _, ok := s.Authenticate("bob", "battery staple"); !ok {
+		t.Error("expected user added by other store to authenticate")
+	}
+	if _, ok := s.Authenticate("alice", "new password"); !ok {
+		t.Error("expected changed password to authenticate")
+	}
+
+	if err := s.SetRole("alice", RoleEditor); !errors.Is(err, ErrLastAdmin) {
+		t.Errorf("expected ErrLastAdmin when demoting, got %v", err)
+	}
+	if err := s.Delete("alice"); !errors.Is(err, ErrLastAdmin) {
+		t.Errorf("expected ErrLastAdmin when deleting, got %v", err)
+	}
+	if err := s.SetRole("bob", RoleEditor); err != nil {
+		t.Errorf("set role: %v", err)
+	}
+	if err := s.Delete("bob"); err != nil {
+		t.Errorf("delete: %v", err)
+	}
+	if _, err := s.Get("bob"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound after delete, got %v", err)
+	}
+}
+
+func TestTOTP(t *testing.T) {
+	s, err := Open(filepath.Join(t.TempDir(), "users.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Add(User{Name: "alice", Role: RoleAdmin}, "correct horse"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.EnableTOTP("alice", "SECRET", []string{"aaaa-bbbb-cccc", "dddd-eeee-ffff"}); err != nil {
+		t.Fatalf("enable: %v", err)
+	}
+	if u, _ := s.Get("alice"); !u.HasTOTP() || slices.Contains(u.RecoveryCodes, "aaaa-bbbb-cccc") {
+		t.Errorf("expected TOTP with hashed recovery codes, got %+v", u)
+	}
+	if !s.UseRecoveryCode("alice", " AAAA-BBBB-CCCC ") {
+		t.Error("expected recovery code to work")
+	}
+	if s.UseRecoveryCode("alice", "aaaa-bbbb-cccc") {
+		t.Error("expected recovery code to work only once")
+	}
+	if s.UseRecoveryCode("alice", "zzzz-zzzz-zzzz") {
+		t.Error("expected unknown recovery code to fail")
+	}
+	if err := s.DisableTOTP("alice"); err != nil {
+		t.Fatalf("disable: %v", err)
+	}
+	if u, _ := s.Get("alice"); u.HasTOTP() || len(u.RecoveryCodes) != 0 {
+		t.Errorf("expected TOTP to be disabled, got %+v", u)
+	}
+}
+
+func TestPermissions(t *testing.T) {
+	author := &User{Name: "alice", DisplayName: "Alice Liddell", Role: RoleAuthor}
+	editor := &User{Name: "bob", Role: RoleEditor}
+	admin := &User{Name: "carol", Role: RoleAdmin}
+
+	tests := []struct {
+		user                  *User
+		entryAuthor           string
+		edit, publish, manage bool
+	}{
+		{author, "Alice Liddell", true, false, false},
+		{author, "alice", true, false, false},
+		{author, "alice-liddell", true, false, false},
+		{author, "Bob", false, false, false},
+		{author, "", false, false, false},
+		{editor, "Alice Liddell", true, true, false},
+		{admin, "", true, true, true},
+	}
+	for _, test := range tests {
+		if got := test.user.CanEdit(test.entryAuthor); got != test.edit {
+			t.Errorf("%s editing entry by %q: got %v, want %v", test.user.Name, test.entryAuthor, got, test.edit)
+		}
+		if got := test.user.CanPublish(); got != test.publish {
+			t.Errorf("%s publishing: got %v, want %v", test.user.Name, got, test.publish)
+		}
+		if got := test.user.CanManageUsers(); got != test.manage {
+			t.Errorf("%s managing users: got %v, want %v", test.user.Name, got, test.manage)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/sokkalf/hubro/config"
+	"github.com/sokkalf/hubro/users"
+	"golang.org/x/term"
+)
+
+const usersUsage = `Usage: %[1]s users <command> [-f file] [-site name] ...
+
+Commands:
+  list                                         list the users
+  add [-role role] [-display-name name] [-email email] <name>
+                                               add a user, an author by default
+  passwd <name>                                set the password of a user
+  role <name> <role>                           change the role of a user
+  delete <name>                                remove a user
+  reset-2fa <name>                             turn off two-factor authentication, for a lost device
+
+Roles are author, editor and admin. Passwords are read from the terminal, or
+from standard input if it isn't one.
+`
+
+// runUsers implements the users subcommand, which manages the admin users
+func runUsers(args []string) int {
+	usage := func() {
+		fmt.Fprintf(os.Stderr, usersUsage, os.Args[0])
+	}
+	if len(args) == 0 {
+		usage()
+		return 2
+	}
+	command := args[0]
+	flags := flag.NewFlagSet("users "+command, flag.ExitOnError)
+	file := flags.String("f", "", "users file (default: users_file from the configuration)")
+	site := flags.String("site", "", "name of the site whose users file to use, when several are configured")
+	role := flags.String("role", string(users.RoleAuthor), "role of the new user")
+	displayName := flags.String("display-name", "", "name the new user writes as, matched against the author of entries")
+	email := flags.String("email", "", "email address of the new user")
+	flags.Usage = func() {
+		usage()
+		flags.PrintDefaults()
+	}
+	flags.Parse(args[1:])
+
+	if *file == "" {
+		c, err := loadUsersConfig()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error loading configuration: %v\n", err)
+			return 1
+		}
+		if sites := c.SiteNames(); *site != "" && !slices.Contains(sites, *site) {
+			fmt.Fprintf(os.Stderr, "Unknown site %q, configured sites are: %s\n", *site, strings.Join(sites, ", "))
+			return 1
+		}
+		*file = c.Site(*site).UsersFile
+	}
+	store, err := users.Open(*file)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading users: %v\n", err)
+		return 1
+	}
+
+	args = flags.Args()
+	switch {
+	case command == "list" && len(args) == 0:
+		err = listUsers(store)
+	case command == "add" && len(args) == 1:
+		var password string
+		if password, err = readPassword(); err == nil {
+			user := users.User{Name: args[0], DisplayName: *displayName, Email: *email, Role: users.Role(*role)}
+			err = store.Add(user, password)
+		}
+	case command == "passwd" && len(args) == 1:
+		var password string
+		if password, err = readPassword(); err == nil {
+			err = store.SetPassword(args[0], password)
+		}
+	case command == "role" && len(args) == 2:
+		err = store.SetRole(args[0], users.Role(args[1]))
+	case command == "delete" && len(args) == 1:
+		err = store.Delete(args[0])
+	case command == "reset-2fa" && len(args) == 1:
+		err = store.DisableTOTP(args[0])
+	default:
+		usage()
+		return 2
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// loadUsersConfig loads the configuration, which may only be invalid because
+// there are no users yet to log in as
+func loadUsersConfig() (*config.HubroConfig, error) {
+	c, err := config.Load(config.ConfigFile())
+	var joined interface{ Unwrap() []error }
+	if err == nil || !errors.As(err, &joined) {
+		return c, err
+	}
+	errs := slices.DeleteFunc(joined.Unwrap(), func(err error) bool {
+		var settingErr *config.SettingError
+		return errors.As(err, &settingErr) && strings.HasSuffix(settingErr.Setting, "admin_password")
+	})
+	return c, errors.Join(errs...)
+}
+
+func listUsers(store *users.Store) error {
+	list, err := store.List()
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tROLE\tDISPLAY NAME\tEMAIL\t2FA")
+	for _, user := range list {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%v\n", user.Name, user.Role, user.DisplayName, user.Email, user.HasTOTP())
+	}
+	return w.Flush()
+}
+
+// readPassword asks for a password twice on a terminal, or reads one line
+// from standard input, so that it can be piped in
+func readPassword() (string, error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			return "", errors.New("no password on standard input")
+		}
+		return strings.TrimRight(line, "\r\n"), nil
+	}
+	ask := func(prompt string) (string, error) {
+		fmt.Fprint(os.Stderr, prompt)
+		password, err := term.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		return string(password), err
+	}
+	password, err := ask("Password: ")
+	if err != nil {
+		return "", err
+	}
+	again, err := ask("Repeat password: ")
+	if err != nil {
+		return "", err
+	}
+	if password != again {
+		return "", errors.New("the passwords don't match")
+	}
+	return password, nil
+}
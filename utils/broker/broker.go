@@ -46,16 +46,27 @@ func (b *Broker[T]) Stop() {
 	close(b.stopCh)
 }
 
+// Subscribe, Unsubscribe and Publish do nothing once the broker is stopped,
+// instead of blocking forever
 func (b *Broker[T]) Subscribe() chan T {
 	msgCh := make(chan T, 5)
-	b.subCh <- msgCh
+	select {
+	case b.subCh <- msgCh:
+	case <-b.stopCh:
+	}
 	return msgCh
 }
 
 func (b *Broker[T]) Unsubscribe(msgCh chan T) {
-	b.unsubCh <- msgCh
+	select {
+	case b.unsubCh <- msgCh:
+	case <-b.stopCh:
+	}
 }
 
 func (b *Broker[T]) Publish(msg T) {
-	b.publishCh <- msg
+	select {
+	case b.publishCh <- msg:
+	case <-b.stopCh:
+	}
 }
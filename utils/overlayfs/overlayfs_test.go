@@ -0,0 +1,45 @@
+package overlayfs
+
+import (
+	"io/fs"
+	"testing"
+	"testing/fstest"
+)
+
+// TestOverlay checks that upper layers shadow lower ones, and that directories are merged.
+func TestOverlay(t *testing.T) {
+	theme := fstest.MapFS{
+		"app.gohtml":          {Data: []byte("theme app")},
+		"partials/nav.gohtml": {Data: []byte("theme nav")},
+	}
+	builtin := fstest.MapFS{
+		"app.gohtml":             {Data: []byte("builtin app")},
+		"partials/nav.gohtml":    {Data: []byte("builtin nav")},
+		"partials/footer.gohtml": {Data: []byte("builtin footer")},
+		"errors/layout.gohtml":   {Data: []byte("builtin errors")},
+	}
+	o := New(theme, builtin)
+
+	for name, want := range map[string]string{
+		"app.gohtml":             "theme app",
+		"partials/nav.gohtml":    "theme nav",
+		"partials/footer.gohtml": "builtin footer",
+		"errors/layout.gohtml":   "builtin errors",
+	} {
+		got, err := fs.ReadFile(o, name)
+		if err != nil || string(got) != want {
+			t.Errorf("%s: expected %q, got %q (%v)", name, want, got, err)
+		}
+	}
+	if _, err := o.Open("missing.gohtml"); err == nil {
+		t.Errorf("expected error opening missing file")
+	}
+
+	entries, err := fs.ReadDir(o, "partials")
+	if err != nil || len(entries) != 2 || entries[0].Name() != "footer.gohtml" {
+		t.Errorf("unexpected merged directory: %v (%v)", entries, err)
+	}
+	if err := fstest.TestFS(o, "app.gohtml", "partials/nav.gohtml", "partials/footer.gohtml", "errors/layout.gohtml"); err != nil {
+		t.Error(err)
+	}
+}
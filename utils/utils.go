@@ -10,12 +10,16 @@ func Slugify(s string) string {
 	return slug.Make(s)
 }
 
+var dateFormats = []string{"2006-01-02", time.RFC3339, "2006-01-02 15:04:05", "2006-01-02 15:04"}
+
+// ParseDate parses a front matter date, optionally including a time of day
 func ParseDate(date string) time.Time {
-	t, err := time.Parse("2006-01-02", date)
-	if err != nil {
-		return time.Time{}
+	for _, format := range dateFormats {
+		if t, err := time.Parse(format, date); err == nil {
+			return t
+		}
 	}
-	return t
+	return time.Time{}
 }
 
 func Map[A any, B any](f func(A) B, arr []A) []B {
@@ -1,29 +1,42 @@
 package watchfs
 
 import (
+	"context"
+	"errors"
 	"io/fs"
 	"log/slog"
 	"os"
+	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/fsnotify/fsnotify"
-	"github.com/sokkalf/hubro/index"
 )
 
 const debounceDuration = 500 * time.Millisecond
 
-func WatchFS(dir string, idx *index.Index) (*fs.FS, error) {
+// Watch calls onChange when files in dir or its subdirectories are written,
+// created or removed. Bursts of changes are debounced into a single call.
+// The watcher is closed when ctx is done.
+func Watch(ctx context.Context, dir string, onChange func()) error {
 	fsys := os.DirFS(dir)
 	watcher, err := fsnotify.NewWatcher()
 	if err != nil {
-		return nil, err
+		return err
 	}
 
 	if err := watcher.Add(dir); err != nil {
-		return nil, err
+		watcher.Close()
+		return err
 	}
-	// Subdirectories
+	// Subdirectories, except hidden ones such as .git
 	err = fs.WalkDir(fsys, ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && path != "." && strings.HasPrefix(d.Name(), ".") {
+			return fs.SkipDir
+		}
 		if d.IsDir() && path != "." {
 			if err := watcher.Add(dir + "/" + path); err != nil {
 				return err
@@ -42,15 +55,24 @@ func WatchFS(dir string, idx *index.Index) (*fs.FS, error) {
 
 		for {
 			select {
+			case <-ctx.Done():
+				if timer != nil {
+					timer.Stop()
+				}
+				watcher.Close()
+				return
 			case event := <-watcher.Events:
+				// Hidden files are temporary files, or metadata such as .git
+				if strings.HasPrefix(filepath.Base(event.Name), ".") {
+					continue
+				}
 				if event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Remove) != 0 {
 					fileInfo, err := os.Stat(event.Name)
-					if err != nil && !event.Has(fsnotify.Remove) {
+					if err != nil && !errors.Is(err, fs.ErrNotExist) {
 						slog.Error("Error getting file info", "error", err)
 						continue
-					} else if err != nil && event.Has(fsnotify.Remove) {
-						// If the file was removed, we don't need to do anything
 					}
+					// A file that no longer exists was removed, maybe right after being created
 					if err == nil && fileInfo.IsDir() {
 						slog.Info("Watching new directory", "directory", event.Name)
 						watcher.Add(event.Name)
@@ -61,7 +83,10 @@ func WatchFS(dir string, idx *index.Index) (*fs.FS, error) {
 						timer.Stop()
 					}
 					timer = time.AfterFunc(debounceDuration, func() {
-						trigger <- struct{}{}
+						select {
+						case trigger <- struct{}{}:
+						case <-ctx.Done():
+						}
 					})
 				}
 			case err := <-watcher.Errors:
@@ -73,11 +98,15 @@ func WatchFS(dir string, idx *index.Index) (*fs.FS, error) {
 	}()
 
 	go func() {
-		for range trigger {
-			slog.Info("Starting directory scan")
-			idx.MsgBroker.Publish(index.Scanned)
+		for {
+			select {
+			case <-trigger:
+				onChange()
+			case <-ctx.Done():
+				return
+			}
 		}
 	}()
 
-	return &fsys, nil
+	return nil
 }
@@ -269,3 +269,33 @@ window.createPage = function(title, idx) {
 	const ws = window.ws;
 	ws.send(JSON.stringify({ type: 'create', title: title, index: idx }));
 }
+
+function csrfToken() {
+	const meta = document.querySelector('meta[name="csrf-token"]');
+	return meta ? meta.content : '';
+}
+
+// Errors are JSON from the media endpoints, and HTML from the login check
+async function mediaResponse(resp) {
+	const data = await resp.json().catch(() => ({}));
+	if (!resp.ok) {
+		throw new Error(data.error || resp.statusText);
+	}
+	return data;
+}
+
+window.listMedia = async function(url) {
+	const resp = await fetch(url, { headers: { 'Accept': 'application/json' } });
+	return mediaResponse(resp);
+}
+
+window.uploadMedia = async function(url, file) {
+	const body = new FormData();
+	body.append('file', file);
+	const resp = await fetch(url, {
+		method: 'POST',
+		body: body,
+		headers: { 'Accept': 'application/json', 'X-CSRF-Token': csrfToken() },
+	});
+	return mediaResponse(resp);
+}
@@ -0,0 +1,10 @@
+// Package view holds the built-in layouts, templates and assets, compiled into the binary.
+package view
+
+import "embed"
+
+// FS contains the default view directories. The static directory holds the
+// compiled app.css and app.js, so they must be built before the binary.
+//
+//go:embed all:layouts all:templates all:public all:static assets/vendor
+var FS embed.FS